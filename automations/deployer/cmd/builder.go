@@ -0,0 +1,437 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"deployer/pkg/registry"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// BuildSecret is a build secret exposed to RUN --mount=type=secret,id=<id>, read from a file or an environment variable.
+type BuildSecret struct {
+	ID  string `yaml:"id"`
+	Src string `yaml:"src"`
+	Env string `yaml:"env"`
+}
+
+// BuildConfig is the build section of deploy.yaml.
+type BuildConfig struct {
+	// Builder is one of docker, buildx, podman or buildah, docker by default
+	Builder    string            `yaml:"builder"`
+	Dockerfile string            `yaml:"dockerfile"`
+	Platforms  []string          `yaml:"platforms"`
+	Args       map[string]string `yaml:"args"`
+	Target     string            `yaml:"target"`
+	Secrets    []BuildSecret     `yaml:"secrets"`
+	CacheFrom  []string          `yaml:"cacheFrom"`
+	CacheTo    []string          `yaml:"cacheTo"`
+	SizeBudget SizeBudget        `yaml:"sizeBudget"`
+}
+
+// BuiltImage is an image produced by a build, scanned before it is pushed. A multi-platform
+// build produces one image per platform.
+type BuiltImage struct {
+	// Platform is the platform of the image, empty for single platform builds
+	Platform string
+	// Name is the name of the local image, or the path of its OCI archive when Archive is set
+	Name    string
+	Archive bool
+	// Engine is the CLI holding the local image: docker or podman
+	Engine string
+	// Digest is the digest of the manifest that is pushed, when it is known before the push
+	Digest string
+	// Size is the size report of archived images, local images are inspected with Engine
+	Size *ImageSize
+}
+
+// ImageSize returns the size report of the image.
+func (i BuiltImage) ImageSize() (ImageSize, error) {
+	if i.Size != nil {
+		return *i.Size, nil
+	}
+	return InspectImageSize(i.Engine, i.Name)
+}
+
+// PlatformFile returns the name of the release artifact of the platform, e.g.
+// sbom.linux-arm64.cdx.json, or the name itself for single platform builds.
+func PlatformFile(name string, platform string) string {
+	if platform == "" {
+		return name
+	}
+	dir, base := filepath.Split(name)
+	i := strings.Index(base, ".")
+	return dir + base[:i] + "." + strings.ReplaceAll(platform, "/", "-") + base[i:]
+}
+
+// PushedPlatforms returns the manifest pushed for every built image, by platform. When the
+// digests of the built images are known, the pushed image index must reference exactly them,
+// so that no platform is pushed without having been scanned.
+func PushedPlatforms(client *registry.Client, imageRepo string, image registry.Descriptor, images []BuiltImage) (map[string]registry.Descriptor, error) {
+	if len(images) == 1 && images[0].Digest == "" {
+		return map[string]registry.Descriptor{images[0].Platform: image}, nil
+	}
+	index, _, err := client.GetImageManifest(imageRepo, image.Digest)
+	if err != nil {
+		return nil, err
+	}
+	pushed := map[string]registry.Descriptor{}
+	for _, desc := range index.Manifests {
+		if desc.Platform != nil && desc.Platform.OS != "unknown" {
+			pushed[desc.Digest] = desc
+		}
+	}
+	subjects := map[string]registry.Descriptor{}
+	for _, built := range images {
+		desc, ok := pushed[built.Digest]
+		if built.Digest == "" || !ok {
+			return nil, errors.Errorf("the %s image scanned for %s was not pushed in %s@%s", built.Platform, built.Name, imageRepo, image.Digest)
+		}
+		subjects[built.Platform] = desc
+		delete(pushed, built.Digest)
+	}
+	for digest := range pushed {
+		return nil, errors.Errorf("%s@%s references the image %s that was not scanned", imageRepo, image.Digest, digest)
+	}
+	return subjects, nil
+}
+
+// ImageBuilder builds and pushes the image of an application.
+type ImageBuilder interface {
+	// Name returns the name of the builder as used in deploy.yaml
+	Name() string
+	// Build builds the image and returns the images to scan before the push
+	Build(appDir string, imageName string) ([]BuiltImage, error)
+	// Push pushes the built image, for every configured platform, to the registry
+	Push(client *registry.Client, imageName string) error
+	// Close removes what the build left on disk
+	Close() error
+}
+
+// NewImageBuilder returns the builder selected by name, defaulting to docker.
+func NewImageBuilder(name string, config BuildConfig) (ImageBuilder, error) {
+	if name == "" {
+		name = config.Builder
+	}
+	switch name {
+	case "", "docker":
+		if len(config.Platforms) > 1 {
+			return nil, errors.New("the docker builder does not support multiple platforms, use buildx")
+		}
+		return &dockerBuilder{config: config}, nil
+	case "buildx":
+		return &buildxBuilder{config: config}, nil
+	case "podman", "buildah":
+		if len(config.Platforms) > 1 {
+			return nil, errors.Errorf("the %s builder does not support multiple platforms, use buildx", name)
+		}
+		return &podmanBuilder{config: config, tool: name}, nil
+	}
+	return nil, errors.Errorf("unknown builder %s, expected docker, buildx, podman or buildah", name)
+}
+
+// dockerfilePath returns the Dockerfile of the application.
+func (c BuildConfig) dockerfilePath(appDir string) string {
+	if c.Dockerfile == "" {
+		return filepath.Join(appDir, "Dockerfile")
+	}
+	return filepath.Join(appDir, c.Dockerfile)
+}
+
+// commonArgs returns the build arguments shared by every builder: Dockerfile, build args and target.
+func (c BuildConfig) commonArgs(appDir string, imageName string) []string {
+	args := []string{"-t", imageName, "-f", c.dockerfilePath(appDir)}
+	keys := make([]string, 0, len(c.Args))
+	for key := range c.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--build-arg", fmt.Sprintf("%s=%s", key, c.Args[key]))
+	}
+	if c.Target != "" {
+		args = append(args, "--target", c.Target)
+	}
+	return args
+}
+
+// secretArgs returns the --secret flags. Secrets read from environment variables are written
+// to temporary files in memory, which are removed by the returned cleanup function.
+func (c BuildConfig) secretArgs() ([]string, func(), error) {
+	var args []string
+	var tmpFiles []string
+	cleanup := func() {
+		for _, file := range tmpFiles {
+			os.Remove(file)
+		}
+	}
+	for _, secret := range c.Secrets {
+		src := secret.Src
+		if secret.Env != "" {
+			value, ok := os.LookupEnv(secret.Env)
+			if !ok {
+				cleanup()
+				return nil, nil, errors.Errorf("environment variable %s of build secret %s not set", secret.Env, secret.ID)
+			}
+			src = filepath.Join("/dev/shm", fmt.Sprintf("%s.secret", uuid.New()))
+			// NOTE: Only the original file owner can read/write the file
+			if err := os.WriteFile(src, []byte(value), 0600); err != nil {
+				cleanup()
+				return nil, nil, errors.Wrapf(err, "failed to write build secret %s", secret.ID)
+			}
+			tmpFiles = append(tmpFiles, src)
+		}
+		if secret.ID == "" || src == "" {
+			cleanup()
+			return nil, nil, errors.Errorf("build secret %q needs an id and either src or env", secret.ID)
+		}
+		args = append(args, "--secret", fmt.Sprintf("id=%s,src=%s", secret.ID, src))
+	}
+	return args, cleanup, nil
+}
+
+func cacheArgs(cacheFrom []string, cacheTo []string) []string {
+	var args []string
+	for _, cache := range cacheFrom {
+		args = append(args, "--cache-from", cache)
+	}
+	for _, cache := range cacheTo {
+		args = append(args, "--cache-to", cache)
+	}
+	return args
+}
+
+// hostPlatform returns the platform of the machine running the build.
+func hostPlatform() string {
+	return fmt.Sprintf("linux/%s", runtime.GOARCH)
+}
+
+// dockerBuilder builds with "docker build" using BuildKit.
+type dockerBuilder struct {
+	config BuildConfig
+}
+
+func (b *dockerBuilder) Name() string { return "docker" }
+func (b *dockerBuilder) Close() error { return nil }
+
+func (b *dockerBuilder) Build(appDir string, imageName string) ([]BuiltImage, error) {
+	secrets, cleanup, err := b.config.secretArgs()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	args := append([]string{"build"}, b.config.commonArgs(appDir, imageName)...)
+	args = append(args, secrets...)
+	for _, cache := range b.config.CacheFrom {
+		args = append(args, "--cache-from", cache)
+	}
+	if len(b.config.CacheTo) > 0 {
+		return nil, errors.New("cacheTo requires the buildx builder")
+	}
+	if len(b.config.Platforms) == 1 {
+		args = append(args, "--platform", b.config.Platforms[0])
+	}
+	args = append(args, appDir)
+	cmd := exec.Command("docker", args...)
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	if _, err := ExecuteCommand(cmd); err != nil {
+		return nil, errors.Wrap(err, "failed to build Docker image")
+	}
+	return []BuiltImage{{Name: imageName, Engine: "docker"}}, nil
+}
+
+func (b *dockerBuilder) Push(client *registry.Client, imageName string) error {
+	if _, err := ExecuteCommand(exec.Command("docker", "push", imageName)); err != nil {
+		return errors.Wrap(err, "failed to push Docker image")
+	}
+	return nil
+}
+
+// buildxBuilder builds with "docker buildx build". A single platform is loaded in the local
+// images. Multiple platforms are built once into an OCI layout: every platform is scanned from
+// it and the same manifests and blobs are pushed, so the pushed image is the scanned one.
+type buildxBuilder struct {
+	config BuildConfig
+	// layout holds the multi-platform build
+	layout *registry.Layout
+}
+
+func (b *buildxBuilder) Name() string { return "buildx" }
+
+func (b *buildxBuilder) Close() error {
+	if b.layout == nil {
+		return nil
+	}
+	return os.RemoveAll(filepath.Dir(b.layout.Dir))
+}
+
+func (b *buildxBuilder) args(appDir string, imageName string, secrets []string) []string {
+	args := append([]string{"buildx", "build"}, b.config.commonArgs(appDir, imageName)...)
+	args = append(args, secrets...)
+	return append(args, cacheArgs(b.config.CacheFrom, b.config.CacheTo)...)
+}
+
+func (b *buildxBuilder) Build(appDir string, imageName string) ([]BuiltImage, error) {
+	secrets, cleanup, err := b.config.secretArgs()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	args := b.args(appDir, imageName, secrets)
+	if len(b.config.Platforms) <= 1 {
+		platform := hostPlatform()
+		if len(b.config.Platforms) == 1 {
+			platform = b.config.Platforms[0]
+		}
+		args = append(args, "--platform", platform, "--load", appDir)
+		if _, err := ExecuteCommand(exec.Command("docker", args...)); err != nil {
+			return nil, errors.Wrap(err, "failed to build image with buildx")
+		}
+		return []BuiltImage{{Name: imageName, Engine: "docker"}}, nil
+	}
+
+	workDir, err := os.MkdirTemp("", "deployer-build-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the build directory")
+	}
+	b.layout = &registry.Layout{Dir: filepath.Join(workDir, "layout")}
+	archive := filepath.Join(workDir, "image.tar")
+	args = append(args, "--platform", strings.Join(b.config.Platforms, ","), "--output", fmt.Sprintf("type=oci,dest=%s", archive), appDir)
+	if _, err := ExecuteCommand(exec.Command("docker", args...)); err != nil {
+		return nil, errors.Wrap(err, "failed to build multi-platform image with buildx")
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", archive)
+	}
+	err = b.layout.ExtractArchive(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(archive)
+	return PlatformArchives(*b.layout, imageName)
+}
+
+// PlatformArchives writes an OCI archive of every platform of the image index of the layout,
+// to be scanned, and returns the platform images with their sizes.
+func PlatformArchives(layout registry.Layout, imageName string) ([]BuiltImage, error) {
+	index, err := layout.Index()
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) != 1 {
+		return nil, errors.Errorf("expected a single image in %s, found %d", layout.Dir, len(index.Manifests))
+	}
+	body, err := layout.ReadBlob(index.Manifests[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	var imageIndex registry.Manifest
+	if err := json.Unmarshal(body, &imageIndex); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the image index of %s", layout.Dir)
+	}
+	var images []BuiltImage
+	for _, desc := range imageIndex.Manifests {
+		// Skip the attestation manifests buildx adds to the index
+		if desc.Platform == nil || desc.Platform.OS == "unknown" {
+			continue
+		}
+		platform := desc.Platform.String()
+		archive := filepath.Join(filepath.Dir(layout.Dir), strings.ReplaceAll(platform, "/", "-")+".tar")
+		file, err := os.Create(archive)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create %s", archive)
+		}
+		err = layout.WriteArchive(file, desc.Digest)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := LayoutImageSize(layout, desc.Digest, fmt.Sprintf("%s (%s)", imageName, platform))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, BuiltImage{Platform: platform, Name: archive, Archive: true, Digest: desc.Digest, Size: &size})
+	}
+	if len(images) == 0 {
+		return nil, errors.Errorf("the image index of %s has no platform images", layout.Dir)
+	}
+	return images, nil
+}
+
+func (b *buildxBuilder) Push(client *registry.Client, imageName string) error {
+	if b.layout == nil {
+		if _, err := ExecuteCommand(exec.Command("docker", "push", imageName)); err != nil {
+			return errors.Wrap(err, "failed to push Docker image")
+		}
+		return nil
+	}
+	ref, err := registry.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	index, err := b.layout.Index()
+	if err != nil {
+		return err
+	}
+	local, stop, err := registry.LayoutServer{Layouts: map[string]registry.Layout{ref.Repository: *b.layout}}.Serve()
+	if err != nil {
+		return err
+	}
+	defer stop()
+	if _, err := registry.CopyManifest(local, ref.Repository, index.Manifests[0].Digest, client, ref.Repository, ref.Tag); err != nil {
+		return errors.Wrapf(err, "failed to push multi-platform image %s", imageName)
+	}
+	return nil
+}
+
+// podmanBuilder builds with "podman build" or "buildah bud" for a single platform. Its
+// manifest lists are not exported per platform, so multiple platforms are left to buildx.
+type podmanBuilder struct {
+	config BuildConfig
+	tool   string
+}
+
+func (b *podmanBuilder) Name() string { return b.tool }
+func (b *podmanBuilder) Close() error { return nil }
+
+func (b *podmanBuilder) Build(appDir string, imageName string) ([]BuiltImage, error) {
+	secrets, cleanup, err := b.config.secretArgs()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	subcommand := "build"
+	if b.tool == "buildah" {
+		subcommand = "bud"
+	}
+	args := append([]string{subcommand, "--layers"}, b.config.commonArgs(appDir, imageName)...)
+	if len(b.config.Platforms) == 1 {
+		args = append(args, "--platform", b.config.Platforms[0])
+	}
+	args = append(args, secrets...)
+	args = append(args, cacheArgs(b.config.CacheFrom, b.config.CacheTo)...)
+	args = append(args, appDir)
+	if _, err := ExecuteCommand(exec.Command(b.tool, args...)); err != nil {
+		return nil, errors.Wrapf(err, "failed to build image with %s", b.tool)
+	}
+	return []BuiltImage{{Name: imageName, Engine: "podman"}}, nil
+}
+
+func (b *podmanBuilder) Push(client *registry.Client, imageName string) error {
+	if _, err := ExecuteCommand(exec.Command(b.tool, "push", imageName)); err != nil {
+		return errors.Wrapf(err, "failed to push image with %s", b.tool)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+)
+
+// writeJSON stores the value as a blob of the layout and returns its descriptor.
+func writeJSON(t *testing.T, layout registry.Layout, mediaType string, value interface{}) registry.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := layout.WriteBlob(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// writePlatformImage stores an image with a single gzipped layer of the content.
+func writePlatformImage(t *testing.T, layout registry.Layout, platform registry.Platform, content string) registry.Descriptor {
+	t.Helper()
+	var layer bytes.Buffer
+	writer := gzip.NewWriter(&layer)
+	writer.Write([]byte(content))
+	writer.Close()
+	layerDigest, err := layout.WriteBlob(layer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := writeJSON(t, layout, registry.MediaTypeOCIConfig, map[string]interface{}{
+		"architecture": platform.Architecture,
+		"os":           platform.OS,
+		"history":      []map[string]string{{"created_by": "COPY . ."}},
+	})
+	manifest := writeJSON(t, layout, registry.MediaTypeOCIManifest, registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        &config,
+		Layers: []registry.Descriptor{{
+			MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:    layerDigest,
+			Size:      int64(layer.Len()),
+		}},
+	})
+	manifest.Platform = &platform
+	return manifest
+}
+
+// writeBuildxLayout writes a layout like the OCI output of a multi-platform buildx build.
+func writeBuildxLayout(t *testing.T) (registry.Layout, []registry.Descriptor) {
+	t.Helper()
+	layout := registry.Layout{Dir: filepath.Join(t.TempDir(), "layout")}
+	platforms := []registry.Descriptor{
+		writePlatformImage(t, layout, registry.Platform{OS: "linux", Architecture: "amd64"}, "amd64 binary"),
+		writePlatformImage(t, layout, registry.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, "arm64 binary"),
+	}
+	attestation := writePlatformImage(t, layout, registry.Platform{OS: "unknown", Architecture: "unknown"}, "provenance")
+	index := writeJSON(t, layout, registry.MediaTypeOCIIndex, registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIIndex,
+		Manifests:     append(append([]registry.Descriptor{}, platforms...), attestation),
+	})
+	if err := layout.WriteIndex(registry.Manifest{SchemaVersion: 2, MediaType: registry.MediaTypeOCIIndex, Manifests: []registry.Descriptor{index}}); err != nil {
+		t.Fatal(err)
+	}
+	return layout, platforms
+}
+
+func TestPlatformFile(t *testing.T) {
+	for name, want := range map[string]string{
+		"sbom.cdx.json":                       "sbom.linux-arm64.cdx.json",
+		"/tmp/v1.2.3/trivy-report.json":       "/tmp/v1.2.3/trivy-report.linux-arm64.json",
+		"releases/1.0.0/vulnerabilities.json": "releases/1.0.0/vulnerabilities.linux-arm64.json",
+	} {
+		if got := PlatformFile(name, "linux/arm64"); got != want {
+			t.Errorf("PlatformFile(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if got := PlatformFile(sbomFile, ""); got != sbomFile {
+		t.Errorf("got %q for a single platform build, want %q", got, sbomFile)
+	}
+}
+
+func TestNewImageBuilderPlatforms(t *testing.T) {
+	multiPlatform := []string{"linux/amd64", "linux/arm64"}
+	for _, test := range []struct {
+		builder   string
+		platforms []string
+		wantErr   bool
+	}{
+		{"docker", []string{"linux/amd64"}, false},
+		{"docker", multiPlatform, true},
+		{"buildx", multiPlatform, false},
+		{"podman", []string{"linux/arm64"}, false},
+		{"podman", multiPlatform, true},
+		{"buildah", multiPlatform, true},
+	} {
+		_, err := NewImageBuilder(test.builder, BuildConfig{Platforms: test.platforms})
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s with %v: got %v, want error %v", test.builder, test.platforms, err, test.wantErr)
+		}
+	}
+}
+
+func TestPlatformArchives(t *testing.T) {
+	layout, platforms := writeBuildxLayout(t)
+	images, err := PlatformArchives(layout, "team/app:1.0.0")
+	if err != nil {
+		t.Fatalf("PlatformArchives: %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want one per platform without the attestation", len(images))
+	}
+	for i, platform := range []string{"linux/amd64", "linux/arm64/v8"} {
+		image := images[i]
+		if image.Platform != platform || image.Digest != platforms[i].Digest || !image.Archive {
+			t.Errorf("image %d: got %+v, want the %s archive of %s", i, image, platform, platforms[i].Digest)
+		}
+		if _, err := os.Stat(image.Name); err != nil {
+			t.Errorf("archive of %s: %v", platform, err)
+		}
+		if image.Size == nil || image.Size.Size != int64(len("amd64 binary")) {
+			t.Errorf("size of %s: got %+v, want the uncompressed layer size", platform, image.Size)
+		}
+	}
+}
+
+func TestBuildxPushAndPushedPlatforms(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+
+	layout, _ := writeBuildxLayout(t)
+	images, err := PlatformArchives(layout, "team/app:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := &buildxBuilder{layout: &layout}
+	if err := builder.Push(client, "localhost/team/app:1.0.0"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	image, err := client.HeadManifest("team/app", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjects, err := PushedPlatforms(client, "team/app", image, images)
+	if err != nil {
+		t.Fatalf("PushedPlatforms: %v", err)
+	}
+	for _, built := range images {
+		if subjects[built.Platform].Digest != built.Digest {
+			t.Errorf("%s: pushed %s, scanned %s", built.Platform, subjects[built.Platform].Digest, built.Digest)
+		}
+	}
+
+	// A platform that was not scanned must not be signed
+	if _, err := PushedPlatforms(client, "team/app", image, images[:1]); err == nil {
+		t.Error("expected an error for a pushed platform that was not scanned")
+	}
+	images[1].Digest = images[0].Digest
+	if _, err := PushedPlatforms(client, "team/app", image, images); err == nil {
+		t.Error("expected an error for a scanned platform that was not pushed")
+	}
+}
@@ -0,0 +1,768 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/signing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// bundleManifestFile describes the content of a bundle
+	bundleManifestFile = "bundle.yaml"
+	// bundleChecksumsFile lists the sha256 of every other file of a bundle
+	bundleChecksumsFile = "checksums.sha256"
+	// bundleFormatVersion is the version of the bundle layout
+	bundleFormatVersion = 1
+)
+
+// BundleImage is an image stored in the bundle as an OCI layout under images/<repository>.
+type BundleImage struct {
+	// Source is the reference the image is known by, e.g. docker.io/bitnami/mysql:8.0.35
+	Source string `yaml:"source"`
+	// Repository is the registry and repository of the image, the path of its layout
+	Repository string `yaml:"repository"`
+	// Reference is the name of the image in the layout, the tag or the digest
+	Reference string `yaml:"reference"`
+	Digest    string `yaml:"digest"`
+	// Artifacts are the signature, SBOM and attestation tags stored with the image
+	Artifacts []string `yaml:"artifacts,omitempty"`
+}
+
+// BundleApplication is an application deployed from the bundle at its pinned version.
+type BundleApplication struct {
+	Name    string      `yaml:"name"`
+	Version string      `yaml:"version"`
+	Image   BundleImage `yaml:"image"`
+}
+
+// BundleVendor is a vendor chart archive and the images it references.
+type BundleVendor struct {
+	Name string `yaml:"name"`
+	// Chart is the path of the chart archive in the bundle
+	Chart  string        `yaml:"chart"`
+	Images []BundleImage `yaml:"images"`
+}
+
+// BundleManifest is the content of bundle.yaml.
+type BundleManifest struct {
+	Version         int                 `yaml:"version"`
+	Created         time.Time           `yaml:"created"`
+	DeployerVersion string              `yaml:"deployerVersion"`
+	Environment     string              `yaml:"environment"`
+	Applications    []BundleApplication `yaml:"applications"`
+	Vendors         []BundleVendor      `yaml:"vendors"`
+}
+
+var (
+	bundleFile  string
+	kindCluster string
+	targetHost  string
+)
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd)
+	bundleCmd.AddCommand(bundleApplyCmd)
+
+	bundleCreateCmd.Flags().StringVar(&appsRoot, "applications_root", "", "the directory holding the applications")
+	bundleCreateCmd.MarkFlagRequired("applications_root")
+	bundleCreateCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	bundleCreateCmd.MarkFlagRequired("operations_directory")
+	bundleCreateCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	bundleCreateCmd.MarkFlagRequired("infrastructure_directory")
+	bundleCreateCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment whose pinned versions and values are bundled")
+	bundleCreateCmd.MarkFlagRequired("target_environment")
+	bundleCreateCmd.Flags().StringVarP(&bundleFile, "output_file", "f", "deployer-bundle.tar.gz", "the bundle file to create")
+	bundleCreateCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	bundleCreateCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+
+	bundleApplyCmd.Flags().StringVarP(&bundleFile, "bundle_file", "f", "", "the bundle file to apply")
+	bundleApplyCmd.MarkFlagRequired("bundle_file")
+	bundleApplyCmd.Flags().StringVar(&targetHost, "registry", "", "load the images into this registry, e.g. registry.internal:5000")
+	bundleApplyCmd.Flags().StringVar(&kindCluster, "kind_cluster", "", "load the images into the nodes of this kind cluster instead of a registry")
+	bundleApplyCmd.Flags().IntVar(&vendorsParallelism, "parallelism", 2, "the maximum number of vendors deployed concurrently")
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Package and install environments without internet access",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Package the images, charts and configs of an environment into a tarball",
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		client := registry.NewClient(registry.DockerHub, creds.Username, creds.Secret)
+		if err := runBundleCreate(client, creds.Username, appsRoot, opsDir, infrastructureDir, targetEnvironment, bundleFile); err != nil {
+			log.Fatalf("Error creating bundle: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var bundleApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Load the images of a bundle and deploy its vendors and applications",
+	Run: func(cmd *cobra.Command, args []string) {
+		if (targetHost == "") == (kindCluster == "") {
+			log.Fatalf("Error: exactly one of --registry and --kind_cluster is required\n")
+		}
+		if err := runBundleApply(bundleFile, targetHost, kindCluster); err != nil {
+			log.Fatalf("Error applying bundle: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// pullBundleImage stores the image in the OCI layout of its repository in the bundle, with its
+// signature, SBOM and attestation when withArtifacts is set.
+func pullBundleImage(bundleDir string, sources map[string]*registry.Client, image string, withArtifacts bool) (BundleImage, error) {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return BundleImage{}, err
+	}
+	source, ok := sources[ref.Registry]
+	if !ok {
+		source = registry.NewClient(ref.Registry, "", "")
+		sources[ref.Registry] = source
+	}
+	bundleImage := BundleImage{
+		Source:     image,
+		Repository: ref.Registry + "/" + ref.Repository,
+		Reference:  ref.Reference(),
+	}
+	layout := registry.Layout{Dir: filepath.Join(bundleDir, "images", bundleImage.Repository)}
+	desc, err := layout.Pull(source, ref.Repository, ref.Reference(), ref.Reference(), map[string]string{
+		registry.AnnotationContainerdImageName: ref.String(),
+	})
+	if err != nil {
+		return BundleImage{}, errors.Wrapf(err, "Error pulling %s", image)
+	}
+	bundleImage.Digest = desc.Digest
+	if ref.Digest != "" && ref.Digest != desc.Digest {
+		return BundleImage{}, errors.Errorf("%s resolved to digest %s", image, desc.Digest)
+	}
+
+	if withArtifacts {
+		for _, suffix := range []string{signing.SignatureTagSuffix, sbomTagSuffix, signing.AttestationTagSuffix} {
+			tag := registry.ReferrerTag(desc.Digest, suffix)
+			if _, err := source.HeadManifest(ref.Repository, tag); err != nil {
+				if errors.Cause(err) == registry.ErrNotFound {
+					continue
+				}
+				return BundleImage{}, err
+			}
+			if _, err := layout.Pull(source, ref.Repository, tag, tag, nil); err != nil {
+				return BundleImage{}, errors.Wrapf(err, "Error pulling %s of %s", tag, image)
+			}
+			bundleImage.Artifacts = append(bundleImage.Artifacts, tag)
+		}
+	}
+	log.Printf("Bundled %s (%s)\n", image, desc.Digest)
+	return bundleImage, nil
+}
+
+// copyDir copies the directory tree, preserving file modes, skipping the paths for which skip returns true.
+func copyDir(src string, dst string, skip func(rel string) bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if skip != nil && skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode().Perm())
+	})
+}
+
+func runBundleCreate(
+	client *registry.Client,
+	namespace string,
+	appsRoot string,
+	opsDir string,
+	infrastructureDir string,
+	environment string,
+	outputFile string,
+) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	infraConfig, err := LoadInfraConfig(infrastructureDir)
+	if err != nil {
+		return err
+	}
+	if err := EnsureHelmRepositories(infraConfig, true); err != nil {
+		return err
+	}
+	infraConfig.Vendors.Charts, err = LockedVendorVersions(infrastructureDir, infraConfig.Vendors.Charts)
+	if err != nil {
+		return err
+	}
+	apps, err := DiscoverApplications(appsRoot)
+	if err != nil {
+		return err
+	}
+	bundleDir, err := os.MkdirTemp("", "deployer-bundle-")
+	if err != nil {
+		return errors.Wrap(err, "Error creating the bundle directory")
+	}
+	defer os.RemoveAll(bundleDir)
+
+	manifest := BundleManifest{
+		Version:         bundleFormatVersion,
+		Created:         time.Now().UTC(),
+		DeployerVersion: Version,
+		Environment:     environment,
+	}
+	sources := map[string]*registry.Client{registry.DockerHub: client}
+
+	// Infrastructure: infra.yaml, the vendor values and the local charts
+	bundleInfraDir := filepath.Join(bundleDir, "infrastructure")
+	if err := copyDir(infrastructureDir, bundleInfraDir, nil); err != nil {
+		return errors.Wrapf(err, "Error copying %s", infrastructureDir)
+	}
+	for _, script := range infraConfig.Vendors.Scripts {
+		log.Printf("Warning: vendor script %s is bundled as is and may need internet access\n", script.Name)
+	}
+	for _, vendor := range infraConfig.Vendors.Manifests {
+		for _, source := range vendor.Sources {
+			if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+				log.Printf("Warning: vendor %s applies %s, which needs internet access, and its images are not bundled\n", vendor.Name, source)
+			}
+		}
+	}
+	for _, vendor := range infraConfig.Vendors.Charts {
+		vendorDir := filepath.Join(bundleInfraDir, "vendors", strings.ToLower(vendor.Name))
+		if IsLocalChart(vendor.Chart) {
+			return errors.Errorf("Local chart %s of vendor %s cannot be bundled, only repository and OCI charts", vendor.Chart, vendor.Name)
+		}
+		rendered, _, err := PullVendorChart(infraConfig, vendor, filepath.Join(vendorDir, "chart"))
+		if err != nil {
+			return err
+		}
+		archive := rendered.Chart
+		log.Printf("Bundled chart %s as %s\n", vendor.Chart, filepath.Base(archive))
+		manifests, err := RenderVendorChart(vendorDir, rendered, environment)
+		if err != nil {
+			return err
+		}
+		images, err := ExtractImages(manifests)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading the manifests of %s", vendor.Name)
+		}
+		chart, err := filepath.Rel(bundleDir, archive)
+		if err != nil {
+			return err
+		}
+		bundleVendor := BundleVendor{Name: vendor.Name, Chart: chart}
+		for _, image := range images {
+			bundleImage, err := pullBundleImage(bundleDir, sources, image, false)
+			if err != nil {
+				return err
+			}
+			bundleVendor.Images = append(bundleVendor.Images, bundleImage)
+		}
+		manifest.Vendors = append(manifest.Vendors, bundleVendor)
+	}
+
+	// Applications: the image of the pinned version, the ops config and the package.json
+	for _, app := range apps {
+		appOpsDir := filepath.Join(opsDir, app.Name)
+		deployFile := filepath.Join(appOpsDir, "deploy.yaml")
+		if CheckIfPathExists(deployFile) != nil {
+			log.Printf("Skipping %s, it has no %s\n", app.Name, deployFile)
+			continue
+		}
+		deployConfig, err := LoadDeployConfig(deployFile)
+		if err != nil {
+			return err
+		}
+		version := deployConfig.DeployedVersions[environment]
+		if version == "" {
+			version = deployConfig.LatestReleaseVersion
+		}
+		if version == "" {
+			return errors.Errorf("%s has no version deployed to %s nor released", app.Name, environment)
+		}
+		image := fmt.Sprintf("%s/%s/%s:%s", registry.DockerHub, namespace, app.Name, version)
+		bundleImage, err := pullBundleImage(bundleDir, sources, image, true)
+		if err != nil {
+			return err
+		}
+		manifest.Applications = append(manifest.Applications, BundleApplication{Name: app.Name, Version: version, Image: bundleImage})
+
+		if err := copyDir(appOpsDir, filepath.Join(bundleDir, "ops", app.Name), func(rel string) bool {
+			return rel == "releases"
+		}); err != nil {
+			return errors.Wrapf(err, "Error copying %s", appOpsDir)
+		}
+		packageJSON, err := os.ReadFile(filepath.Join(app.Dir, "package.json"))
+		if err != nil {
+			return errors.Wrapf(err, "Error reading the package.json of %s", app.Name)
+		}
+		bundleAppDir := filepath.Join(bundleDir, "applications", app.Name)
+		if err := os.MkdirAll(bundleAppDir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(bundleAppDir, "package.json"), packageJSON, 0644); err != nil {
+			return err
+		}
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "Error encoding the bundle manifest")
+	}
+	if err := os.WriteFile(filepath.Join(bundleDir, bundleManifestFile), data, 0644); err != nil {
+		return err
+	}
+	if err := writeChecksums(bundleDir); err != nil {
+		return err
+	}
+	if err := createTarGz(bundleDir, outputFile); err != nil {
+		return err
+	}
+	log.Printf("Created bundle %s with %d applications and %d vendors for %s\n", outputFile, len(manifest.Applications), len(manifest.Vendors), environment)
+	return nil
+}
+
+// bundleFiles returns the sorted relative paths of the regular files of the bundle, except the checksums.
+func bundleFiles(bundleDir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(bundleDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(bundleDir, path)
+		if err != nil {
+			return err
+		}
+		if rel != bundleChecksumsFile {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// writeChecksums writes the sha256sum compatible checksums of every file of the bundle.
+func writeChecksums(bundleDir string) error {
+	files, err := bundleFiles(bundleDir)
+	if err != nil {
+		return errors.Wrap(err, "Error listing the bundle files")
+	}
+	var checksums strings.Builder
+	for _, file := range files {
+		sum, err := fileSHA256(filepath.Join(bundleDir, file))
+		if err != nil {
+			return errors.Wrapf(err, "Error hashing %s", file)
+		}
+		fmt.Fprintf(&checksums, "%s  %s\n", sum, file)
+	}
+	return os.WriteFile(filepath.Join(bundleDir, bundleChecksumsFile), []byte(checksums.String()), 0644)
+}
+
+// verifyChecksums checks every file of the bundle against the checksums, and that no file was added.
+func verifyChecksums(bundleDir string) error {
+	file, err := os.Open(filepath.Join(bundleDir, bundleChecksumsFile))
+	if err != nil {
+		return errors.Wrap(err, "Bundle has no checksums")
+	}
+	defer file.Close()
+	expected := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fields) != 2 {
+			return errors.Errorf("Invalid checksum line %q", scanner.Text())
+		}
+		expected[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	files, err := bundleFiles(bundleDir)
+	if err != nil {
+		return err
+	}
+	var mismatches []string
+	for _, rel := range files {
+		want, ok := expected[rel]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not listed", rel))
+			continue
+		}
+		delete(expected, rel)
+		sum, err := fileSHA256(filepath.Join(bundleDir, rel))
+		if err != nil {
+			return err
+		}
+		if sum != want {
+			mismatches = append(mismatches, fmt.Sprintf("%s has checksum %s, expected %s", rel, sum, want))
+		}
+	}
+	for rel := range expected {
+		mismatches = append(mismatches, fmt.Sprintf("%s is missing", rel))
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return errors.Errorf("Bundle checksums do not match:\n%s", strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
+
+// createTarGz archives the directory into a gzip compressed tarball.
+func createTarGz(dir string, outputFile string) error {
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating %s", outputFile)
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	archive := tar.NewWriter(gz)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Error writing %s", outputFile)
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// extractTarGz extracts the tarball into the directory, rejecting paths outside of it.
+func extractTarGz(file string, dir string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening %s", file)
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading %s", file)
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Error reading %s", file)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return errors.Errorf("Invalid path %s in %s", header.Name, file)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, archive); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("Unsupported entry %s in %s", header.Name, file)
+		}
+	}
+}
+
+// loadBundleImage copies the image of the bundle into the target registry and returns its
+// digest-pinned reference there.
+func loadBundleImage(local *registry.Client, target *registry.Client, targetHost string, image BundleImage) (string, error) {
+	ref, err := registry.ParseReference(image.Source)
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range append([]string{image.Reference}, image.Artifacts...) {
+		if _, err := registry.CopyManifest(local, image.Repository, tag, target, ref.Repository, tag); err != nil {
+			return "", errors.Wrapf(err, "Error loading %s", image.Source)
+		}
+	}
+	loaded := registry.Reference{Registry: targetHost, Repository: ref.Repository, Tag: ref.Tag, Digest: image.Digest}
+	log.Printf("Loaded %s as %s\n", image.Source, loaded)
+	return loaded.String(), nil
+}
+
+// kindLoadBundleImage imports the image of the bundle into the nodes of the kind cluster.
+func kindLoadBundleImage(bundleDir string, cluster string, image BundleImage) error {
+	archive, err := os.CreateTemp("", "deployer-image-*.tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	layout := registry.Layout{Dir: filepath.Join(bundleDir, "images", image.Repository)}
+	if err := layout.WriteArchive(archive, image.Reference); err != nil {
+		archive.Close()
+		return errors.Wrapf(err, "Error exporting %s", image.Source)
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if _, err := ExecuteCommand(exec.Command("kind", "load", "image-archive", archive.Name(), "--name", cluster)); err != nil {
+		return errors.Wrapf(err, "Error loading %s into kind cluster %s", image.Source, cluster)
+	}
+	log.Printf("Loaded %s into kind cluster %s\n", image.Source, cluster)
+	return nil
+}
+
+// writeMirrorLock writes the mapping of the images to the registry they were loaded into.
+func writeMirrorLock(file string, registryHost string, images []MirroredImage) error {
+	data, err := yaml.Marshal(MirrorLock{Registry: registryHost, Images: images})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode mirror file")
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// useBundledCharts points the vendor charts of infra.yaml to the archives of the bundle.
+func useBundledCharts(bundleDir string, vendors []BundleVendor) error {
+	infraFile := filepath.Join(bundleDir, "infrastructure", "infra.yaml")
+	data, err := GetMapFromYamlFile(infraFile)
+	if err != nil {
+		return err
+	}
+	archives := map[string]string{}
+	for _, vendor := range vendors {
+		archives[vendor.Name] = filepath.Join(bundleDir, vendor.Chart)
+	}
+	vendorsData, _ := data["vendors"].(map[interface{}]interface{})
+	charts, _ := vendorsData["charts"].([]interface{})
+	for _, chart := range charts {
+		chartData, ok := chart.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		if archive, ok := archives[fmt.Sprint(chartData["name"])]; ok {
+			chartData["chart"] = archive
+			delete(chartData, "version")
+		}
+	}
+	// The charts are bundled, the repositories may not be reachable
+	delete(data, "repositories")
+	return WriteMapToYamlFile(infraFile, data)
+}
+
+func runBundleApply(bundleFile string, targetHost string, kindCluster string) error {
+	bundleDir, err := os.MkdirTemp("", "deployer-bundle-")
+	if err != nil {
+		return errors.Wrap(err, "Error creating the bundle directory")
+	}
+	defer os.RemoveAll(bundleDir)
+
+	log.Printf("Extracting bundle %s\n", bundleFile)
+	if err := extractTarGz(bundleFile, bundleDir); err != nil {
+		return err
+	}
+	if err := verifyChecksums(bundleDir); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(bundleDir, bundleManifestFile))
+	if err != nil {
+		return errors.Wrap(err, "Error reading the bundle manifest")
+	}
+	var manifest BundleManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return errors.Wrap(err, "Error parsing the bundle manifest")
+	}
+	if manifest.Version != bundleFormatVersion {
+		return errors.Errorf("Unsupported bundle version %d, expected %d", manifest.Version, bundleFormatVersion)
+	}
+	log.Printf("Bundle for %s created on %s by deployer %s\n", manifest.Environment, manifest.Created.Format(time.RFC3339), manifest.DeployerVersion)
+
+	// Serve the layouts of the bundle so the images are read with the registry client
+	layouts := map[string]registry.Layout{}
+	var images []BundleImage
+	for _, vendor := range manifest.Vendors {
+		images = append(images, vendor.Images...)
+	}
+	for _, app := range manifest.Applications {
+		images = append(images, app.Image)
+	}
+	for _, image := range images {
+		layouts[image.Repository] = registry.Layout{Dir: filepath.Join(bundleDir, "images", image.Repository)}
+	}
+	local, stop, err := registry.LayoutServer{Layouts: layouts}.Serve()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	var target *registry.Client
+	if targetHost != "" {
+		target = NewReplicaClient(ReplicationTarget{Registry: targetHost})
+	}
+	// loadImage returns the mirror entries rewriting the image, none when it keeps its name
+	loadImage := func(image BundleImage, sources ...string) ([]MirroredImage, error) {
+		if target == nil {
+			return nil, kindLoadBundleImage(bundleDir, kindCluster, image)
+		}
+		loaded, err := loadBundleImage(local, target, targetHost, image)
+		if err != nil {
+			return nil, err
+		}
+		var mirrored []MirroredImage
+		for _, source := range sources {
+			mirrored = append(mirrored, MirroredImage{Source: source, Mirror: loaded})
+		}
+		return mirrored, nil
+	}
+
+	bundleInfraDir := filepath.Join(bundleDir, "infrastructure")
+	for _, vendor := range manifest.Vendors {
+		var mirrored []MirroredImage
+		for _, image := range vendor.Images {
+			entries, err := loadImage(image, image.Source)
+			if err != nil {
+				return err
+			}
+			mirrored = append(mirrored, entries...)
+		}
+		lockFile := filepath.Join(bundleInfraDir, "vendors", strings.ToLower(vendor.Name), mirrorFile)
+		os.Remove(lockFile)
+		if target != nil {
+			if err := writeMirrorLock(lockFile, targetHost, mirrored); err != nil {
+				return err
+			}
+		}
+	}
+	if err := useBundledCharts(bundleDir, manifest.Vendors); err != nil {
+		return err
+	}
+	if err := runVendorsDeploy(bundleInfraDir, manifest.Environment, vendorsParallelism, false, VendorSelector{}); err != nil {
+		return err
+	}
+
+	for _, app := range manifest.Applications {
+		bundleAppDir := filepath.Join(bundleDir, "applications", app.Name)
+		// The chart renders <repo>:<version>, or <repo>:<version>@<digest> when the signature is verified
+		ref, err := registry.ParseReference(app.Image.Source)
+		if err != nil {
+			return err
+		}
+		pinned := ref
+		pinned.Digest = app.Image.Digest
+		mirrored, err := loadImage(app.Image, ref.String(), pinned.String())
+		if err != nil {
+			return err
+		}
+		// The layouts are served by a local address, the provenance names the released image
+		source := &ImageSource{Client: local, Repository: app.Image.Repository, Name: ref.Registry + "/" + ref.Repository}
+		if target != nil {
+			source.MirrorFile = filepath.Join(bundleAppDir, mirrorFile)
+			if err := writeMirrorLock(source.MirrorFile, targetHost, mirrored); err != nil {
+				return err
+			}
+		}
+		if err := runDeploy(
+			bundleAppDir,
+			filepath.Join(bundleDir, "ops"),
+			bundleInfraDir,
+			manifest.Environment,
+			"",
+			app.Version,
+			source,
+		); err != nil {
+			return errors.Wrapf(err, "Error deploying %s", app.Name)
+		}
+	}
+	log.Printf("Applied bundle %s: %d vendors and %d applications\n", bundleFile, len(manifest.Vendors), len(manifest.Applications))
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+)
+
+func TestBundleCreateAndApplyVerifiesProvenance(t *testing.T) {
+	hub := registrytest.New()
+	defer hub.Close()
+	client := hub.Client()
+	target := registrytest.New()
+	defer target.Close()
+
+	// A signed release of team/app 1.0.0 with its provenance
+	root := t.TempDir()
+	keyFile := filepath.Join(root, "release")
+	if err := runKeysGenerate(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	image, err := client.PushArtifact("team/app", "1.0.0", registry.Artifact{
+		Layers: []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Data: []byte("release")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignReleasedImage(client, "team/app", image, keyFile+".key"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	statement, err := NewProvenance(root, ReleasedImageName("team/app"), image.Digest, BuildInfo{
+		StartedOn:   now.Add(-time.Minute),
+		FinishedOn:  now,
+		TrivyPassed: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AttachProvenance(client, "team/app", image, statement, keyFile+".key", root); err != nil {
+		t.Fatal(err)
+	}
+
+	appsRoot := filepath.Join(root, "applications")
+	opsDir := filepath.Join(root, "ops")
+	infrastructureDir := filepath.Join(root, "infrastructure")
+	writeFile(t, filepath.Join(appsRoot, "app", "package.json"), `{"name":"app","version":"1.0.0"}`)
+	writeFile(t, filepath.Join(opsDir, "app", "deploy.yaml"), `apiVersion: deployer/v2
+chart: nodejs
+environmentVars: []
+latestReleaseVersion: 1.0.0
+signing:
+  trustedKeys:
+    production:
+      - release.pub
+`)
+	publicKey, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(opsDir, "app", "release.pub"), string(publicKey))
+	writeFile(t, filepath.Join(opsDir, "app", "values.production.yaml"), "image:\n  tag: <IMAGE_TAG>\n")
+	writeFile(t, filepath.Join(infrastructureDir, "infra.yaml"), "apiVersion: deployer/v2\nvendors: {}\n")
+	writeFile(t, filepath.Join(infrastructureDir, "charts", "nodejs", "Chart.yaml"), "apiVersion: v2\nname: nodejs\nversion: 1.0.0\n")
+
+	bundle := filepath.Join(root, "bundle.tar.gz")
+	if err := runBundleCreate(client, "team", appsRoot, opsDir, infrastructureDir, "production", bundle); err != nil {
+		t.Fatalf("runBundleCreate: %v", err)
+	}
+
+	// Apply without a cluster, the provenance is verified against docker.io/team/app
+	fakeHelm(t)
+	t.Setenv("KUBECONFIG", filepath.Join(root, "missing-kubeconfig"))
+	targetHost := strings.TrimPrefix(target.URL, "http://")
+	if err := runBundleApply(bundle, targetHost, ""); err != nil {
+		t.Fatalf("runBundleApply: %v", err)
+	}
+	if _, ok := target.Manifest("team/app", "1.0.0"); !ok {
+		t.Error("the image was not loaded into the target registry")
+	}
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"deployer/pkg/registry"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// channelName matches the floating tags that can be moved: names like stable or beta, and <major>.<minor>
+var channelName = regexp.MustCompile(`^([a-z][a-z0-9-]{0,63}|[0-9]+\.[0-9]+)$`)
+
+func init() {
+	rootCmd.AddCommand(channelCmd)
+	channelCmd.AddCommand(channelSetCmd)
+
+	channelSetCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	channelSetCmd.MarkFlagRequired("operations_directory")
+
+	channelSetCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	channelSetCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+}
+
+var channelCmd = &cobra.Command{
+	Use:   "channel",
+	Short: "Manage the release channels of the applications",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var channelSetCmd = &cobra.Command{
+	Use:   "set <application_name> <channel> <version>",
+	Short: "Point a channel tag to a released version",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		client := registry.NewClient(registry.DockerHub, creds.Username, creds.Secret)
+		if err := runChannelSet(client, opsDir, creds.Username, args[0], args[1], args[2]); err != nil {
+			log.Fatalf("Error setting channel: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// IsImmutableTag reports whether the tag is a version tag, which must never be overwritten.
+func IsImmutableTag(tag string) bool {
+	_, err := semver.StrictNewVersion(tag)
+	return err == nil
+}
+
+// ValidateChannel checks that the channel is a floating tag name and not a version tag.
+func ValidateChannel(channel string) error {
+	if IsImmutableTag(channel) {
+		return errors.Errorf("%s is an immutable version tag and cannot be used as a channel", channel)
+	}
+	if !channelName.MatchString(channel) {
+		return errors.Errorf("invalid channel %s, expected a lowercase name like stable or <major>.<minor>", channel)
+	}
+	return nil
+}
+
+// MinorChannel returns the <major>.<minor> channel of the version.
+func MinorChannel(version string) (string, error) {
+	v, err := semver.StrictNewVersion(version)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid version %s", version)
+	}
+	return fmt.Sprintf("%d.%d", v.Major(), v.Minor()), nil
+}
+
+// EnsureTagIsNew fails when the version tag already exists in the registry, so that a push never overwrites it.
+func EnsureTagIsNew(client *registry.Client, imageRepo string, version string) error {
+	desc, err := client.HeadManifest(imageRepo, version)
+	if err == nil {
+		return errors.Errorf("Image tag %s:%s already exists (%s) and version tags are immutable", imageRepo, version, desc.Digest)
+	}
+	if errors.Cause(err) != registry.ErrNotFound {
+		return errors.Wrapf(err, "Error checking if %s:%s exists", imageRepo, version)
+	}
+	return nil
+}
+
+// MoveChannel points the channel tag to the manifest of the version by copying the manifest
+// through the registry API. Layers are shared, so no blob is transferred.
+func MoveChannel(client *registry.Client, imageRepo string, channel string, version string) (registry.Descriptor, error) {
+	if err := ValidateChannel(channel); err != nil {
+		return registry.Descriptor{}, err
+	}
+	if !IsImmutableTag(version) {
+		return registry.Descriptor{}, errors.Errorf("%s is not a released version", version)
+	}
+	body, desc, err := client.GetManifest(imageRepo, version)
+	if err != nil {
+		return registry.Descriptor{}, errors.Wrapf(err, "Version %s of %s not found", version, imageRepo)
+	}
+	if _, err := client.PutManifest(imageRepo, channel, desc.MediaType, body); err != nil {
+		return registry.Descriptor{}, errors.Wrapf(err, "Error moving channel %s to %s", channel, version)
+	}
+	log.Printf("Channel %s:%s now points to %s (%s)\n", imageRepo, channel, version, desc.Digest)
+	return desc, nil
+}
+
+// ReleaseChannels returns the channels to move when releasing the version: the requested
+// channels and the <major>.<minor> channel, unless a newer patch of that minor is released.
+func ReleaseChannels(client *registry.Client, imageRepo string, version string, requested []string) ([]string, error) {
+	channels := map[string]bool{}
+	for _, channel := range requested {
+		if err := ValidateChannel(channel); err != nil {
+			return nil, err
+		}
+		channels[channel] = true
+	}
+
+	minor, err := MinorChannel(version)
+	if err != nil {
+		return nil, err
+	}
+	released, err := semver.StrictNewVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := client.ListTags(imageRepo)
+	if err != nil {
+		return nil, err
+	}
+	newest := true
+	for _, tag := range tags {
+		other, err := semver.StrictNewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if other.Major() == released.Major() && other.Minor() == released.Minor() && other.GreaterThan(released) {
+			log.Printf("Not moving channel %s, %s is newer than %s\n", minor, tag, version)
+			newest = false
+			break
+		}
+	}
+	if newest {
+		channels[minor] = true
+	}
+
+	var result []string
+	for channel := range channels {
+		result = append(result, channel)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// RecordChannels stores the version each channel points to in the channels section of deploy.yaml.
+func RecordChannels(deployFile string, channels []string, version string) error {
+	yamlData, err := GetMapFromYamlFile(deployFile)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading YAML file %s", deployFile)
+	}
+	recorded := GetStringMap(yamlData["channels"])
+	for _, channel := range channels {
+		recorded[channel] = version
+	}
+	yamlData["channels"] = recorded
+	if err := WriteMapToYamlFile(deployFile, yamlData); err != nil {
+		return errors.Wrapf(err, "Error writing YAML file %s", deployFile)
+	}
+	return nil
+}
+
+func runChannelSet(client *registry.Client, opsDir string, namespace string, appName string, channel string, version string) error {
+	deployFile := filepath.Join(opsDir, appName, "deploy.yaml")
+	if err := CheckIfPathExists(deployFile); err != nil {
+		return errors.Wrapf(err, "File %s does not exist", deployFile)
+	}
+	imageRepo := fmt.Sprintf("%s/%s", namespace, appName)
+	if _, err := MoveChannel(client, imageRepo, channel, version); err != nil {
+		return err
+	}
+	if err := RecordChannels(deployFile, []string{channel}, version); err != nil {
+		return err
+	}
+	log.Printf("Updated %s with channels.%s=%s\n", deployFile, channel, version)
+	return nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"deployer/pkg/trivy"
+
+	"github.com/pkg/errors"
+)
+
+// DeployConfig is the typed view of ops/<app>/deploy.yaml.
+type DeployConfig struct {
+	// APIVersion is the version of the deploy.yaml format, see deployConfigFormat
+	APIVersion           string            `yaml:"apiVersion"`
+	Chart                string            `yaml:"chart" schema:"required"`
+	EnvironmentVars      []string          `yaml:"environmentVars"`
+	LatestReleaseVersion string            `yaml:"latestReleaseVersion"`
+	DeployedVersions     map[string]string `yaml:"deployedVersions"`
+	Channels             map[string]string `yaml:"channels"`
+	Security             *trivy.Policy     `yaml:"security"`
+	Signing              *SigningConfig    `yaml:"signing"`
+	Build                BuildConfig       `yaml:"build"`
+	Replication          ReplicationConfig `yaml:"replication"`
+}
+
+// LoadDeployConfig reads and validates the deploy.yaml file of an application, converted from
+// an older format version when needed.
+func LoadDeployConfig(deployFile string) (DeployConfig, error) {
+	var config DeployConfig
+	data, err := os.ReadFile(deployFile)
+	if err != nil {
+		return config, errors.Wrapf(err, "failed to read deploy file %s", deployFile)
+	}
+	if err := deployConfigFormat.Load(deployFile, data, &config); err != nil {
+		return config, errors.Wrapf(err, "invalid deploy file %s", deployFile)
+	}
+	return config, nil
+}
+
+// SecurityPolicy returns the security policy of the application, or the default policy when none is declared.
+func (c DeployConfig) SecurityPolicy() (trivy.Policy, error) {
+	if c.Security == nil {
+		return trivy.DefaultPolicy(), nil
+	}
+	return *c.Security, c.Security.Validate()
+}
+
+// ReleaseArtifactsDir returns, creating it when needed, the directory that holds the
+// artifacts of a release: <ops_directory>/<application>/releases/<version>.
+func ReleaseArtifactsDir(opsDir string, appName string, version string) (string, error) {
+	dir := filepath.Join(opsDir, appName, "releases", version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create release artifacts directory %s", dir)
+	}
+	return dir, nil
+}
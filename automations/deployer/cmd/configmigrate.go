@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"deployer/pkg/schema"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Versions of the infra.yaml and deploy.yaml formats. The files without an apiVersion are
+// deployer/v1, the format before apiVersion was introduced.
+const (
+	configVersionV1 = "deployer/v1"
+	configVersionV2 = "deployer/v2"
+)
+
+// infraConfigFormat upgrades infra.yaml to the version of InfraConfig
+var infraConfigFormat = schema.Format{
+	Name:        "infra.yaml",
+	Current:     configVersionV2,
+	Unversioned: configVersionV1,
+	Converters: []schema.Converter{
+		{From: configVersionV1, To: configVersionV2, Convert: convertV1},
+	},
+}
+
+// deployConfigFormat upgrades deploy.yaml to the version of DeployConfig
+var deployConfigFormat = schema.Format{
+	Name:        "deploy.yaml",
+	Current:     configVersionV2,
+	Unversioned: configVersionV1,
+	Converters: []schema.Converter{
+		{From: configVersionV1, To: configVersionV2, Convert: convertV1},
+	},
+}
+
+// convertV1 upgrades a deployer/v1 document. Script vendors written as their plain name
+// become a mapping with the name, the other fields deployer/v2 reads as they are.
+func convertV1(document *yaml.Node) error {
+	vendors := schema.MappingValue(document, "vendors")
+	if vendors == nil || vendors.Kind != yaml.MappingNode {
+		return nil
+	}
+	scripts := schema.MappingValue(vendors, "scripts")
+	if scripts == nil || scripts.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for i, script := range scripts.Content {
+		if script.Kind != yaml.ScalarNode {
+			continue
+		}
+		// The name keeps its comments
+		scripts.Content[i] = &yaml.Node{
+			Kind: yaml.MappingNode,
+			Tag:  "!!map",
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"},
+				script,
+			},
+		}
+	}
+	return nil
+}
+
+var migrateDryRun bool
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configMigrateCmd)
+
+	configMigrateCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	configMigrateCmd.MarkFlagRequired("operations_directory")
+
+	configMigrateCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	configMigrateCmd.MarkFlagRequired("infrastructure_directory")
+
+	configMigrateCmd.Flags().BoolVar(&migrateDryRun, "dry_run", false, "only list the files that would be migrated")
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the deployer configuration files",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrite infra.yaml and the deploy.yaml files to the newest format version",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runConfigMigrate(opsDir, infrastructureDir, migrateDryRun); err != nil {
+			log.Fatalf("Error migrating the configuration: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// MigrateConfigFile rewrites the file to the current version of the format, keeping its
+// comments. It returns the version the file was at.
+func MigrateConfigFile(file string, format schema.Format, dryRun bool) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error reading file %s", file)
+	}
+	migrated, from, err := format.Rewrite(file, data)
+	if err != nil || from == format.Current || dryRun {
+		return from, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return from, err
+	}
+	return from, errors.Wrapf(os.WriteFile(file, migrated, info.Mode()), "Error writing file %s", file)
+}
+
+func runConfigMigrate(opsDir string, infrastructureDir string, dryRun bool) error {
+	files := map[string]schema.Format{filepath.Join(infrastructureDir, "infra.yaml"): infraConfigFormat}
+	order := []string{filepath.Join(infrastructureDir, "infra.yaml")}
+	deployFiles, err := filepath.Glob(filepath.Join(opsDir, "*", "deploy.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, deployFile := range deployFiles {
+		files[deployFile] = deployConfigFormat
+		order = append(order, deployFile)
+	}
+
+	migrated := 0
+	for _, file := range order {
+		format := files[file]
+		from, err := MigrateConfigFile(file, format, dryRun)
+		if err != nil {
+			return err
+		}
+		switch {
+		case from == format.Current:
+			log.Printf("%s is at %s\n", file, format.Current)
+		case dryRun:
+			log.Printf("%s would be migrated from %s to %s\n", file, from, format.Current)
+			migrated++
+		default:
+			log.Printf("Migrated %s from %s to %s\n", file, from, format.Current)
+			migrated++
+		}
+	}
+	log.Printf("%d of %d files migrated\n", migrated, len(order))
+	return nil
+}
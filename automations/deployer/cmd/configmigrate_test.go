@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfigFile(t *testing.T) {
+	infraFile := filepath.Join(t.TempDir(), "infra.yaml")
+	writeFile(t, infraFile, `# Vendors of the cluster
+vendors:
+  scripts:
+    - Nginx # ingress controller
+`)
+
+	from, err := MigrateConfigFile(infraFile, infraConfigFormat, false)
+	if err != nil {
+		t.Fatalf("MigrateConfigFile: %v", err)
+	}
+	if from != configVersionV1 {
+		t.Errorf("got version %s, want %s for a file without apiVersion", from, configVersionV1)
+	}
+	data, err := os.ReadFile(infraFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated := string(data)
+	if !strings.Contains(migrated, "apiVersion: "+configVersionV2) || !strings.Contains(migrated, "- name: Nginx # ingress controller") {
+		t.Errorf("got\n%s\nwant the apiVersion added, the script name converted and the comments kept", migrated)
+	}
+	var infraConfig InfraConfig
+	if err := infraConfigFormat.Load(infraFile, data, &infraConfig); err != nil || len(infraConfig.Vendors.Scripts) != 1 || infraConfig.Vendors.Scripts[0].Name != "Nginx" {
+		t.Errorf("got %+v (%v), want the migrated file to load", infraConfig, err)
+	}
+
+	if from, err := MigrateConfigFile(infraFile, infraConfigFormat, false); err != nil || from != configVersionV2 {
+		t.Errorf("got %s (%v), want the migrated file left at %s", from, err, configVersionV2)
+	}
+
+	// deployer/v2 only has the mapping form of the scripts
+	writeFile(t, infraFile, "apiVersion: deployer/v2\nvendors:\n  scripts:\n    - Nginx\n")
+	data, _ = os.ReadFile(infraFile)
+	if err := infraConfigFormat.Load(infraFile, data, &InfraConfig{}); err == nil {
+		t.Error("got a plain script name loaded from deployer/v2, want an error")
+	}
+
+	// deployer/v1 never had a version field
+	writeFile(t, infraFile, "version: 1\nvendors: {}\n")
+	data, _ = os.ReadFile(infraFile)
+	if err := infraConfigFormat.Load(infraFile, data, &InfraConfig{}); err == nil || !strings.Contains(err.Error(), "unknown field version") {
+		t.Errorf("got %v, want the version field reported as unknown", err)
+	}
+}
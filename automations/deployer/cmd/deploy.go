@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"log"
 	"os"
@@ -8,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"deployer/pkg/registry"
+
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -37,6 +40,8 @@ func init() {
 	// Optional
 	deployCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "the namespace to deploy to")
 	deployCmd.Flags().StringVarP(&imageTag, "image_tag", "t", "", "the image tag to use")
+	deployCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	deployCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
 }
 
 var deployCmd = &cobra.Command{
@@ -50,6 +55,7 @@ var deployCmd = &cobra.Command{
 			targetEnvironment,
 			namespace,
 			imageTag,
+			nil,
 		); err != nil {
 			log.Fatalf("Error running deploy process: %v\n", err)
 			os.Exit(1)
@@ -57,6 +63,51 @@ var deployCmd = &cobra.Command{
 	},
 }
 
+// ImageSource is where the image of the application is verified, instead of Docker Hub.
+type ImageSource struct {
+	Client     *registry.Client
+	Repository string
+	// Name is the canonical name the image was released under, see ReleasedImageName
+	Name string
+	// MirrorFile rewrites the images of the rendered chart, see vendors mirror
+	MirrorFile string
+}
+
+// VerifyDeployedImage checks the signature of the image to deploy, and its provenance in
+// protected environments, and returns the verified digest. Protected environments refuse images
+// that cannot be verified, other environments without trusted keys are not verified.
+func VerifyDeployedImage(
+	client *registry.Client,
+	imageRepo string,
+	imageName string,
+	tag string,
+	environment string,
+	trustedKeys []*ecdsa.PublicKey,
+) (string, error) {
+	protected := IsProtectedEnvironment(environment)
+	if len(trustedKeys) == 0 {
+		if protected {
+			return "", errors.Errorf(
+				"No trusted keys configured for %s in the signing section of deploy.yaml, refusing to deploy an image without verified signature and provenance",
+				environment,
+			)
+		}
+		log.Printf("Warning: no trusted keys configured for %s, skipping image signature verification\n", environment)
+		return "", nil
+	}
+	digest, err := VerifyImageSignature(client, imageRepo, tag, trustedKeys)
+	if err != nil {
+		return "", errors.Wrapf(err, "Image signature verification failed for %s:%s", imageRepo, tag)
+	}
+	if protected {
+		if _, err := VerifyProvenance(client, imageRepo, imageName, digest, trustedKeys); err != nil {
+			return "", errors.Wrapf(err, "Provenance verification failed for %s:%s", imageRepo, tag)
+		}
+		log.Printf("Verified provenance of %s:%s\n", imageRepo, tag)
+	}
+	return digest, nil
+}
+
 func runDeploy(
 	appDir string,
 	opsDir string,
@@ -64,6 +115,7 @@ func runDeploy(
 	environment string,
 	namespace string,
 	imageTag string,
+	source *ImageSource,
 ) error {
 	log.Printf("Checking if the application directory exists: %s\n", appDir)
 	if err := CheckIfPathExists(appDir); err != nil {
@@ -106,8 +158,42 @@ func runDeploy(
 	if imageTag != "" {
 		releaseVersion = imageTag
 	}
+	deployConfig, err := LoadDeployConfig(deployFile)
+	if err != nil {
+		return err
+	}
+	trustedKeys, err := deployConfig.TrustedKeys(filepath.Join(opsDir, appName), environment)
+	if err != nil {
+		return err
+	}
+	imageReference := releaseVersion
+	if len(trustedKeys) > 0 && source == nil {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			return errors.Wrap(err, "Error resolving docker credentials")
+		}
+		imageRepo := fmt.Sprintf("%s/%s", creds.Username, appName)
+		source = &ImageSource{
+			Client:     registry.NewClient(registry.DockerHub, creds.Username, creds.Secret),
+			Repository: imageRepo,
+			Name:       ReleasedImageName(imageRepo),
+		}
+	}
+	var client *registry.Client
+	var imageRepo, imageName string
+	if source != nil {
+		client, imageRepo, imageName = source.Client, source.Repository, source.Name
+	}
+	digest, err := VerifyDeployedImage(client, imageRepo, imageName, releaseVersion, environment, trustedKeys)
+	if err != nil {
+		return err
+	}
+	if digest != "" {
+		// Pin the verified digest so the tag cannot be swapped after verification
+		imageReference = fmt.Sprintf("%s@%s", releaseVersion, digest)
+	}
 	environmentVars = append(environmentVars, "IMAGE_TAG")
-	os.Setenv("IMAGE_TAG", releaseVersion)
+	os.Setenv("IMAGE_TAG", imageReference)
 
 	chartValues := filepath.Join(opsDir, appName, fmt.Sprintf("values.%s.yaml", environment))
 	if err := CheckIfPathExists(chartValues); err != nil {
@@ -163,6 +249,7 @@ func runDeploy(
 	}
 
 	log.Printf("Deploying application %s to namespace %s\n", appName, namespace)
+	CreateOwnedNamespace(namespace, appName, log.Default())
 	helmCmd := exec.Command(
 		"helm",
 		"upgrade",
@@ -176,10 +263,31 @@ func runDeploy(
 		"--create-namespace",
 		"--wait",
 	)
+	if source != nil && source.MirrorFile != "" {
+		postRenderer, err := PostRendererScript(source.MirrorFile)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(postRenderer)
+		helmCmd.Args = append(helmCmd.Args, "--post-renderer", postRenderer)
+	}
 	out, err := ExecuteCommand(helmCmd)
 	if err != nil {
 		return errors.Wrapf(err, "Error running helm upgrade: %s", out)
 	}
 	log.Printf("Application %s deployed\n", appName)
+
+	// Record the deployed version so registry retention keeps it
+	deployData, err := GetMapFromYamlFile(deployFile)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading YAML file %s", deployFile)
+	}
+	deployedVersions := GetStringMap(deployData["deployedVersions"])
+	deployedVersions[environment] = releaseVersion
+	deployData["deployedVersions"] = deployedVersions
+	if err := WriteMapToYamlFile(deployFile, deployData); err != nil {
+		return errors.Wrapf(err, "Error writing YAML file %s", deployFile)
+	}
+	log.Printf("Updated %s with deployedVersions.%s=%s\n", deployFile, environment, releaseVersion)
 	return nil
 }
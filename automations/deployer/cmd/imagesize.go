@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"deployer/pkg/registry"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// imageSizeFile is the release artifact holding the size and layers of the image
+const imageSizeFile = "image.json"
+
+// largestLayersShown is the number of layers listed in the size report
+const largestLayersShown = 5
+
+// SizeBudget is the build.sizeBudget section of deploy.yaml.
+type SizeBudget struct {
+	// MaxSize is the maximum image size, e.g. 300Mi or 250M
+	MaxSize string `yaml:"maxSize"`
+	// MaxGrowthPercent is the maximum growth compared to the previous release
+	MaxGrowthPercent float64 `yaml:"maxGrowthPercent"`
+}
+
+// ImageLayer is a layer of the image and the instruction that created it.
+type ImageLayer struct {
+	CreatedBy string `json:"createdBy"`
+	Size      int64  `json:"size"`
+}
+
+// ImageSize is the size report of an image.
+type ImageSize struct {
+	Image  string       `json:"image"`
+	Size   int64        `json:"size"`
+	Layers []ImageLayer `json:"layers"`
+}
+
+// InspectImageSize reads the size and the layer history of a local image with docker or podman.
+func InspectImageSize(engine string, imageName string) (ImageSize, error) {
+	imageSize := ImageSize{Image: imageName}
+	out, err := exec.Command(engine, "image", "inspect", "--format", "{{.Size}}", imageName).Output()
+	if err != nil {
+		return imageSize, errors.Wrapf(err, "failed to inspect image %s", imageName)
+	}
+	if imageSize.Size, err = strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err != nil {
+		return imageSize, errors.Wrapf(err, "failed to parse size of image %s", imageName)
+	}
+
+	if engine == "podman" {
+		out, err = exec.Command(engine, "history", "--no-trunc", "--format", "json", imageName).Output()
+		if err != nil {
+			return imageSize, errors.Wrapf(err, "failed to read history of image %s", imageName)
+		}
+		var history []struct {
+			CreatedBy string `json:"createdBy"`
+			Size      int64  `json:"size"`
+		}
+		if err := json.Unmarshal(out, &history); err != nil {
+			return imageSize, errors.Wrapf(err, "failed to parse history of image %s", imageName)
+		}
+		for _, entry := range history {
+			imageSize.Layers = append(imageSize.Layers, ImageLayer{CreatedBy: entry.CreatedBy, Size: entry.Size})
+		}
+		return imageSize, nil
+	}
+
+	out, err = exec.Command(engine, "history", "--no-trunc", "--human=false", "--format", "{{json .}}", imageName).Output()
+	if err != nil {
+		return imageSize, errors.Wrapf(err, "failed to read history of image %s", imageName)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry struct {
+			CreatedBy string `json:"CreatedBy"`
+			Size      string `json:"Size"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return imageSize, errors.Wrapf(err, "failed to parse history of image %s", imageName)
+		}
+		size, err := strconv.ParseInt(entry.Size, 10, 64)
+		if err != nil {
+			return imageSize, errors.Wrapf(err, "failed to parse layer size %q of image %s", entry.Size, imageName)
+		}
+		imageSize.Layers = append(imageSize.Layers, ImageLayer{CreatedBy: entry.CreatedBy, Size: size})
+	}
+	return imageSize, nil
+}
+
+// LayoutImageSize computes the size and the layer history of an image of an OCI layout. Layer
+// sizes are uncompressed, like the sizes reported by docker for local images.
+func LayoutImageSize(layout registry.Layout, digest string, imageName string) (ImageSize, error) {
+	body, err := layout.ReadBlob(digest)
+	if err != nil {
+		return ImageSize{Image: imageName}, err
+	}
+	var manifest registry.Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil || manifest.Config == nil {
+		return ImageSize{Image: imageName}, errors.Errorf("invalid manifest %s of image %s", digest, imageName)
+	}
+	configData, err := layout.ReadBlob(manifest.Config.Digest)
+	if err != nil {
+		return ImageSize{Image: imageName}, err
+	}
+	return manifestImageSize(manifest, configData, layout.OpenBlob, imageName)
+}
+
+// RegistryImageSize computes the size and the layer history of an image of the registry, the
+// largest platform of a multi-platform image. Layers are downloaded to measure their
+// uncompressed size, so the result compares with the size of a local image.
+func RegistryImageSize(client *registry.Client, imageRepo string, reference string) (ImageSize, error) {
+	imageName := fmt.Sprintf("%s:%s", imageRepo, reference)
+	manifest, _, err := client.GetImageManifest(imageRepo, reference)
+	if err != nil {
+		return ImageSize{Image: imageName}, err
+	}
+	if manifest.IsIndex() {
+		var largest ImageSize
+		for _, desc := range manifest.Manifests {
+			// Skip the attestation manifests buildx adds to the index
+			if desc.Platform == nil || desc.Platform.OS == "unknown" {
+				continue
+			}
+			size, err := RegistryImageSize(client, imageRepo, desc.Digest)
+			if err != nil {
+				return ImageSize{Image: imageName}, err
+			}
+			if size.Size >= largest.Size {
+				largest = size
+			}
+		}
+		largest.Image = imageName
+		return largest, nil
+	}
+	if manifest.Config == nil {
+		return ImageSize{Image: imageName}, errors.Errorf("manifest %s has no config", imageName)
+	}
+	configData, err := client.GetBlob(imageRepo, manifest.Config.Digest)
+	if err != nil {
+		return ImageSize{Image: imageName}, err
+	}
+	open := func(digest string) (io.ReadCloser, error) {
+		blob, _, err := client.OpenBlob(imageRepo, digest)
+		return blob, err
+	}
+	return manifestImageSize(manifest, configData, open, imageName)
+}
+
+// manifestImageSize matches the layers of the manifest with the history of the image config.
+func manifestImageSize(
+	manifest registry.Manifest,
+	configData []byte,
+	open func(digest string) (io.ReadCloser, error),
+	imageName string,
+) (ImageSize, error) {
+	imageSize := ImageSize{Image: imageName}
+	var config registry.ImageConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return imageSize, errors.Wrapf(err, "failed to parse config of image %s", imageName)
+	}
+
+	var err error
+	layers := manifest.Layers
+	for _, entry := range config.History {
+		layer := ImageLayer{CreatedBy: entry.CreatedBy}
+		if !entry.EmptyLayer && len(layers) > 0 {
+			if layer.Size, err = uncompressedSize(open, layers[0]); err != nil {
+				return imageSize, err
+			}
+			layers = layers[1:]
+		}
+		imageSize.Size += layer.Size
+		imageSize.Layers = append(imageSize.Layers, layer)
+	}
+	// Layers without history
+	for _, desc := range layers {
+		size, err := uncompressedSize(open, desc)
+		if err != nil {
+			return imageSize, err
+		}
+		imageSize.Size += size
+		imageSize.Layers = append(imageSize.Layers, ImageLayer{Size: size})
+	}
+	return imageSize, nil
+}
+
+// uncompressedSize returns the size of the layer once decompressed.
+func uncompressedSize(open func(digest string) (io.ReadCloser, error), layer registry.Descriptor) (int64, error) {
+	if !strings.HasSuffix(layer.MediaType, "gzip") {
+		return layer.Size, nil
+	}
+	blob, err := open(layer.Digest)
+	if err != nil {
+		return 0, err
+	}
+	defer blob.Close()
+	reader, err := gzip.NewReader(blob)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to decompress layer %s", layer.Digest)
+	}
+	size, err := io.Copy(io.Discard, reader)
+	return size, errors.Wrapf(err, "failed to decompress layer %s", layer.Digest)
+}
+
+// formatBytes returns the size in MiB with one decimal.
+func formatBytes(size int64) string {
+	return fmt.Sprintf("%.1fMiB", float64(size)/(1024*1024))
+}
+
+// PrintImageSize prints the total size and the largest layers of the image.
+func PrintImageSize(imageSize ImageSize) {
+	log.Printf("Image %s size: %s in %d layers\n", imageSize.Image, formatBytes(imageSize.Size), len(imageSize.Layers))
+	layers := append([]ImageLayer{}, imageSize.Layers...)
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].Size > layers[j].Size
+	})
+	if len(layers) > largestLayersShown {
+		layers = layers[:largestLayersShown]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tCREATED BY")
+	for _, layer := range layers {
+		createdBy := strings.Join(strings.Fields(layer.CreatedBy), " ")
+		if len(createdBy) > 100 {
+			createdBy = createdBy[:97] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\n", formatBytes(layer.Size), createdBy)
+	}
+	w.Flush()
+}
+
+// CheckImageSize records the image size as a release artifact and enforces the budget: the
+// maximum size and the maximum growth compared to the previous release. The size of the
+// previous release is read from its release artifact, or from its image in the registry.
+func CheckImageSize(
+	client *registry.Client,
+	imageRepo string,
+	opsDir string,
+	appName string,
+	version string,
+	previousVersion string,
+	budget SizeBudget,
+	imageSize ImageSize,
+) error {
+	artifactsDir, err := ReleaseArtifactsDir(opsDir, appName, version)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(imageSize, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal image size")
+	}
+	sizeFile := filepath.Join(artifactsDir, imageSizeFile)
+	if err := os.WriteFile(sizeFile, data, 0644); err != nil {
+		return errors.Wrapf(err, "Error writing file %s", sizeFile)
+	}
+
+	var violations []string
+	if budget.MaxSize != "" {
+		maxSize, err := resource.ParseQuantity(budget.MaxSize)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid build.sizeBudget.maxSize %s", budget.MaxSize)
+		}
+		if imageSize.Size > maxSize.Value() {
+			violations = append(violations, fmt.Sprintf("image size %s exceeds the budget of %s", formatBytes(imageSize.Size), budget.MaxSize))
+		}
+	}
+
+	if previousVersion != "" && previousVersion != version {
+		previous, err := previousImageSize(client, imageRepo, opsDir, appName, previousVersion)
+		if err != nil {
+			if budget.MaxGrowthPercent > 0 {
+				return errors.Wrapf(err, "Size of the previous release %s is unknown, build.sizeBudget.maxGrowthPercent cannot be checked", previousVersion)
+			}
+			log.Printf("Warning: size of the previous release %s is unknown, skipping the growth check: %v\n", previousVersion, err)
+		} else {
+			growth := 0.0
+			if previous.Size > 0 {
+				growth = float64(imageSize.Size-previous.Size) * 100 / float64(previous.Size)
+			}
+			log.Printf("Image size changed by %+.1f%% compared to %s (%s)\n", growth, previousVersion, formatBytes(previous.Size))
+			if budget.MaxGrowthPercent > 0 && growth > budget.MaxGrowthPercent {
+				violations = append(violations, fmt.Sprintf(
+					"image grew %.1f%% compared to %s, more than the allowed %.1f%%",
+					growth,
+					previousVersion,
+					budget.MaxGrowthPercent,
+				))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return errors.Errorf("Image size budget exceeded: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// previousImageSize reads the size of a previous release from its release artifact, falling
+// back to its image in the registry when the artifact was not recorded.
+func previousImageSize(client *registry.Client, imageRepo string, opsDir string, appName string, version string) (ImageSize, error) {
+	previousFile := filepath.Join(opsDir, appName, "releases", version, imageSizeFile)
+	data, err := os.ReadFile(previousFile)
+	if err == nil {
+		var previous ImageSize
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return previous, errors.Wrapf(err, "Error parsing file %s", previousFile)
+		}
+		return previous, nil
+	}
+	if !os.IsNotExist(err) {
+		return ImageSize{}, errors.Wrapf(err, "Error reading file %s", previousFile)
+	}
+	if client == nil {
+		return ImageSize{}, errors.Errorf("%s does not exist", previousFile)
+	}
+	log.Printf("%s does not exist, reading the size of %s:%s from the registry\n", previousFile, imageRepo, version)
+	return RegistryImageSize(client, imageRepo, version)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+)
+
+// pushSizedImage pushes an image with a gzipped layer of size bytes under the tag.
+func pushSizedImage(t *testing.T, client *registry.Client, repo string, tag string, size int) {
+	t.Helper()
+	var layer bytes.Buffer
+	writer := gzip.NewWriter(&layer)
+	writer.Write(bytes.Repeat([]byte("a"), size))
+	writer.Close()
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"history":      []map[string]string{{"created_by": "COPY . ."}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PushArtifact(repo, tag, registry.Artifact{
+		ConfigMediaType: registry.MediaTypeOCIConfig,
+		Config:          config,
+		Layers:          []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Data: layer.Bytes()}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckImageSize(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+	pushSizedImage(t, client, "team/app", "1.0.0", 1000)
+
+	for _, test := range []struct {
+		name            string
+		previousVersion string
+		// artifact is the size recorded in releases/<previous>/image.json, none when 0
+		artifact int64
+		budget   SizeBudget
+		size     int64
+		wantErr  string
+	}{
+		{name: "first release", size: 5000, budget: SizeBudget{MaxGrowthPercent: 10}},
+		{name: "artifact within growth", previousVersion: "0.9.0", artifact: 1000, size: 1100, budget: SizeBudget{MaxGrowthPercent: 10}},
+		{name: "artifact over growth", previousVersion: "0.9.0", artifact: 1000, size: 1101, budget: SizeBudget{MaxGrowthPercent: 10}, wantErr: "image grew 10.1% compared to 0.9.0"},
+		{name: "registry within growth", previousVersion: "1.0.0", size: 1050, budget: SizeBudget{MaxGrowthPercent: 10}},
+		{name: "registry over growth", previousVersion: "1.0.0", size: 1200, budget: SizeBudget{MaxGrowthPercent: 10}, wantErr: "image grew 20.0% compared to 1.0.0"},
+		{name: "no baseline with growth budget", previousVersion: "0.8.0", size: 1000, budget: SizeBudget{MaxGrowthPercent: 10}, wantErr: "Size of the previous release 0.8.0 is unknown"},
+		{name: "no baseline without growth budget", previousVersion: "0.8.0", size: 1000},
+		{name: "over max size", size: 2 * 1024 * 1024, budget: SizeBudget{MaxSize: "1Mi"}, wantErr: "exceeds the budget of 1Mi"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opsDir := t.TempDir()
+			if test.artifact > 0 {
+				data, _ := json.Marshal(ImageSize{Image: "team/app:" + test.previousVersion, Size: test.artifact})
+				writeFile(t, filepath.Join(opsDir, "app", "releases", test.previousVersion, imageSizeFile), string(data))
+			}
+			err := CheckImageSize(client, "team/app", opsDir, "app", "1.1.0", test.previousVersion, test.budget, ImageSize{Image: "team/app:1.1.0", Size: test.size})
+			if test.wantErr == "" && err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
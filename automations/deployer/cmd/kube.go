@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"deployer/pkg/manifests"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// helmInstanceLabel is the label the charts give to the resources of a release
+const helmInstanceLabel = "app.kubernetes.io/instance"
+
+// KubeRestConfig loads the kubeconfig the same way kubectl and helm do: KUBECONFIG, then
+// ~/.kube/config, with its current context.
+func KubeRestConfig() (*rest.Config, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading the kubeconfig")
+	}
+	return config, nil
+}
+
+// NewKubeClient returns a client for the cluster of the current kubeconfig context.
+func NewKubeClient() (kubernetes.Interface, error) {
+	config, err := KubeRestConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error creating the kubernetes client")
+	}
+	return client, nil
+}
+
+// EnsureNamespace creates the namespace when it does not exist, labelled with its owner so that
+// teardown knows the deployer created it. It returns whether the namespace was created.
+func EnsureNamespace(client kubernetes.Interface, namespace string, owner string) (bool, error) {
+	ctx := context.Background()
+	_, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err == nil {
+		return false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, errors.Wrapf(err, "Error getting namespace %s", namespace)
+	}
+	_, err = client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+			Labels: map[string]string{
+				manifests.OwnerLabel:     owner,
+				manifests.ManagedByLabel: manifests.ManagedBy,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	return err == nil, errors.Wrapf(err, "Error creating namespace %s", namespace)
+}
+
+// CreateOwnedNamespace creates the namespace of a release before helm does, so that it is
+// labelled with its owner. Without a cluster connection helm creates it, unlabelled.
+func CreateOwnedNamespace(namespace string, owner string, logger *log.Logger) {
+	client, err := NewKubeClient()
+	if err == nil {
+		var created bool
+		created, err = EnsureNamespace(client, namespace, owner)
+		if created {
+			logger.Printf("Created namespace %s\n", namespace)
+		}
+	}
+	if err != nil {
+		logger.Printf("Warning: namespace %s may be created unlabelled and kept on teardown: %v\n", namespace, err)
+	}
+}
+
+// DeleteOwnedNamespace deletes the namespace when the deployer created it for the owner. It
+// returns whether the namespace was deleted.
+func DeleteOwnedNamespace(client kubernetes.Interface, namespace string, owner string) (bool, error) {
+	ctx := context.Background()
+	live, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "Error getting namespace %s", namespace)
+	}
+	labels := live.GetLabels()
+	if labels[manifests.ManagedByLabel] != manifests.ManagedBy || labels[manifests.OwnerLabel] != owner {
+		return false, nil
+	}
+	err = client.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, errors.Wrapf(err, "Error deleting namespace %s", namespace)
+	}
+	return true, nil
+}
+
+// NamespacePVCs returns the names of the persistent volume claims of the namespace.
+func NamespacePVCs(client kubernetes.Interface, namespace string) ([]string, error) {
+	pvcs, err := client.CoreV1().PersistentVolumeClaims(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error listing the persistent volume claims of namespace %s", namespace)
+	}
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	return names, nil
+}
+
+// DeleteReleasePVCs deletes the persistent volume claims of the helm release, which helm
+// uninstall keeps, and returns their names.
+func DeleteReleasePVCs(client kubernetes.Interface, namespace string, releaseName string) ([]string, error) {
+	ctx := context.Background()
+	pvcs, err := client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: helmInstanceLabel + "=" + releaseName,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error listing the persistent volume claims of namespace %s", namespace)
+	}
+	var deleted []string
+	for _, pvc := range pvcs.Items {
+		err := client.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return deleted, errors.Wrapf(err, "Error deleting persistent volume claim %s/%s", namespace, pvc.Name)
+		}
+		deleted = append(deleted, pvc.Name)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"deployer/pkg/dockerlint"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.AddCommand(lintDockerfileCmd)
+
+	lintDockerfileCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
+	lintDockerfileCmd.MarkFlagRequired("application_directory")
+
+	// Optional, used to check the probes of the chart values and the build section
+	lintDockerfileCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Lint the application build files",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var lintDockerfileCmd = &cobra.Command{
+	Use:   "dockerfile",
+	Short: "Lint the application Dockerfile and build context",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runLintDockerfile(appDir, opsDir); err != nil {
+			log.Fatalf("Error linting Dockerfile: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func runLintDockerfile(appDir string, opsDir string) error {
+	if err := CheckIfPathExists(appDir); err != nil {
+		return errors.Wrapf(err, "Directory %s does not exist", appDir)
+	}
+	jsonData, err := GetFieldsFromPackageJSON(appDir, []string{"name"})
+	if err != nil {
+		return err
+	}
+	appName := jsonData["name"].(string)
+	var buildConfig BuildConfig
+	if opsDir != "" {
+		deployConfig, err := LoadDeployConfig(filepath.Join(opsDir, appName, "deploy.yaml"))
+		if err != nil {
+			return err
+		}
+		buildConfig = deployConfig.Build
+	}
+	return LintDockerfile(appDir, buildConfig.dockerfilePath(appDir), opsDir, appName)
+}
+
+// LintDockerfile lints the Dockerfile and the .dockerignore of the application, printing
+// every finding. It fails when any finding has the error severity.
+func LintDockerfile(appDir string, dockerfilePath string, opsDir string, appName string) error {
+	data, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading file %s", dockerfilePath)
+	}
+	dockerignore, err := os.ReadFile(filepath.Join(appDir, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Error reading .dockerignore")
+	}
+	hasProbes := false
+	if opsDir != "" {
+		if hasProbes, err = valuesDefineProbes(filepath.Join(opsDir, appName)); err != nil {
+			return err
+		}
+	}
+
+	findings := dockerlint.Lint(dockerlint.Parse(data), dockerlint.Options{
+		DockerfileName: dockerfilePath,
+		Dockerignore:   dockerignore,
+		HasProbes:      hasProbes,
+	})
+	errorCount := 0
+	for _, finding := range findings {
+		if finding.File == ".dockerignore" {
+			finding.File = filepath.Join(appDir, ".dockerignore")
+		}
+		fmt.Println(finding)
+		if finding.Severity == dockerlint.SeverityError {
+			errorCount++
+		}
+	}
+	log.Printf("Dockerfile lint: %d findings, %d errors\n", len(findings), errorCount)
+	if errorCount > 0 {
+		return errors.Errorf("Dockerfile lint found %d errors", errorCount)
+	}
+	return nil
+}
+
+// valuesDefineProbes reports whether any values.<env>.yaml of the application declares a liveness or readiness probe.
+func valuesDefineProbes(appOpsDir string) (bool, error) {
+	valuesFiles, err := filepath.Glob(filepath.Join(appOpsDir, "values.*.yaml"))
+	if err != nil {
+		return false, err
+	}
+	for _, valuesFile := range valuesFiles {
+		data, err := GetMapFromYamlFile(valuesFile)
+		if err != nil {
+			return false, err
+		}
+		if hasKey(data, "livenessProbe") || hasKey(data, "readinessProbe") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasKey searches the key in nested yaml maps.
+func hasKey(value interface{}, key string) bool {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			if k == key || hasKey(v, key) {
+				return true
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			if fmt.Sprint(k) == key || hasKey(v, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
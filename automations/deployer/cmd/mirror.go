@@ -0,0 +1,397 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"deployer/pkg/registry"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// mirrorFile is the file of a vendor directory mapping the chart images to their mirror
+const mirrorFile = "mirror.yaml"
+
+// containerKeys are the pod spec fields holding containers
+var containerKeys = map[string]bool{
+	"containers":          true,
+	"initContainers":      true,
+	"ephemeralContainers": true,
+}
+
+// MirrorConfig is the mirror section of infra.yaml: the private registry vendor images are copied to.
+type MirrorConfig struct {
+	// Registry is the registry host, e.g. localhost:5000
+	Registry string `yaml:"registry"`
+	// Prefix is prepended to the repository of mirrored images, e.g. mirror/bitnami/mysql
+	Prefix string `yaml:"prefix"`
+	// UsernameEnv and PasswordEnv are checked after the docker config and credential helpers
+	UsernameEnv string `yaml:"usernameEnv"`
+	PasswordEnv string `yaml:"passwordEnv"`
+}
+
+// MirroredImage maps an image referenced by a chart to its digest-pinned copy in the mirror.
+type MirroredImage struct {
+	Source string `yaml:"source"`
+	Mirror string `yaml:"mirror"`
+}
+
+// MirrorLock is the content of the mirror.yaml file of a vendor.
+type MirrorLock struct {
+	Registry string          `yaml:"registry"`
+	Images   []MirroredImage `yaml:"images"`
+}
+
+var mirrorLockFile string
+
+func init() {
+	vendorsMirrorCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsMirrorCmd.MarkFlagRequired("infrastructure_directory")
+	vendorsMirrorCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment whose values are used to render the charts")
+	vendorsMirrorCmd.MarkFlagRequired("target_environment")
+
+	vendorsPostRenderCmd.Flags().StringVarP(&mirrorLockFile, "mirror_file", "m", "", "the mirror.yaml file mapping the images")
+	vendorsPostRenderCmd.MarkFlagRequired("mirror_file")
+
+	vendorsCmd.AddCommand(vendorsMirrorCmd)
+	vendorsCmd.AddCommand(vendorsPostRenderCmd)
+}
+
+var vendorsMirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Copy the images of the vendor charts into the private registry",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsMirror(infrastructureDir, targetEnvironment); err != nil {
+			log.Fatalf("Error running vendors mirror: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// vendorsPostRenderCmd is run by helm as post-renderer, reading the manifests on stdin
+var vendorsPostRenderCmd = &cobra.Command{
+	Use:    "post-render",
+	Short:  "Rewrite the images of rendered manifests to their mirror, used as helm post-renderer",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsPostRender(mirrorLockFile, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("Error rewriting images: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// LoadInfraConfig reads and validates the infra.yaml file of the infrastructure directory.
+func LoadInfraConfig(infrastructureDir string) (InfraConfig, error) {
+	if err := CheckIfPathExists(infrastructureDir); err != nil {
+		return InfraConfig{}, errors.Wrapf(err, "Infrastructure directory %s does not exist", infrastructureDir)
+	}
+	infraConfigFile := filepath.Join(infrastructureDir, "infra.yaml")
+	infraConfigData, err := os.ReadFile(infraConfigFile)
+	if err != nil {
+		return InfraConfig{}, errors.Wrapf(err, "Failed to read file %s", infraConfigFile)
+	}
+	infraConfig, err := ParseInfraConfigFromYaml(infraConfigFile, infraConfigData)
+	if err != nil {
+		return infraConfig, errors.Wrapf(err, "Invalid file %s", infraConfigFile)
+	}
+	return infraConfig, nil
+}
+
+// RenderVendorChart renders the manifests of the vendor chart with helm template. The
+// environment placeholders of the values are left as they are, they do not affect images.
+func RenderVendorChart(vendorDir string, vendor VendorChartConfig, environment string) ([]byte, error) {
+	valuesFile := filepath.Join(vendorDir, fmt.Sprintf("values.%s.yaml", environment))
+	if err := CheckIfPathExists(valuesFile); err != nil {
+		return nil, errors.Wrapf(err, "Helm values file %s does not exist", valuesFile)
+	}
+	cmd := HelmCommand("template", vendor.ReleaseName, vendor.Chart, "--values", valuesFile, "--namespace", vendor.Namespace)
+	if vendor.Version != "" {
+		cmd.Args = append(cmd.Args, "--version", vendor.Version)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error rendering chart %s: %s", vendor.Chart, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// visitImages calls visit with the image of every container of the yaml value and replaces it with the result.
+func visitImages(value interface{}, inContainers bool, visit func(image string) string) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for k, item := range v {
+			key := fmt.Sprint(k)
+			if image, ok := item.(string); ok && inContainers && key == "image" {
+				v[k] = visit(image)
+				continue
+			}
+			visitImages(item, containerKeys[key], visit)
+		}
+	case []interface{}:
+		for _, item := range v {
+			visitImages(item, inContainers, visit)
+		}
+	}
+}
+
+// decodeManifests decodes a multi-document yaml stream, skipping empty documents.
+func decodeManifests(manifests []byte) ([]interface{}, error) {
+	var documents []interface{}
+	decoder := yaml.NewDecoder(bytes.NewReader(manifests))
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode manifests")
+		}
+		if document != nil {
+			documents = append(documents, document)
+		}
+	}
+}
+
+// ExtractImages returns the sorted container images referenced by the manifests.
+func ExtractImages(manifests []byte) ([]string, error) {
+	documents, err := decodeManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, document := range documents {
+		visitImages(document, false, func(image string) string {
+			found[image] = true
+			return image
+		})
+	}
+	var images []string
+	for image := range found {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// RewriteImages replaces the container images of the manifests found in the mirror lock.
+func RewriteImages(manifests []byte, lock MirrorLock) ([]byte, error) {
+	mirrors := map[string]string{}
+	for _, image := range lock.Images {
+		ref, err := registry.ParseReference(image.Source)
+		if err != nil {
+			return nil, err
+		}
+		mirrors[ref.String()] = image.Mirror
+	}
+	documents, err := decodeManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	for _, document := range documents {
+		visitImages(document, false, func(image string) string {
+			ref, err := registry.ParseReference(image)
+			if err == nil {
+				if mirror, ok := mirrors[ref.String()]; ok {
+					return mirror
+				}
+			}
+			log.Printf("Warning: image %s is not mirrored, run deployer vendors mirror\n", image)
+			return image
+		})
+		data, err := yaml.Marshal(document)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode manifest")
+		}
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+	return out.Bytes(), nil
+}
+
+// MirrorImage copies the image to the mirror registry, unless the mirror already has the same
+// digest, and returns the digest-pinned mirror reference.
+func MirrorImage(sources map[string]*registry.Client, mirror *registry.Client, config MirrorConfig, image string) (string, bool, error) {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return "", false, err
+	}
+	source, ok := sources[ref.Registry]
+	if !ok {
+		source = registry.NewClient(ref.Registry, "", "")
+		sources[ref.Registry] = source
+	}
+	target := registry.Reference{
+		Registry:   config.Registry,
+		Repository: path.Join(config.Prefix, ref.Repository),
+		Tag:        ref.Tag,
+	}
+
+	desc, err := source.HeadManifest(ref.Repository, ref.Reference())
+	if err != nil {
+		return "", false, errors.Wrapf(err, "Error resolving %s", image)
+	}
+	if ref.Digest != "" && ref.Digest != desc.Digest {
+		return "", false, errors.Errorf("%s resolved to digest %s", image, desc.Digest)
+	}
+	target.Digest = desc.Digest
+
+	existing, err := mirror.HeadManifest(target.Repository, target.Reference())
+	if err == nil && existing.Digest == desc.Digest {
+		return target.String(), false, nil
+	}
+	if err != nil && errors.Cause(err) != registry.ErrNotFound {
+		return "", false, err
+	}
+	if _, err := registry.CopyManifest(source, ref.Repository, desc.Digest, mirror, target.Repository, target.Reference()); err != nil {
+		return "", false, errors.Wrapf(err, "Error copying %s", image)
+	}
+	return target.String(), true, nil
+}
+
+func runVendorsMirror(infrastructureDir string, environment string) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	infraConfig, err := LoadInfraConfig(infrastructureDir)
+	if err != nil {
+		return err
+	}
+	if err := EnsureHelmRepositories(infraConfig, false); err != nil {
+		return err
+	}
+	infraConfig.Vendors.Charts, err = LockedVendorVersions(infrastructureDir, infraConfig.Vendors.Charts)
+	if err != nil {
+		return err
+	}
+	config := infraConfig.Mirror
+	if config.Registry == "" {
+		return errors.New("No mirror registry configured in the mirror section of infra.yaml")
+	}
+	mirror := NewReplicaClient(ReplicationTarget{
+		Registry:    config.Registry,
+		UsernameEnv: config.UsernameEnv,
+		PasswordEnv: config.PasswordEnv,
+	})
+	sources := map[string]*registry.Client{}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VENDOR\tSOURCE\tMIRROR\tSTATUS")
+	for _, vendor := range infraConfig.Vendors.Charts {
+		vendorDir := filepath.Join(infrastructureDir, "vendors", strings.ToLower(vendor.Name))
+		log.Printf("Rendering chart %s of vendor %s\n", vendor.Chart, vendor.Name)
+		pulled, _, cleanup, err := PullVendorChartTemp(infraConfig, vendor)
+		if err != nil {
+			return err
+		}
+		manifests, err := RenderVendorChart(vendorDir, pulled, environment)
+		cleanup()
+		if err != nil {
+			return err
+		}
+		images, err := ExtractImages(manifests)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading the manifests of %s", vendor.Name)
+		}
+
+		lock := MirrorLock{Registry: config.Registry}
+		for _, image := range images {
+			mirrored, copied, err := MirrorImage(sources, mirror, config, image)
+			if err != nil {
+				return err
+			}
+			status := "present"
+			if copied {
+				status = "copied"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", vendor.Name, image, mirrored, status)
+			lock.Images = append(lock.Images, MirroredImage{Source: image, Mirror: mirrored})
+		}
+
+		data, err := yaml.Marshal(lock)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode mirror file")
+		}
+		lockFile := filepath.Join(vendorDir, mirrorFile)
+		if err := os.WriteFile(lockFile, data, 0644); err != nil {
+			return errors.Wrapf(err, "Error writing file %s", lockFile)
+		}
+		log.Printf("Mirrored %d images of %s, written %s\n", len(images), vendor.Name, lockFile)
+	}
+	w.Flush()
+	return nil
+}
+
+// LoadMirrorLock reads a mirror.yaml file.
+func LoadMirrorLock(lockFile string) (MirrorLock, error) {
+	var lock MirrorLock
+	data, err := os.ReadFile(lockFile)
+	if err != nil {
+		return lock, errors.Wrapf(err, "Error reading file %s", lockFile)
+	}
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return lock, errors.Wrapf(err, "Error parsing file %s", lockFile)
+	}
+	return lock, nil
+}
+
+func runVendorsPostRender(lockFile string, in io.Reader, out io.Writer) error {
+	lock, err := LoadMirrorLock(lockFile)
+	if err != nil {
+		return err
+	}
+	manifests, err := io.ReadAll(in)
+	if err != nil {
+		return errors.Wrap(err, "Error reading manifests")
+	}
+	rewritten, err := RewriteImages(manifests, lock)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(rewritten)
+	return err
+}
+
+// MirrorPostRenderer returns the post-renderer rewriting the images of the vendor to their
+// mirror, or an empty path when the vendor has no mirror.yaml. The caller removes the script.
+func MirrorPostRenderer(vendorDir string) (string, error) {
+	lockFile := filepath.Join(vendorDir, mirrorFile)
+	if CheckIfPathExists(lockFile) != nil {
+		return "", nil
+	}
+	return PostRendererScript(lockFile)
+}
+
+// PostRendererScript writes a helm post-renderer script rewriting the images found in the
+// mirror file, since helm does not pass arguments to post-renderers. The caller removes the script.
+func PostRendererScript(lockFile string) (string, error) {
+	lockFile, err := filepath.Abs(lockFile)
+	if err != nil {
+		return "", err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "Error locating the deployer executable")
+	}
+	script := filepath.Join("/dev/shm", fmt.Sprintf("%s.sh", uuid.New()))
+	content := fmt.Sprintf("#!/bin/sh\nexec %q vendors post-render --mirror_file %q\n", executable, lockFile)
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		return "", errors.Wrapf(err, "Error writing file %s", script)
+	}
+	return script, nil
+}
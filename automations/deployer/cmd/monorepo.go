@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Statuses of an application in the monorepo release summary
+const (
+	releaseStatusReleased  = "released"
+	releaseStatusFailed    = "failed"
+	releaseStatusUnchanged = "unchanged"
+)
+
+// MonorepoApp is an application of the applications directory.
+type MonorepoApp struct {
+	Name    string
+	Version string
+	Dir     string
+}
+
+// AppRelease is the outcome of the release of an application.
+type AppRelease struct {
+	App      MonorepoApp
+	Status   string
+	Reason   string
+	Duration time.Duration
+	Err      error
+}
+
+// ReleaseTag returns the git tag marking the release of a version of the application.
+func ReleaseTag(appName string, version string) string {
+	return fmt.Sprintf("%s/v%s", appName, version)
+}
+
+// DiscoverApplications returns the applications of the applications directory, i.e. the
+// subdirectories holding a package.json.
+func DiscoverApplications(appsRoot string) ([]MonorepoApp, error) {
+	if err := CheckIfPathExists(appsRoot); err != nil {
+		return nil, errors.Wrapf(err, "Directory %s does not exist", appsRoot)
+	}
+	entries, err := os.ReadDir(appsRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading directory %s", appsRoot)
+	}
+	var apps []MonorepoApp
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(appsRoot, entry.Name())
+		if CheckIfPathExists(filepath.Join(dir, "package.json")) != nil {
+			continue
+		}
+		jsonData, err := GetFieldsFromPackageJSON(dir, []string{"name", "version"})
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, MonorepoApp{
+			Name:    jsonData["name"].(string),
+			Version: jsonData["version"].(string),
+			Dir:     dir,
+		})
+	}
+	return apps, nil
+}
+
+// gitIn runs git in the directory and returns its trimmed output.
+func gitIn(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", errors.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", errors.Wrapf(err, "git %s", strings.Join(args, " "))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// LastReleaseTag returns the most recent release tag of the application reachable from HEAD,
+// or an empty string when the application was never released.
+func LastReleaseTag(dir string, appName string) (string, error) {
+	tags, err := gitIn(dir, "tag", "--merged", "HEAD", "--list", ReleaseTag(appName, "*"), "--sort=-v:refname")
+	if err != nil {
+		return "", err
+	}
+	if tags == "" {
+		return "", nil
+	}
+	return strings.SplitN(tags, "\n", 2)[0], nil
+}
+
+// CreateReleaseTag tags HEAD with the release tag of the version.
+func CreateReleaseTag(dir string, appName string, version string) error {
+	tag := ReleaseTag(appName, version)
+	if _, err := gitIn(dir, "tag", "-a", tag, "-m", fmt.Sprintf("Release %s %s", appName, version)); err != nil {
+		return errors.Wrapf(err, "Error creating git tag %s", tag)
+	}
+	log.Printf("Created git tag %s, push it with: git push origin %s\n", tag, tag)
+	return nil
+}
+
+// ChangedFiles returns the files of the application and its ops config that changed since the
+// git ref, including uncommitted changes. Changes made by the deployer itself are ignored: the
+// release artifacts, the version bump of package.json and the release/deploy bookkeeping of
+// deploy.yaml.
+func ChangedFiles(app MonorepoApp, opsDir string, ref string) ([]string, error) {
+	root, err := gitIn(app.Dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	appPath, err := filepath.Abs(app.Dir)
+	if err != nil {
+		return nil, err
+	}
+	opsPath, err := filepath.Abs(filepath.Join(opsDir, app.Name))
+	if err != nil {
+		return nil, err
+	}
+	out, err := gitIn(root, "diff", "--name-only", ref, "--", appPath, opsPath, ":(exclude)"+filepath.Join(opsPath, "releases"))
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitIn(root, "ls-files", "--others", "--exclude-standard", "--", appPath, opsPath, ":(exclude)"+filepath.Join(opsPath, "releases"))
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, file := range strings.Split(out+"\n"+untracked, "\n") {
+		if file == "" {
+			continue
+		}
+		bookkeeping, err := onlyBookkeepingChanged(root, ref, file)
+		if err != nil {
+			return nil, err
+		}
+		if !bookkeeping {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// bookkeepingFields are the fields the deployer writes on release and deploy, by file name
+var bookkeepingFields = map[string][]string{
+	"package.json": {"version"},
+	"deploy.yaml":  {"latestReleaseVersion", "deployedVersions", "channels"},
+}
+
+// onlyBookkeepingChanged reports whether the file differs from the ref only by the fields
+// the deployer writes itself.
+func onlyBookkeepingChanged(root string, ref string, file string) (bool, error) {
+	fields, ok := bookkeepingFields[filepath.Base(file)]
+	if !ok {
+		return false, nil
+	}
+	before, err := gitIn(root, "show", fmt.Sprintf("%s:%s", ref, file))
+	if err != nil {
+		// The file did not exist at the ref
+		return false, nil
+	}
+	after, err := os.ReadFile(filepath.Join(root, file))
+	if err != nil {
+		// The file was deleted
+		return false, nil
+	}
+	unmarshal := yaml.Unmarshal
+	if filepath.Ext(file) == ".json" {
+		unmarshal = json.Unmarshal
+	}
+	var beforeData, afterData map[string]interface{}
+	if err := unmarshal([]byte(before), &beforeData); err != nil {
+		return false, nil
+	}
+	if err := unmarshal(after, &afterData); err != nil {
+		return false, nil
+	}
+	for _, field := range fields {
+		delete(beforeData, field)
+		delete(afterData, field)
+	}
+	return reflect.DeepEqual(beforeData, afterData), nil
+}
+
+// SelectChangedApplications returns the applications to release, with the reason, and the
+// unchanged ones. The changes of an application are computed since changedSince when it is
+// given, or else since its last release tag. Without changedSince and without a release tag
+// the application is always released.
+func SelectChangedApplications(apps []MonorepoApp, opsDir string, changedSince string) ([]AppRelease, []AppRelease, error) {
+	var selected, unchanged []AppRelease
+	for _, app := range apps {
+		ref := changedSince
+		if ref == "" {
+			tag, err := LastReleaseTag(app.Dir, app.Name)
+			if err != nil {
+				return nil, nil, err
+			}
+			ref = tag
+		}
+		if ref == "" {
+			selected = append(selected, AppRelease{App: app, Reason: "never released"})
+			continue
+		}
+		changed, err := ChangedFiles(app, opsDir, ref)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Error detecting changes of %s since %s", app.Name, ref)
+		}
+		if len(changed) == 0 {
+			unchanged = append(unchanged, AppRelease{App: app, Status: releaseStatusUnchanged, Reason: fmt.Sprintf("no changes since %s", ref)})
+			continue
+		}
+		log.Printf("%s changed since %s: %s\n", app.Name, ref, strings.Join(changed, ", "))
+		selected = append(selected, AppRelease{App: app, Reason: fmt.Sprintf("%d files changed since %s", len(changed), ref)})
+	}
+	return selected, unchanged, nil
+}
+
+// ReleaseApplications releases the applications with at most parallelism concurrent releases.
+// A successful release is tagged in git. Failures do not stop the other releases.
+func ReleaseApplications(releases []AppRelease, parallelism int, release func(app MonorepoApp) error) []AppRelease {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]AppRelease, len(releases))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := releases[i]
+				started := time.Now()
+				log.Printf("Releasing %s %s (%s)\n", result.App.Name, result.App.Version, result.Reason)
+				if err := release(result.App); err != nil {
+					result.Status, result.Err = releaseStatusFailed, err
+					log.Printf("Release of %s failed: %v\n", result.App.Name, err)
+				} else {
+					result.Status = releaseStatusReleased
+				}
+				result.Duration = time.Since(started).Round(time.Second)
+				results[i] = result
+			}
+		}()
+	}
+	for i := range releases {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// PrintReleaseSummary prints the outcome of every application.
+func PrintReleaseSummary(results []AppRelease) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APPLICATION\tVERSION\tSTATUS\tDURATION\tDETAILS")
+	for _, result := range results {
+		details := result.Reason
+		if result.Err != nil {
+			details = result.Err.Error()
+		}
+		duration := "-"
+		if result.Status != releaseStatusUnchanged {
+			duration = result.Duration.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.App.Name, result.App.Version, result.Status, duration, details)
+	}
+	w.Flush()
+}
+
+// runMonorepoRelease releases the changed applications of the applications directory.
+func runMonorepoRelease(
+	appsRoot string,
+	username string,
+	token string,
+	opsDir string,
+	changedSince string,
+	parallelism int,
+) error {
+	apps, err := DiscoverApplications(appsRoot)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d applications in %s\n", len(apps), appsRoot)
+	selected, unchanged, err := SelectChangedApplications(apps, opsDir, changedSince)
+	if err != nil {
+		return err
+	}
+	log.Printf("Releasing %d applications, %d unchanged\n", len(selected), len(unchanged))
+
+	results := ReleaseApplications(selected, parallelism, func(app MonorepoApp) error {
+		return runRelease(app.Dir, username, token, opsDir)
+	})
+	results = append(results, unchanged...)
+	PrintReleaseSummary(results)
+
+	var failed []string
+	for _, result := range results {
+		if result.Status == releaseStatusFailed {
+			failed = append(failed, result.App.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("%d releases failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// git runs git in the directory with a fixed identity and fails the test on error.
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectChangedApplications(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	opsDir := filepath.Join(root, "ops")
+	apps := []MonorepoApp{
+		{Name: "api", Version: "1.0.0", Dir: filepath.Join(root, "applications", "api")},
+		{Name: "web", Version: "1.0.0", Dir: filepath.Join(root, "applications", "web")},
+	}
+	git(t, root, "init", "-q")
+	for _, app := range apps {
+		writeFile(t, filepath.Join(app.Dir, "package.json"), `{"name":"`+app.Name+`","version":"1.0.0"}`)
+	}
+	git(t, root, "add", "-A")
+	git(t, root, "commit", "-q", "-m", "initial")
+	git(t, root, "tag", "base")
+	git(t, root, "tag", "-a", ReleaseTag("api", "1.0.0"), "-m", "Release api 1.0.0")
+
+	writeFile(t, filepath.Join(apps[0].Dir, "index.js"), "console.log('api')")
+	git(t, root, "add", "-A")
+	git(t, root, "commit", "-q", "-m", "change api")
+	git(t, root, "tag", "-a", ReleaseTag("api", "1.0.1"), "-m", "Release api 1.0.1")
+
+	names := func(releases []AppRelease) []string {
+		var names []string
+		for _, release := range releases {
+			names = append(names, release.App.Name)
+		}
+		return names
+	}
+
+	// Since the last release tag: api is unchanged since 1.0.1, web was never released
+	selected, unchanged, err := SelectChangedApplications(apps, opsDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(selected); len(got) != 1 || got[0] != "web" {
+		t.Errorf("selected %v, want [web]", got)
+	}
+	if got := names(unchanged); len(got) != 1 || got[0] != "api" {
+		t.Errorf("unchanged %v, want [api]", got)
+	}
+
+	// The given ref takes precedence over the release tags
+	selected, unchanged, err = SelectChangedApplications(apps, opsDir, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(selected); len(got) != 1 || got[0] != "api" {
+		t.Errorf("selected %v since base, want [api]", got)
+	}
+	if got := names(unchanged); len(got) != 1 || got[0] != "web" {
+		t.Errorf("unchanged %v since base, want [web]", got)
+	}
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deployer/pkg/provenance"
+	"deployer/pkg/registry"
+	"deployer/pkg/signing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// provenanceFile is the release artifact holding the signed provenance envelope
+const provenanceFile = "provenance.json"
+
+var verifyVersion string
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.AddCommand(verifyProvenanceCmd)
+
+	verifyProvenanceCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
+	verifyProvenanceCmd.MarkFlagRequired("application_directory")
+
+	verifyProvenanceCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	verifyProvenanceCmd.MarkFlagRequired("operations_directory")
+
+	verifyProvenanceCmd.Flags().StringVarP(&verifyVersion, "version", "v", "", "the released version of the application")
+	verifyProvenanceCmd.MarkFlagRequired("version")
+
+	verifyProvenanceCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "production", "the environment whose trusted keys are used")
+	verifyProvenanceCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	verifyProvenanceCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the supply chain metadata of released images",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var verifyProvenanceCmd = &cobra.Command{
+	Use:   "provenance",
+	Short: "Verify the signed build provenance of a released image",
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		client := registry.NewClient(registry.DockerHub, creds.Username, creds.Secret)
+		if err := runVerifyProvenance(client, appDir, opsDir, creds.Username, verifyVersion, targetEnvironment); err != nil {
+			log.Fatalf("Error verifying provenance: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// BuildInfo collects what is recorded in the provenance of a release.
+type BuildInfo struct {
+	StartedOn      time.Time
+	FinishedOn     time.Time
+	DockerfileHash string
+	BuildArgs      map[string]string
+	TrivyReport    string
+	TrivyPassed    bool
+}
+
+// HashFile returns the hex encoded SHA-256 of the file.
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", path)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// gitOutput runs git in the directory and returns the trimmed output, or an empty string on failure.
+func gitOutput(dir string, args ...string) string {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// NewProvenance builds the SLSA provenance statement of a released image.
+func NewProvenance(appDir string, imageName string, digest string, info BuildInfo) (provenance.Statement, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return provenance.Statement{}, errors.Wrap(err, "failed to get hostname")
+	}
+	commit := gitOutput(appDir, "rev-parse", "HEAD")
+	sourceURI := gitOutput(appDir, "config", "--get", "remote.origin.url")
+	if sourceURI == "" {
+		sourceURI = appDir
+	}
+	dirty := gitOutput(appDir, "status", "--porcelain", ".") != ""
+
+	var trivyDigest string
+	if info.TrivyReport != "" {
+		if trivyDigest, err = HashFile(info.TrivyReport); err != nil {
+			return provenance.Statement{}, err
+		}
+	}
+	buildArgs := map[string]interface{}{}
+	for key, value := range info.BuildArgs {
+		buildArgs[key] = value
+	}
+
+	source := provenance.ConfigSource{URI: "git+" + sourceURI, EntryPoint: "Dockerfile"}
+	materials := []provenance.Material{{URI: "Dockerfile", Digest: provenance.DigestSet{"sha256": info.DockerfileHash}}}
+	if commit != "" {
+		source.Digest = provenance.DigestSet{"sha1": commit}
+		materials = append(materials, provenance.Material{URI: source.URI, Digest: source.Digest})
+	}
+
+	return provenance.Statement{
+		Type:          provenance.StatementType,
+		PredicateType: provenance.PredicateType,
+		Subject: []provenance.Subject{{
+			Name:   imageName,
+			Digest: provenance.DigestSet{"sha256": strings.TrimPrefix(digest, "sha256:")},
+		}},
+		Predicate: provenance.Predicate{
+			Builder:   provenance.Builder{ID: fmt.Sprintf("deployer@%s", host)},
+			BuildType: provenance.BuildType,
+			Invocation: provenance.Invocation{
+				ConfigSource: source,
+				Parameters:   map[string]interface{}{"buildArgs": buildArgs},
+				Environment: map[string]interface{}{
+					"host":            host,
+					"deployerVersion": Version,
+					"sourceDirty":     dirty,
+					"trivy": map[string]interface{}{
+						"passed":       info.TrivyPassed,
+						"reportSha256": trivyDigest,
+					},
+				},
+			},
+			Metadata: provenance.Metadata{
+				BuildStartedOn:  info.StartedOn.UTC(),
+				BuildFinishedOn: info.FinishedOn.UTC(),
+				Completeness:    provenance.Completeness{Parameters: true},
+			},
+			Materials: materials,
+		},
+	}, nil
+}
+
+// AttachProvenance signs the provenance, keeps it as a release artifact and pushes it as an
+// attestation of the image digest.
+func AttachProvenance(
+	client *registry.Client,
+	imageRepo string,
+	image registry.Descriptor,
+	statement provenance.Statement,
+	keyFile string,
+	artifactsDir string,
+) error {
+	key, err := signing.LoadPrivateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal provenance")
+	}
+	envelope, err := signing.SignEnvelope(key, signing.InTotoPayloadType, payload)
+	if err != nil {
+		return err
+	}
+	envelopeData, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal provenance envelope")
+	}
+	artifact := filepath.Join(artifactsDir, provenanceFile)
+	if err := os.WriteFile(artifact, envelopeData, 0644); err != nil {
+		return errors.Wrapf(err, "Error writing file %s", artifact)
+	}
+	attDesc, err := signing.AttachAttestation(client, imageRepo, image, provenance.PredicateType, envelope)
+	if err != nil {
+		return errors.Wrapf(err, "Error pushing provenance of %s@%s", imageRepo, image.Digest)
+	}
+	log.Printf("Pushed provenance attestation %s referencing %s@%s\n", attDesc.Digest, imageRepo, image.Digest)
+	return nil
+}
+
+// ReleasedImageName returns the canonical name of a released image, the subject of its
+// provenance wherever the image is read from.
+func ReleasedImageName(imageRepo string) string {
+	return fmt.Sprintf("%s/%s", registry.DockerHub, imageRepo)
+}
+
+// VerifyProvenance checks that a provenance attestation of the image digest, read from the
+// repository of the client, is signed by one of the trusted keys, describes the image of the
+// canonical name and records a passing security scan.
+func VerifyProvenance(
+	client *registry.Client,
+	imageRepo string,
+	imageName string,
+	digest string,
+	keys []*ecdsa.PublicKey,
+) (provenance.Statement, error) {
+	envelopes, err := signing.FetchAttestations(client, imageRepo, digest, provenance.PredicateType)
+	if err != nil {
+		return provenance.Statement{}, err
+	}
+	var problems []string
+	for _, envelope := range envelopes {
+		payload, err := signing.VerifyEnvelope(keys, envelope)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		var statement provenance.Statement
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if err := statement.Validate(imageName, digest); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		trivy, _ := statement.Predicate.Invocation.Environment["trivy"].(map[string]interface{})
+		if passed, _ := trivy["passed"].(bool); !passed {
+			problems = append(problems, "the provenance does not record a passing security scan")
+			continue
+		}
+		return statement, nil
+	}
+	return provenance.Statement{}, errors.Errorf(
+		"no valid provenance for %s@%s: %s",
+		imageRepo,
+		digest,
+		strings.Join(problems, "; "),
+	)
+}
+
+func runVerifyProvenance(
+	client *registry.Client,
+	appDir string,
+	opsDir string,
+	namespace string,
+	version string,
+	environment string,
+) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	if err := CheckIfPathExists(appDir); err != nil {
+		return errors.Wrapf(err, "Directory %s does not exist", appDir)
+	}
+	jsonData, err := GetFieldsFromPackageJSON(appDir, []string{"name"})
+	if err != nil {
+		return err
+	}
+	appName := jsonData["name"].(string)
+	deployFile := filepath.Join(opsDir, appName, "deploy.yaml")
+	deployConfig, err := LoadDeployConfig(deployFile)
+	if err != nil {
+		return err
+	}
+	keys, err := deployConfig.TrustedKeys(filepath.Join(opsDir, appName), environment)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.Errorf("No trusted keys configured for %s in %s", environment, deployFile)
+	}
+
+	imageRepo := fmt.Sprintf("%s/%s", namespace, appName)
+	image, err := client.HeadManifest(imageRepo, version)
+	if err != nil {
+		return errors.Wrapf(err, "Image %s:%s not found", imageRepo, version)
+	}
+	statement, err := VerifyProvenance(client, imageRepo, ReleasedImageName(imageRepo), image.Digest, keys)
+	if err != nil {
+		return err
+	}
+	predicate := statement.Predicate
+	log.Printf("Provenance of %s:%s (%s) verified\n", imageRepo, version, image.Digest)
+	log.Printf("  builder: %s\n", predicate.Builder.ID)
+	log.Printf("  source: %s %s\n", predicate.Invocation.ConfigSource.URI, predicate.Invocation.ConfigSource.Digest["sha1"])
+	log.Printf("  deployer version: %v\n", predicate.Invocation.Environment["deployerVersion"])
+	log.Printf("  built: %s - %s\n", predicate.Metadata.BuildStartedOn.Format(time.RFC3339), predicate.Metadata.BuildFinishedOn.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+	"deployer/pkg/signing"
+)
+
+func TestVerifyDeployedImage(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+	const imageRepo = "team/app"
+	imageName := ReleasedImageName(imageRepo)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "release")
+	if err := runKeysGenerate(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := signing.LoadPublicKey(keyFile + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []*ecdsa.PublicKey{publicKey}
+
+	if _, err := VerifyDeployedImage(client, imageRepo, imageName, "1.0.0", "production", nil); err == nil {
+		t.Error("expected production to refuse a deploy without trusted keys")
+	}
+	if digest, err := VerifyDeployedImage(client, imageRepo, imageName, "1.0.0", "staging", nil); err != nil || digest != "" {
+		t.Errorf("got %q, %v, want an unverified deploy to staging", digest, err)
+	}
+
+	image, err := client.PushArtifact(imageRepo, "1.0.0", registry.Artifact{
+		Layers: []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Data: []byte("release")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignReleasedImage(client, imageRepo, image, keyFile+".key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed but without provenance
+	if digest, err := VerifyDeployedImage(client, imageRepo, imageName, "1.0.0", "staging", keys); err != nil || digest != image.Digest {
+		t.Errorf("got %q, %v, want the signed digest in staging", digest, err)
+	}
+	_, err = VerifyDeployedImage(client, imageRepo, imageName, "1.0.0", "production", keys)
+	if err == nil || !strings.Contains(err.Error(), "Provenance verification failed") {
+		t.Fatalf("got %v, want production to require the provenance", err)
+	}
+
+	now := time.Now()
+	statement, err := NewProvenance(dir, imageName, image.Digest, BuildInfo{
+		StartedOn:   now.Add(-time.Minute),
+		FinishedOn:  now,
+		TrivyPassed: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AttachProvenance(client, imageRepo, image, statement, keyFile+".key", dir); err != nil {
+		t.Fatal(err)
+	}
+	if digest, err := VerifyDeployedImage(client, imageRepo, imageName, "1.0.0", "production", keys); err != nil || digest != image.Digest {
+		t.Errorf("got %q, %v, want the verified digest in production", digest, err)
+	}
+
+	// A replica in another registry and repository serves the same subject
+	replica := registrytest.New()
+	defer replica.Close()
+	if result := ReplicateVersion(client, imageRepo, replica.Client(), "mirror/app", "1.0.0", nil); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if digest, err := VerifyDeployedImage(replica.Client(), "mirror/app", imageName, "1.0.0", "production", keys); err != nil || digest != image.Digest {
+		t.Errorf("got %q, %v, want the replica verified against the released name", digest, err)
+	}
+	if _, err := VerifyDeployedImage(replica.Client(), "mirror/app", ReleasedImageName("mirror/app"), "1.0.0", "production", keys); err == nil {
+		t.Error("expected the provenance to be rejected for another image name")
+	}
+}
@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/signing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keepLast      int
+	keepNewerThan time.Duration
+	keepRegex     string
+	dryRun        bool
+)
+
+func init() {
+	rootCmd.AddCommand(registryCmd)
+	registryCmd.AddCommand(registryPruneCmd)
+
+	registryPruneCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
+	registryPruneCmd.MarkFlagRequired("application_directory")
+
+	registryPruneCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	registryPruneCmd.MarkFlagRequired("operations_directory")
+
+	registryPruneCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	registryPruneCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+
+	registryPruneCmd.Flags().IntVar(&keepLast, "keep_last", 10, "keep the last N released versions")
+	registryPruneCmd.Flags().DurationVar(&keepNewerThan, "keep_newer_than", 0, "keep versions created within this duration, e.g. 720h")
+	registryPruneCmd.Flags().StringVar(&keepRegex, "keep_regex", "", "keep tags matching this regular expression")
+	registryPruneCmd.Flags().BoolVar(&dryRun, "dry_run", false, "only list the tags that would be deleted")
+}
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Manage the images in the private registry",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var registryPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete released image tags according to retention policies",
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		client := registry.NewClient(registry.DockerHub, creds.Username, creds.Secret)
+		policy := RetentionPolicy{
+			KeepLast:      keepLast,
+			KeepNewerThan: keepNewerThan,
+			KeepRegex:     keepRegex,
+		}
+		if err := runRegistryPrune(client, appDir, opsDir, creds.Username, policy, dryRun); err != nil {
+			log.Fatalf("Error running registry prune: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// RetentionPolicy decides which released tags are kept. A tag is kept when any rule matches.
+type RetentionPolicy struct {
+	KeepLast      int
+	KeepNewerThan time.Duration
+	KeepRegex     string
+}
+
+// TagRetention is the retention decision for a single tag.
+type TagRetention struct {
+	Tag     string
+	Digest  string
+	Created time.Time
+	Keep    bool
+	Reasons []string
+}
+
+func runRegistryPrune(
+	client *registry.Client,
+	appDir string,
+	opsDir string,
+	namespace string,
+	policy RetentionPolicy,
+	dryRun bool,
+) error {
+	log.Printf("Checking if the application directory exists: %s\n", appDir)
+	if err := CheckIfPathExists(appDir); err != nil {
+		return errors.Wrapf(err, "Directory %s does not exist", appDir)
+	}
+	jsonData, err := GetFieldsFromPackageJSON(appDir, []string{"name"})
+	if err != nil {
+		return err
+	}
+	appName := jsonData["name"].(string)
+
+	deployFile := filepath.Join(opsDir, appName, "deploy.yaml")
+	if err := CheckIfPathExists(deployFile); err != nil {
+		return errors.Wrapf(err, "File %s does not exist", deployFile)
+	}
+	referenced, err := GetReferencedVersions(deployFile)
+	if err != nil {
+		return err
+	}
+
+	imageRepo := fmt.Sprintf("%s/%s", namespace, appName)
+	log.Printf("Listing tags of %s/%s\n", client, imageRepo)
+	tags, err := client.ListTags(imageRepo)
+	if err != nil {
+		return err
+	}
+
+	retentions, err := PlanRetention(client, imageRepo, tags, referenced, policy)
+	if err != nil {
+		return err
+	}
+	printRetention(retentions)
+
+	// Deleting a manifest removes every tag pointing to it, so a digest is
+	// only deleted when none of its tags is kept
+	keptDigests := map[string]bool{}
+	for _, retention := range retentions {
+		if retention.Keep {
+			keptDigests[retention.Digest] = true
+		}
+	}
+	var digests []string
+	tagsByDigest := map[string][]string{}
+	for _, retention := range retentions {
+		if retention.Keep {
+			continue
+		}
+		if keptDigests[retention.Digest] {
+			log.Printf("Skipping tag %s, its digest %s is shared with a kept tag\n", retention.Tag, retention.Digest)
+			continue
+		}
+		if _, ok := tagsByDigest[retention.Digest]; !ok {
+			digests = append(digests, retention.Digest)
+		}
+		tagsByDigest[retention.Digest] = append(tagsByDigest[retention.Digest], retention.Tag)
+	}
+	var deleteErrors []string
+	for _, digest := range digests {
+		if err := DeleteReleasedImage(client, imageRepo, digest, tagsByDigest[digest], dryRun); err != nil {
+			deleteErrors = append(deleteErrors, err.Error())
+		}
+	}
+	if len(deleteErrors) > 0 {
+		return errors.Errorf("Failed to delete tags: %s", strings.Join(deleteErrors, "\n"))
+	}
+	log.Printf("Registry prune completed for %s\n", imageRepo)
+	return nil
+}
+
+// referrerTagSuffixes are the suffixes of the tags of the signature, SBOM and attestation of an image
+var referrerTagSuffixes = []string{signing.SignatureTagSuffix, sbomTagSuffix, signing.AttestationTagSuffix}
+
+// DeleteReleasedImage deletes the image with the digest and the tags pointing to it, then the
+// signature, SBOM and attestation tags of the digest so they are not left orphaned. Docker Hub
+// cannot delete manifests through the registry API, so its tags are deleted through the Hub API.
+func DeleteReleasedImage(client *registry.Client, imageRepo string, digest string, tags []string, dryRun bool) error {
+	var referrers []string
+	for _, suffix := range referrerTagSuffixes {
+		tag := registry.ReferrerTag(digest, suffix)
+		if _, err := client.HeadManifest(imageRepo, tag); err != nil {
+			if errors.Cause(err) == registry.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		referrers = append(referrers, tag)
+	}
+	if dryRun {
+		log.Printf("[dry-run] Would delete %s:%s (%s)\n", imageRepo, strings.Join(append(tags, referrers...), ", "), digest)
+		return nil
+	}
+
+	log.Printf("Deleting %s:%s (%s)\n", imageRepo, strings.Join(tags, ", "), digest)
+	if client.IsDockerHub() {
+		for _, tag := range tags {
+			if err := client.DeleteTag(imageRepo, tag); err != nil {
+				return err
+			}
+		}
+	} else if err := client.DeleteManifest(imageRepo, digest); err != nil {
+		return err
+	}
+	// The artifacts are deleted after their subject, so a failed delete never leaves an
+	// image without its signature
+	for _, tag := range referrers {
+		log.Printf("Deleting %s:%s\n", imageRepo, tag)
+		if err := client.DeleteTag(imageRepo, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referrerTagPattern matches the signature, SBOM and attestation tags of an image digest
+var referrerTagPattern = regexp.MustCompile(`^sha256-[0-9a-f]{64}\.`)
+
+// PlanRetention applies the retention policy to the tags of the repository.
+// Only tags that are semantic versions are candidates for deletion; other tags,
+// such as channels or signatures, are always kept, and so are the versions
+// whose digest a channel tag points to.
+func PlanRetention(
+	client *registry.Client,
+	imageRepo string,
+	tags []string,
+	referenced map[string]string,
+	policy RetentionPolicy,
+) ([]TagRetention, error) {
+	var keepPattern *regexp.Regexp
+	if policy.KeepRegex != "" {
+		var err error
+		keepPattern, err = regexp.Compile(policy.KeepRegex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid keep regex %s", policy.KeepRegex)
+		}
+	}
+	referencedBy := map[string][]string{}
+	for source, version := range referenced {
+		referencedBy[version] = append(referencedBy[version], source)
+	}
+
+	type versionTag struct {
+		tag     string
+		version *semver.Version
+	}
+	var versions []versionTag
+	var retentions []TagRetention
+	channels := map[string][]string{}
+	for _, tag := range tags {
+		version, err := semver.StrictNewVersion(tag)
+		if err == nil {
+			versions = append(versions, versionTag{tag: tag, version: version})
+			continue
+		}
+		retention := TagRetention{Tag: tag, Keep: true, Reasons: []string{"not a release tag"}}
+		if !referrerTagPattern.MatchString(tag) {
+			desc, err := client.HeadManifest(imageRepo, tag)
+			if err != nil {
+				return nil, err
+			}
+			retention.Digest = desc.Digest
+			channels[desc.Digest] = append(channels[desc.Digest], tag)
+		}
+		retentions = append(retentions, retention)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version.GreaterThan(versions[j].version)
+	})
+
+	now := time.Now()
+	for i, v := range versions {
+		desc, err := client.HeadManifest(imageRepo, v.tag)
+		if err != nil {
+			return nil, err
+		}
+		retention := TagRetention{Tag: v.tag, Digest: desc.Digest}
+		if channelTags, ok := channels[desc.Digest]; ok {
+			retention.Reasons = append(retention.Reasons, fmt.Sprintf("tagged %s", strings.Join(channelTags, ", ")))
+		}
+		if i < policy.KeepLast {
+			retention.Reasons = append(retention.Reasons, fmt.Sprintf("within last %d", policy.KeepLast))
+		}
+		if sources, ok := referencedBy[v.tag]; ok {
+			sort.Strings(sources)
+			retention.Reasons = append(retention.Reasons, fmt.Sprintf("referenced by %s", strings.Join(sources, ", ")))
+		}
+		if keepPattern != nil && keepPattern.MatchString(v.tag) {
+			retention.Reasons = append(retention.Reasons, fmt.Sprintf("matches %s", policy.KeepRegex))
+		}
+		if policy.KeepNewerThan > 0 {
+			config, err := client.GetImageConfig(imageRepo, desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			retention.Created = config.Created
+			if now.Sub(config.Created) < policy.KeepNewerThan {
+				retention.Reasons = append(retention.Reasons, fmt.Sprintf("newer than %s", policy.KeepNewerThan))
+			}
+		}
+		retention.Keep = len(retention.Reasons) > 0
+		retentions = append(retentions, retention)
+	}
+	return retentions, nil
+}
+
+func printRetention(retentions []TagRetention) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tDIGEST\tCREATED\tACTION\tREASON")
+	for _, retention := range retentions {
+		action := "delete"
+		if retention.Keep {
+			action = "keep"
+		}
+		created := "-"
+		if !retention.Created.IsZero() {
+			created = retention.Created.Format(time.RFC3339)
+		}
+		digest := retention.Digest
+		if digest == "" {
+			digest = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", retention.Tag, digest, created, action, strings.Join(retention.Reasons, "; "))
+	}
+	w.Flush()
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+	"deployer/pkg/signing"
+)
+
+// pushRelease pushes an image with the content under the tags and returns its descriptor.
+func pushRelease(t *testing.T, client *registry.Client, repo string, content string, tags ...string) registry.Descriptor {
+	t.Helper()
+	var desc registry.Descriptor
+	for _, tag := range tags {
+		var err error
+		desc, err = client.PushArtifact(repo, tag, registry.Artifact{
+			Layers: []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Data: []byte(content)}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return desc
+}
+
+func TestPlanRetentionKeepsChannelVersions(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+
+	stable := pushRelease(t, client, "team/app", "1.0.0", "1.0.0", "stable")
+	pushRelease(t, client, "team/app", "1.1.0", "1.1.0")
+	pushRelease(t, client, "team/app", "1.2.0", "1.2.0")
+	signature := registry.ReferrerTag(stable.Digest, signing.SignatureTagSuffix)
+	pushRelease(t, client, "team/app", "signature", signature)
+
+	tags, err := client.ListTags("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retentions, err := PlanRetention(client, "team/app", tags, nil, RetentionPolicy{KeepLast: 1})
+	if err != nil {
+		t.Fatalf("PlanRetention: %v", err)
+	}
+	byTag := map[string]TagRetention{}
+	for _, retention := range retentions {
+		byTag[retention.Tag] = retention
+	}
+	for tag, keep := range map[string]bool{"1.0.0": true, "1.1.0": false, "1.2.0": true, "stable": true, signature: true} {
+		if byTag[tag].Keep != keep {
+			t.Errorf("%s: got keep %v (%v), want %v", tag, byTag[tag].Keep, byTag[tag].Reasons, keep)
+		}
+	}
+	if byTag["stable"].Digest != stable.Digest {
+		t.Errorf("got digest %q for the channel, want %s", byTag["stable"].Digest, stable.Digest)
+	}
+	if reasons := strings.Join(byTag["1.0.0"].Reasons, "; "); reasons != "tagged stable" {
+		t.Errorf("got reasons %q for 1.0.0, want the channel", reasons)
+	}
+
+	// Pruning keeps the image of the channel and its signature
+	root := t.TempDir()
+	appDir := filepath.Join(root, "applications", "app")
+	opsDir := filepath.Join(root, "ops")
+	writeFile(t, filepath.Join(appDir, "package.json"), `{"name":"app","version":"1.2.0"}`)
+	writeFile(t, filepath.Join(opsDir, "app", "deploy.yaml"), "deployedVersions: {}\n")
+	if err := runRegistryPrune(client, appDir, opsDir, "team", RetentionPolicy{KeepLast: 1}, false); err != nil {
+		t.Fatalf("runRegistryPrune: %v", err)
+	}
+	for _, tag := range []string{"1.0.0", "stable", signature, "1.2.0"} {
+		if _, ok := server.Manifest("team/app", tag); !ok {
+			t.Errorf("%s was deleted", tag)
+		}
+	}
+	if _, ok := server.Manifest("team/app", "1.1.0"); ok {
+		t.Error("1.1.0 was kept")
+	}
+}
@@ -1,55 +1,75 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"deployer/pkg/registry"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v2"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
 
 var (
-	username string
-	token    string
+	username         string
+	passwordStdin    bool
+	securityBaseline string
+	signingKey       string
+	builderName      string
+	appsRoot         string
+	changedSince     string
+	releaseAll       bool
+	parallelism      int
+	releaseChannels  []string
 )
 
 func init() {
 	rootCmd.AddCommand(releaseCmd)
 
 	releaseCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
-	releaseCmd.MarkFlagRequired("application_directory")
 
 	releaseCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
 	releaseCmd.MarkFlagRequired("operations_directory")
 
-	releaseCmd.Flags().StringVarP(&username, "username", "u", os.Getenv("DOCKER_USERNAME"), "the username to access the private repository")
-	releaseCmd.MarkFlagRequired("username")
+	releaseCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	releaseCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+	releaseCmd.Flags().StringVar(&builderName, "builder", "", "the image builder: docker, buildx, podman or buildah (defaults to build.builder in deploy.yaml)")
+	releaseCmd.Flags().StringVar(&signingKey, "signing_key", os.Getenv("DEPLOYER_SIGNING_KEY"), "the private key used to sign the image digest")
+	releaseCmd.Flags().StringVar(&appsRoot, "applications_root", "", "the directory holding the applications, used with --all and --changed-since")
+	releaseCmd.Flags().BoolVar(&releaseAll, "all", false, "release every application of --applications_root changed since its last release tag")
+	releaseCmd.Flags().StringVar(&changedSince, "changed-since", "", "release the applications of --applications_root changed since this git ref instead of since their last release tag")
+	releaseCmd.Flags().IntVar(&parallelism, "parallelism", 2, "the maximum number of concurrent releases with --all and --changed-since")
+	releaseCmd.Flags().StringSliceVar(&releaseChannels, "channel", nil, "the channel tags to move to the released version, e.g. beta or stable")
+	releaseCmd.Flags().StringVar(&securityBaseline, "security_baseline", "", "only fail on vulnerabilities that are new relative to the version deployed to this environment")
 }
 
 var releaseCmd = &cobra.Command{
 	Use:   "release",
 	Short: "Release the application",
 	Run: func(cmd *cobra.Command, args []string) {
-		token := os.Getenv("DOCKER_PASSWORD")
-		if token == "" {
-			log.Print("Insert the docker password/token below: \n")
-			scanner := bufio.NewScanner(os.Stdin)
-			scanner.Scan()
-			err := scanner.Err()
-			if err != nil {
-				log.Fatal(err)
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		if releaseAll || changedSince != "" {
+			if appsRoot == "" {
+				log.Fatalf("Error: --applications_root is required with --all and --changed-since\n")
+			}
+			if err := runMonorepoRelease(appsRoot, creds.Username, creds.Secret, opsDir, changedSince, parallelism); err != nil {
+				log.Fatalf("Error running release process: %v\n", err)
+				os.Exit(1)
 			}
-			token = scanner.Text()
-		} else {
-			log.Printf("Using Docker token from environment variable DOCKER_PASSWORD\n")
+			return
 		}
-		if err := runRelease(appDir, username, token, opsDir); err != nil {
+		if appDir == "" {
+			log.Fatalf("Error: --application_directory is required\n")
+		}
+		if err := runRelease(appDir, creds.Username, creds.Secret, opsDir); err != nil {
 			log.Fatalf("Error running release process: %v\n", err)
 			os.Exit(1)
 		}
@@ -63,6 +83,7 @@ func runRelease(
 	opsDir string,
 ) error {
 	log.Printf("Starting release process for application in directory: %s\n", appDir)
+	buildInfo := BuildInfo{StartedOn: time.Now(), BuildArgs: map[string]string{}}
 
 	log.Printf("Checking if the application directory exists: %s\n", appDir)
 	if err := CheckIfPathExists(appDir); err != nil {
@@ -87,6 +108,19 @@ func runRelease(
 	appName := jsonData["name"].(string)
 	log.Printf("Read package.json: name=%s, version=%s\n", appName, version)
 
+	deployFile := filepath.Join(opsDir, appName, "deploy.yaml")
+	if err := CheckIfPathExists(deployFile); err != nil {
+		return errors.Wrapf(err, "File %s does not exist", deployFile)
+	}
+	deployConfig, err := LoadDeployConfig(deployFile)
+	if err != nil {
+		return err
+	}
+	securityPolicy, err := deployConfig.SecurityPolicy()
+	if err != nil {
+		return errors.Wrapf(err, "Invalid security section in %s", deployFile)
+	}
+
 	// Check if the image tag already exists on DockerHub private repository
 	log.Printf("Checking if image tag '%s' already exists on DockerHub private repository\n", version)
 	imageRepo := fmt.Sprintf("%s/%s", username, appName)
@@ -98,38 +132,163 @@ func runRelease(
 	if exists {
 		return errors.Errorf("Image tag '%s' already exists on DockerHub private repository", version)
 	}
+	builder, err := NewImageBuilder(builderName, deployConfig.Build)
+	if err != nil {
+		return err
+	}
+	log.Printf("Linting the Dockerfile of application: %s\n", appName)
+	if err := LintDockerfile(appDir, deployConfig.Build.dockerfilePath(appDir), opsDir, appName); err != nil {
+		return err
+	}
+
+	dockerfileHash, err := HashFile(deployConfig.Build.dockerfilePath(appDir))
+	if err != nil {
+		return err
+	}
+	buildInfo.DockerfileHash = dockerfileHash
+	for key, value := range deployConfig.Build.Args {
+		buildInfo.BuildArgs[key] = value
+	}
+
 	// Build the Docker image
-	log.Printf("Building Docker image for application: %s\n", appName)
-	err = BuildDockerImage(appDir, imageName)
+	log.Printf("Building image for application %s with %s\n", appName, builder.Name())
+	images, err := builder.Build(appDir, imageName)
+	defer builder.Close()
 	if err != nil {
 		return err
 	}
-	log.Printf("Built Docker image: %s\n", imageName)
+	log.Printf("Built image: %s\n", imageName)
+
+	// The budget applies to the largest platform
+	var imageSize ImageSize
+	for _, image := range images {
+		size, err := image.ImageSize()
+		if err != nil {
+			return err
+		}
+		PrintImageSize(size)
+		if size.Size >= imageSize.Size {
+			imageSize = size
+		}
+	}
+	client := registry.NewClient(registry.DockerHub, username, token)
+	if err := CheckImageSize(
+		client,
+		imageRepo,
+		opsDir,
+		appName,
+		version,
+		deployConfig.LatestReleaseVersion,
+		deployConfig.Build.SizeBudget,
+		imageSize,
+	); err != nil {
+		return err
+	}
 
-	err = RunTrivy(imageName)
+	artifactsDir, err := ReleaseArtifactsDir(opsDir, appName, version)
 	if err != nil {
 		return err
 	}
-	log.Printf("Ran Trivy for security checks on Docker image: %s\n", imageName)
+	reportFile := filepath.Join(artifactsDir, "trivy-report.json")
+	report, err := ScanBuiltImages(images, reportFile)
+	if err != nil {
+		return err
+	}
+	log.Printf("Ran Trivy for security checks on Docker image: %s, full report: %s\n", imageName, reportFile)
+	if securityBaseline != "" {
+		securityPolicy.Baseline = securityBaseline
+	}
+	if err := RunSecurityGate(client, imageRepo, opsDir, appName, version, deployConfig, securityPolicy, report); err != nil {
+		return err
+	}
+	buildInfo.TrivyReport = reportFile
+	buildInfo.TrivyPassed = true
+
+	sbomPaths := map[string]string{}
+	for _, image := range images {
+		sbomPath, err := GenerateSBOM(image, artifactsDir)
+		if err != nil {
+			return err
+		}
+		sbomPaths[image.Platform] = sbomPath
+		log.Printf("Generated SBOM: %s\n", sbomPath)
+	}
+
+	// Version tags are immutable, check again right before pushing in case the tag was pushed during the build
+	if err := EnsureTagIsNew(client, imageRepo, version); err != nil {
+		return err
+	}
 
 	// Push the Docker image to the private repository
-	err = PushDockerImage(imageName)
+	err = builder.Push(client, imageName)
 	if err != nil {
 		return err
 	}
 	log.Printf("Pushed Docker image to the private repository: %s\n", imageName)
 
-	deployFile := filepath.Join(opsDir, appName, "deploy.yaml")
-	if err := CheckIfPathExists(deployFile); err != nil {
-		return errors.Wrapf(err, "File %s does not exist", deployFile)
+	image, err := client.HeadManifest(imageRepo, version)
+	if err != nil {
+		return errors.Wrapf(err, "Error resolving the digest of %s", imageName)
 	}
+	// Every pushed platform must be one that was scanned, the SBOM and signature of each platform
+	// reference its digest
+	subjects, err := PushedPlatforms(client, imageRepo, image, images)
+	if err != nil {
+		return err
+	}
+	for _, built := range images {
+		subject := subjects[built.Platform]
+		sbomDesc, err := PushSBOM(client, imageRepo, subject, sbomPaths[built.Platform])
+		if err != nil {
+			return err
+		}
+		log.Printf("Pushed SBOM %s referencing %s@%s\n", sbomDesc.Digest, imageRepo, subject.Digest)
+	}
+
+	buildInfo.FinishedOn = time.Now()
+	if signingKey != "" {
+		if err := SignReleasedImage(client, imageRepo, image, signingKey); err != nil {
+			return err
+		}
+		for _, built := range images {
+			subject := subjects[built.Platform]
+			if subject.Digest == image.Digest {
+				continue
+			}
+			if err := SignReleasedImage(client, imageRepo, subject, signingKey); err != nil {
+				return err
+			}
+		}
+		statement, err := NewProvenance(appDir, ReleasedImageName(imageRepo), image.Digest, buildInfo)
+		if err != nil {
+			return err
+		}
+		if err := AttachProvenance(client, imageRepo, image, statement, signingKey, artifactsDir); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("Warning: no signing key given, %s@%s is not signed and has no provenance\n", imageRepo, image.Digest)
+	}
+
+	channels, err := ReleaseChannels(client, imageRepo, version, releaseChannels)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if _, err := MoveChannel(client, imageRepo, channel, version); err != nil {
+			return err
+		}
+	}
+	if err := RecordChannels(deployFile, channels, version); err != nil {
+		return err
+	}
+
 	yamlData, err := GetMapFromYamlFile(deployFile)
 	if err != nil {
 		return errors.Wrapf(err, "Error reading YAML file %s", deployFile)
 	}
 	yamlData["latestReleaseVersion"] = version
-	newYamlData, err := yaml.Marshal(yamlData)
-	if err := os.WriteFile(deployFile, newYamlData, 0644); err != nil {
+	if err := WriteMapToYamlFile(deployFile, yamlData); err != nil {
 		return errors.Wrapf(err, "Error writing YAML file %s", deployFile)
 	}
 	log.Printf("Updated %s with latestReleaseVersion=%s\n", deployFile, version)
@@ -139,6 +298,22 @@ func runRelease(
 	if err != nil {
 		return err
 	}
+
+	// Tag the release so the next monorepo release only picks the application when it changed
+	if err := CreateReleaseTag(appDir, appName, version); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+	// Copy the image to the disaster recovery registries, failures are retried with registry sync
+	if targets := deployConfig.Replication.Targets; len(targets) > 0 {
+		releasedChannels := map[string]string{}
+		for _, channel := range channels {
+			releasedChannels[channel] = version
+		}
+		results := ReplicateVersions(client, imageRepo, targets, []string{version}, releasedChannels)
+		if err := PrintReplication(results); err != nil {
+			return errors.Wrap(err, "Version released but not replicated, retry with deployer registry sync")
+		}
+	}
 	log.Printf("Release process completed for version %s\n", version)
 	return nil
 }
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"deployer/pkg/credentials"
+	"deployer/pkg/registry"
+	"deployer/pkg/signing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of a version in the replication report
+const (
+	replicationStatusCopied  = "replicated"
+	replicationStatusPresent = "present"
+	replicationStatusFailed  = "failed"
+)
+
+// ReplicationTarget is an additional registry released images are copied to.
+type ReplicationTarget struct {
+	// Registry is the registry host, e.g. ghcr.io or registry.example.com:5000
+	Registry string `yaml:"registry" schema:"required"`
+	// Repository defaults to the repository of the image in Docker Hub, <username>/<app>
+	Repository string `yaml:"repository"`
+	// UsernameEnv and PasswordEnv are checked after the docker config and credential helpers
+	UsernameEnv string `yaml:"usernameEnv"`
+	PasswordEnv string `yaml:"passwordEnv"`
+}
+
+// ReplicationConfig is the replication section of deploy.yaml.
+type ReplicationConfig struct {
+	Targets []ReplicationTarget `yaml:"targets"`
+}
+
+// ReplicationResult is the outcome of the replication of a version to a target.
+type ReplicationResult struct {
+	Registry   string
+	Repository string
+	Version    string
+	Digest     string
+	Status     string
+	Err        error
+}
+
+var syncVersions []string
+
+func init() {
+	registryCmd.AddCommand(registrySyncCmd)
+
+	registrySyncCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
+	registrySyncCmd.MarkFlagRequired("application_directory")
+
+	registrySyncCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	registrySyncCmd.MarkFlagRequired("operations_directory")
+
+	registrySyncCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	registrySyncCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+	registrySyncCmd.Flags().StringSliceVar(&syncVersions, "versions", nil, "the versions to replicate (defaults to every released version)")
+}
+
+var registrySyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Replicate the released versions to the registries listed in deploy.yaml",
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		client := registry.NewClient(registry.DockerHub, creds.Username, creds.Secret)
+		if err := runRegistrySync(client, appDir, opsDir, creds.Username, syncVersions); err != nil {
+			log.Fatalf("Error running registry sync: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// NewReplicaClient returns a client for the target, resolving its credentials like docker does.
+// Without credentials the registry is accessed anonymously.
+func NewReplicaClient(target ReplicationTarget) *registry.Client {
+	creds, err := credentials.Resolve(credentials.Options{
+		Server:      target.Registry,
+		UsernameEnv: target.UsernameEnv,
+		PasswordEnv: target.PasswordEnv,
+	})
+	if err != nil {
+		log.Printf("Warning: no credentials for %s, accessing it anonymously: %v\n", target.Registry, err)
+	}
+	return registry.NewClient(target.Registry, creds.Username, creds.Secret)
+}
+
+// ReplicateVersion copies the version of the image, its signature, SBOM and attestation, and
+// those of its platform images, to the target repository by digest and moves the given
+// channels there. An existing version tag is
+// never overwritten: it must already have the same digest.
+func ReplicateVersion(
+	source *registry.Client,
+	sourceRepo string,
+	target *registry.Client,
+	targetRepo string,
+	version string,
+	channels []string,
+) ReplicationResult {
+	result := ReplicationResult{Registry: target.Registry, Repository: targetRepo, Version: version}
+	fail := func(err error) ReplicationResult {
+		result.Status, result.Err = replicationStatusFailed, err
+		return result
+	}
+
+	image, err := source.HeadManifest(sourceRepo, version)
+	if err != nil {
+		return fail(errors.Wrapf(err, "Version %s of %s not found", version, sourceRepo))
+	}
+	result.Digest = image.Digest
+
+	existing, err := target.HeadManifest(targetRepo, version)
+	switch {
+	case err == nil && existing.Digest != image.Digest:
+		return fail(errors.Errorf("%s:%s has digest %s instead of %s and version tags are immutable", targetRepo, version, existing.Digest, image.Digest))
+	case err == nil:
+		result.Status = replicationStatusPresent
+	case errors.Cause(err) != registry.ErrNotFound:
+		return fail(err)
+	default:
+		if _, err := registry.CopyManifest(source, sourceRepo, version, target, targetRepo, version); err != nil {
+			return fail(err)
+		}
+		copied, err := target.HeadManifest(targetRepo, version)
+		if err != nil {
+			return fail(err)
+		}
+		if copied.Digest != image.Digest {
+			return fail(errors.Errorf("%s:%s has digest %s after the copy, expected %s", targetRepo, version, copied.Digest, image.Digest))
+		}
+		result.Status = replicationStatusCopied
+	}
+
+	// The platform images of an index have their own SBOM and attestation
+	subjects := []string{image.Digest}
+	manifest, _, err := source.GetImageManifest(sourceRepo, image.Digest)
+	if err != nil {
+		return fail(err)
+	}
+	if manifest.IsIndex() {
+		for _, platform := range manifest.Manifests {
+			subjects = append(subjects, platform.Digest)
+		}
+	}
+	for _, subject := range subjects {
+		for _, suffix := range []string{signing.SignatureTagSuffix, sbomTagSuffix, signing.AttestationTagSuffix} {
+			tag := registry.ReferrerTag(subject, suffix)
+			if _, err := source.HeadManifest(sourceRepo, tag); err != nil {
+				if errors.Cause(err) == registry.ErrNotFound {
+					continue
+				}
+				return fail(err)
+			}
+			if _, err := registry.CopyManifest(source, sourceRepo, tag, target, targetRepo, tag); err != nil {
+				return fail(errors.Wrapf(err, "Error copying %s", tag))
+			}
+		}
+	}
+
+	for _, channel := range channels {
+		if err := ValidateChannel(channel); err != nil {
+			return fail(err)
+		}
+		if _, err := registry.CopyManifest(source, sourceRepo, version, target, targetRepo, channel); err != nil {
+			return fail(errors.Wrapf(err, "Error moving channel %s", channel))
+		}
+	}
+	return result
+}
+
+// ReplicateVersions replicates the versions to every target and returns a result per target and version.
+func ReplicateVersions(
+	source *registry.Client,
+	sourceRepo string,
+	targets []ReplicationTarget,
+	versions []string,
+	channels map[string]string,
+) []ReplicationResult {
+	channelsOf := map[string][]string{}
+	for channel, version := range channels {
+		channelsOf[version] = append(channelsOf[version], channel)
+	}
+	var results []ReplicationResult
+	for _, target := range targets {
+		targetRepo := target.Repository
+		if targetRepo == "" {
+			targetRepo = sourceRepo
+		}
+		client := NewReplicaClient(target)
+		for _, version := range versions {
+			sort.Strings(channelsOf[version])
+			log.Printf("Replicating %s:%s to %s/%s\n", sourceRepo, version, target.Registry, targetRepo)
+			results = append(results, ReplicateVersion(source, sourceRepo, client, targetRepo, version, channelsOf[version]))
+		}
+	}
+	return results
+}
+
+// PrintReplication prints the replication report and returns an error when a replication failed.
+func PrintReplication(results []ReplicationResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REGISTRY\tREPOSITORY\tVERSION\tDIGEST\tSTATUS")
+	var failed []string
+	for _, result := range results {
+		status := result.Status
+		if result.Err != nil {
+			status = fmt.Sprintf("%s: %v", status, result.Err)
+			failed = append(failed, fmt.Sprintf("%s/%s:%s", result.Registry, result.Repository, result.Version))
+		}
+		digest := result.Digest
+		if digest == "" {
+			digest = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Registry, result.Repository, result.Version, digest, status)
+	}
+	w.Flush()
+	if len(failed) > 0 {
+		return errors.Errorf("Replication failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func runRegistrySync(client *registry.Client, appDir string, opsDir string, namespace string, versions []string) error {
+	if err := CheckIfPathExists(appDir); err != nil {
+		return errors.Wrapf(err, "Directory %s does not exist", appDir)
+	}
+	jsonData, err := GetFieldsFromPackageJSON(appDir, []string{"name"})
+	if err != nil {
+		return err
+	}
+	appName := jsonData["name"].(string)
+	deployConfig, err := LoadDeployConfig(filepath.Join(opsDir, appName, "deploy.yaml"))
+	if err != nil {
+		return err
+	}
+	if len(deployConfig.Replication.Targets) == 0 {
+		return errors.Errorf("No replication targets in the deploy.yaml of %s", appName)
+	}
+
+	imageRepo := fmt.Sprintf("%s/%s", namespace, appName)
+	if len(versions) == 0 {
+		tags, err := client.ListTags(imageRepo)
+		if err != nil {
+			return err
+		}
+		var released []*semver.Version
+		for _, tag := range tags {
+			if version, err := semver.StrictNewVersion(tag); err == nil {
+				released = append(released, version)
+			}
+		}
+		sort.Sort(semver.Collection(released))
+		for _, version := range released {
+			versions = append(versions, version.Original())
+		}
+	}
+	log.Printf("Replicating %d versions of %s to %d registries\n", len(versions), imageRepo, len(deployConfig.Replication.Targets))
+	results := ReplicateVersions(client, imageRepo, deployConfig.Replication.Targets, versions, deployConfig.Channels)
+	return PrintReplication(results)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+	"deployer/pkg/signing"
+)
+
+func TestReplicateVersionCopiesPlatformReferrers(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	source := server.Client()
+	replica := registrytest.New()
+	defer replica.Close()
+	target := replica.Client()
+
+	layout, platforms := writeBuildxLayout(t)
+	builder := &buildxBuilder{layout: &layout}
+	if err := builder.Push(source, "localhost/team/app:1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := source.HeadManifest("team/app", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrers := []string{registry.ReferrerTag(index.Digest, signing.SignatureTagSuffix)}
+	for _, platform := range platforms {
+		referrers = append(referrers,
+			registry.ReferrerTag(platform.Digest, sbomTagSuffix),
+			registry.ReferrerTag(platform.Digest, signing.AttestationTagSuffix),
+		)
+	}
+	for _, tag := range referrers {
+		pushRelease(t, source, "team/app", tag, tag)
+	}
+
+	result := ReplicateVersion(source, "team/app", target, "mirror/app", "1.0.0", []string{"stable"})
+	if result.Err != nil || result.Status != replicationStatusCopied || result.Digest != index.Digest {
+		t.Fatalf("got %+v, want the index replicated", result)
+	}
+	for _, tag := range append([]string{"1.0.0", "stable"}, referrers...) {
+		if _, ok := replica.Manifest("mirror/app", tag); !ok {
+			t.Errorf("%s was not replicated", tag)
+		}
+	}
+
+	// Replicating again finds the version in place and refreshes its referrers
+	if result := ReplicateVersion(source, "team/app", target, "mirror/app", "1.0.0", nil); result.Err != nil || result.Status != replicationStatusPresent {
+		t.Errorf("got %+v, want the version present", result)
+	}
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/trivy"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// sbomFile is the release artifact holding the CycloneDX SBOM of the image
+	sbomFile = "sbom.cdx.json"
+	// sbomMediaType is the artifact type of the SBOM pushed to the registry
+	sbomMediaType = "application/vnd.cyclonedx+json"
+	// sbomTagSuffix is the suffix of the tag the SBOM is stored under
+	sbomTagSuffix = "sbom"
+)
+
+var (
+	sbomVersion    string
+	sbomPlatform   string
+	sbomOutputFile string
+)
+
+func init() {
+	rootCmd.AddCommand(sbomCmd)
+	sbomCmd.AddCommand(sbomGetCmd)
+
+	sbomGetCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
+	sbomGetCmd.MarkFlagRequired("application_directory")
+
+	sbomGetCmd.Flags().StringVarP(&sbomVersion, "version", "v", "", "the released version of the application")
+	sbomGetCmd.MarkFlagRequired("version")
+
+	sbomGetCmd.Flags().StringVar(&sbomPlatform, "platform", "", "the platform of the SBOM for multi-platform images, e.g. linux/arm64")
+	sbomGetCmd.Flags().StringVarP(&sbomOutputFile, "output_file", "f", "", "write the SBOM to this file instead of stdout")
+	sbomGetCmd.Flags().StringVarP(&username, "username", "u", "", "the username to access the private repository (defaults to DOCKER_USERNAME or the docker config)")
+	sbomGetCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the docker password/token from stdin")
+}
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom",
+	Short: "Manage the SBOM of released images",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var sbomGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Fetch the SBOM of a released image from the registry",
+	Run: func(cmd *cobra.Command, args []string) {
+		creds, err := ResolveDockerCredentials(username, passwordStdin)
+		if err != nil {
+			log.Fatalf("Error resolving docker credentials: %v\n", err)
+		}
+		client := registry.NewClient(registry.DockerHub, creds.Username, creds.Secret)
+		if err := runSbomGet(client, appDir, creds.Username, sbomVersion, sbomPlatform, sbomOutputFile); err != nil {
+			log.Fatalf("Error fetching SBOM: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// GenerateSBOM writes the CycloneDX SBOM of the built image to the release artifacts directory.
+func GenerateSBOM(image BuiltImage, artifactsDir string) (string, error) {
+	outputFile := filepath.Join(artifactsDir, PlatformFile(sbomFile, image.Platform))
+	cmd := exec.Command("trivy", "image", "--format", "cyclonedx", "--output", outputFile)
+	cmd.Args = append(cmd.Args, trivyImageArgs(image)...)
+	if _, err := ExecuteCommand(cmd); err != nil {
+		return "", errors.Wrap(err, "failed to generate SBOM")
+	}
+	return outputFile, nil
+}
+
+// PushSBOM pushes the SBOM as an OCI artifact whose subject is the image digest.
+func PushSBOM(client *registry.Client, imageRepo string, image registry.Descriptor, sbomPath string) (registry.Descriptor, error) {
+	data, err := os.ReadFile(sbomPath)
+	if err != nil {
+		return registry.Descriptor{}, errors.Wrapf(err, "failed to read SBOM %s", sbomPath)
+	}
+	return client.PushArtifact(imageRepo, registry.ReferrerTag(image.Digest, sbomTagSuffix), registry.Artifact{
+		ArtifactType: sbomMediaType,
+		Layers:       []registry.Layer{{MediaType: sbomMediaType, Data: data}},
+		Subject:      &image,
+	})
+}
+
+// PullSBOMs fetches the SBOMs of the released version from the registry by platform, checking
+// that each references the digest of its image. A single platform image has one SBOM under
+// an empty platform, a multi-platform image one per platform of its index.
+func PullSBOMs(client *registry.Client, imageRepo string, version string) (map[string][]byte, error) {
+	manifest, image, err := client.GetImageManifest(imageRepo, version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Image %s:%s not found", imageRepo, version)
+	}
+	subjects := map[string]registry.Descriptor{"": image}
+	if manifest.IsIndex() {
+		subjects = map[string]registry.Descriptor{}
+		for _, desc := range manifest.Manifests {
+			if desc.Platform != nil && desc.Platform.OS != "unknown" {
+				subjects[desc.Platform.String()] = desc
+			}
+		}
+	}
+	sboms := map[string][]byte{}
+	for platform, subject := range subjects {
+		log.Printf("Fetching SBOM of %s:%s %s(%s)\n", imageRepo, version, platformLabel(platform), subject.Digest)
+		data, err := pullSBOM(client, imageRepo, subject)
+		if err != nil {
+			return nil, errors.Wrapf(err, "SBOM of %s:%s %snot found", imageRepo, version, platformLabel(platform))
+		}
+		sboms[platform] = data
+	}
+	return sboms, nil
+}
+
+func pullSBOM(client *registry.Client, imageRepo string, subject registry.Descriptor) ([]byte, error) {
+	manifest, layers, err := client.PullArtifact(imageRepo, registry.ReferrerTag(subject.Digest, sbomTagSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject.Digest {
+		return nil, errors.Errorf("the SBOM does not reference the image digest %s", subject.Digest)
+	}
+	for i, layer := range manifest.Layers {
+		if layer.MediaType == sbomMediaType {
+			return layers[i], nil
+		}
+	}
+	return nil, errors.Errorf("the SBOM artifact has no %s layer", sbomMediaType)
+}
+
+// platformLabel returns the platform followed by a space, or nothing for a single platform.
+func platformLabel(platform string) string {
+	if platform == "" {
+		return ""
+	}
+	return platform + " "
+}
+
+// ScanSBOM scans the packages of the SBOM for vulnerabilities with Trivy and writes the JSON
+// report to reportFile.
+func ScanSBOM(sbomPath string, reportFile string) (trivy.Report, error) {
+	cmd := exec.Command("trivy", "sbom", "--format", "json", "--output", reportFile, "--exit-code", "0", sbomPath)
+	if _, err := ExecuteCommand(cmd); err != nil {
+		return trivy.Report{}, errors.Wrapf(err, "failed to scan SBOM %s", sbomPath)
+	}
+	return trivy.ReadReport(reportFile)
+}
+
+func runSbomGet(client *registry.Client, appDir string, namespace string, version string, platform string, outputFile string) error {
+	if err := CheckIfPathExists(appDir); err != nil {
+		return errors.Wrapf(err, "Directory %s does not exist", appDir)
+	}
+	jsonData, err := GetFieldsFromPackageJSON(appDir, []string{"name"})
+	if err != nil {
+		return err
+	}
+	imageRepo := fmt.Sprintf("%s/%s", namespace, jsonData["name"].(string))
+
+	sboms, err := PullSBOMs(client, imageRepo, version)
+	if err != nil {
+		return err
+	}
+	data, ok := sboms[platform]
+	if len(sboms) == 1 && platform == "" {
+		for _, sbom := range sboms {
+			data, ok = sbom, true
+		}
+	}
+	if !ok {
+		var platforms []string
+		for p := range sboms {
+			platforms = append(platforms, p)
+		}
+		sort.Strings(platforms)
+		return errors.Errorf("No SBOM for the platform %q of %s:%s, choose one of %s with --platform", platform, imageRepo, version, strings.Join(platforms, ", "))
+	}
+	if outputFile == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(outputFile, data, 0644); err != nil {
+		return errors.Wrapf(err, "Error writing file %s", outputFile)
+	}
+	log.Printf("SBOM written to %s\n", outputFile)
+	return nil
+}
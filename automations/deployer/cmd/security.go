@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/trivy"
+
+	"github.com/pkg/errors"
+)
+
+// vulnerabilitiesFile is the release artifact holding the parsed findings of the image
+const vulnerabilitiesFile = "vulnerabilities.json"
+
+// RunSecurityGate stores the findings of the release and evaluates them against the policy.
+// In baseline mode only the findings that are new relative to the release deployed to the
+// baseline environment count towards the limits; inherited and fixed findings are reported.
+func RunSecurityGate(
+	client *registry.Client,
+	imageRepo string,
+	opsDir string,
+	appName string,
+	version string,
+	deployConfig DeployConfig,
+	policy trivy.Policy,
+	report trivy.Report,
+) error {
+	vulnerabilities := report.Vulnerabilities()
+	artifactsDir, err := ReleaseArtifactsDir(opsDir, appName, version)
+	if err != nil {
+		return err
+	}
+	if err := trivy.WriteVulnerabilities(filepath.Join(artifactsDir, vulnerabilitiesFile), vulnerabilities); err != nil {
+		return err
+	}
+
+	counted := vulnerabilities
+	if policy.Baseline != "" {
+		comparison, baselineVersion, err := compareWithBaseline(client, imageRepo, opsDir, appName, deployConfig, policy.Baseline, vulnerabilities)
+		if err != nil {
+			return err
+		}
+		if baselineVersion == "" {
+			// Without a baseline every finding is new
+			comparison.New = vulnerabilities
+		}
+		log.Printf(
+			"Compared with %s release %s: %d new, %d inherited, %d fixed findings\n",
+			policy.Baseline,
+			baselineVersion,
+			len(comparison.New),
+			len(comparison.Inherited),
+			len(comparison.Fixed),
+		)
+		if len(comparison.Inherited) > 0 {
+			fmt.Printf("\nInherited findings (present in %s %s):\n", policy.Baseline, baselineVersion)
+			trivy.PrintFindings(os.Stdout, comparison.Inherited)
+		}
+		if len(comparison.Fixed) > 0 {
+			fmt.Printf("\nFixed findings (present in %s %s only):\n", policy.Baseline, baselineVersion)
+			trivy.PrintFindings(os.Stdout, comparison.Fixed)
+		}
+		fmt.Printf("\nNew findings:\n")
+		counted = comparison.New
+	}
+
+	evaluation := policy.Evaluate(counted, time.Now())
+	evaluation.PrintSummary(os.Stdout)
+	for _, warning := range evaluation.Warnings {
+		log.Printf("Warning: %s\n", warning)
+	}
+	if !evaluation.Passed() {
+		return errors.Errorf("Security policy failed: %s", strings.Join(evaluation.Violations, "; "))
+	}
+	return nil
+}
+
+// compareWithBaseline compares the findings with the findings of the release currently
+// deployed to the baseline environment. The returned version is empty when no release is
+// deployed there yet.
+func compareWithBaseline(
+	client *registry.Client,
+	imageRepo string,
+	opsDir string,
+	appName string,
+	deployConfig DeployConfig,
+	environment string,
+	vulnerabilities []trivy.Vulnerability,
+) (trivy.Comparison, string, error) {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return trivy.Comparison{}, "", errors.Wrap(err, "invalid security baseline")
+	}
+	baselineVersion, ok := deployConfig.DeployedVersions[environment]
+	if !ok || baselineVersion == "" {
+		log.Printf("Warning: no version deployed to %s, every finding is considered new\n", environment)
+		return trivy.Comparison{}, "", nil
+	}
+	baseline, err := BaselineVulnerabilities(client, imageRepo, opsDir, appName, baselineVersion)
+	if err != nil {
+		return trivy.Comparison{}, "", errors.Wrapf(err, "No findings of the %s baseline release %s", environment, baselineVersion)
+	}
+	return trivy.Compare(vulnerabilities, baseline), baselineVersion, nil
+}
+
+// BaselineVulnerabilities returns the findings of the released version, from its release
+// artifacts when they are checked out, or else by scanning its SBOM stored in the registry
+// with the current vulnerability database.
+func BaselineVulnerabilities(
+	client *registry.Client,
+	imageRepo string,
+	opsDir string,
+	appName string,
+	version string,
+) ([]trivy.Vulnerability, error) {
+	baselineFile := filepath.Join(opsDir, appName, "releases", version, vulnerabilitiesFile)
+	if err := CheckIfPathExists(baselineFile); err == nil {
+		return trivy.ReadVulnerabilities(baselineFile)
+	}
+	log.Printf("Findings of the baseline release %s are not in %s, scanning its SBOM from the registry\n", version, baselineFile)
+	sboms, err := PullSBOMs(client, imageRepo, version)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "deployer-baseline-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	// The findings of every platform are merged, like the report of a multi-platform release
+	var vulnerabilities []trivy.Vulnerability
+	seen := map[string]bool{}
+	for platform, sbom := range sboms {
+		sbomPath := filepath.Join(dir, PlatformFile(sbomFile, platform))
+		if err := os.WriteFile(sbomPath, sbom, 0644); err != nil {
+			return nil, errors.Wrapf(err, "Error writing file %s", sbomPath)
+		}
+		report, err := ScanSBOM(sbomPath, filepath.Join(dir, PlatformFile("trivy-report.json", platform)))
+		if err != nil {
+			return nil, err
+		}
+		for _, vulnerability := range report.Vulnerabilities() {
+			if !seen[vulnerability.Key()] {
+				seen[vulnerability.Key()] = true
+				vulnerabilities = append(vulnerabilities, vulnerability)
+			}
+		}
+	}
+	return vulnerabilities, nil
+}
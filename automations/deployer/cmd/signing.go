@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/signing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var keyOutputPrefix string
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysGenerateCmd)
+
+	keysGenerateCmd.Flags().StringVarP(&keyOutputPrefix, "output_prefix", "p", "deployer", "write the key pair to <prefix>.key and <prefix>.pub")
+}
+
+// SigningConfig is the signing section of deploy.yaml.
+type SigningConfig struct {
+	// TrustedKeys lists, per environment, the public keys (relative to the application
+	// operations directory) accepted when verifying image signatures before a deploy
+	TrustedKeys map[string][]string `yaml:"trustedKeys"`
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the keys used to sign released images",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate an ECDSA P-256 key pair for image signing",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runKeysGenerate(keyOutputPrefix); err != nil {
+			log.Fatalf("Error generating keys: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func runKeysGenerate(prefix string) error {
+	privateKey, publicKey, err := signing.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	privateFile := prefix + ".key"
+	publicFile := prefix + ".pub"
+	if err := CheckIfPathExists(privateFile); err == nil {
+		return errors.Errorf("File %s already exists", privateFile)
+	}
+	// NOTE: Only the owner can read the private key
+	if err := os.WriteFile(privateFile, privateKey, 0600); err != nil {
+		return errors.Wrapf(err, "Error writing file %s", privateFile)
+	}
+	if err := os.WriteFile(publicFile, publicKey, 0644); err != nil {
+		return errors.Wrapf(err, "Error writing file %s", publicFile)
+	}
+	log.Printf("Generated private key %s and public key %s\n", privateFile, publicFile)
+	return nil
+}
+
+// SignReleasedImage signs the pushed image digest with the private key.
+func SignReleasedImage(client *registry.Client, imageRepo string, image registry.Descriptor, keyFile string) error {
+	key, err := signing.LoadPrivateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	dockerReference := fmt.Sprintf("%s/%s", client.Registry, imageRepo)
+	sigDesc, err := signing.SignImage(client, imageRepo, dockerReference, image, key)
+	if err != nil {
+		return errors.Wrapf(err, "Error signing %s@%s", imageRepo, image.Digest)
+	}
+	log.Printf("Signed %s@%s, signature stored in %s\n", imageRepo, image.Digest, sigDesc.Digest)
+	return nil
+}
+
+// TrustedKeys loads the public keys trusted for the environment, resolved relative to the
+// application operations directory. It returns no keys when verification is not configured.
+func (c DeployConfig) TrustedKeys(appOpsDir string, environment string) ([]*ecdsa.PublicKey, error) {
+	if c.Signing == nil {
+		return nil, nil
+	}
+	var keys []*ecdsa.PublicKey
+	for _, keyFile := range c.Signing.TrustedKeys[environment] {
+		if !filepath.IsAbs(keyFile) {
+			keyFile = filepath.Join(appOpsDir, keyFile)
+		}
+		key, err := signing.LoadPublicKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// VerifyImageSignature resolves the tag to its digest and checks that it was signed
+// by one of the trusted keys. It returns the verified digest.
+func VerifyImageSignature(client *registry.Client, imageRepo string, tag string, keys []*ecdsa.PublicKey) (string, error) {
+	image, err := client.HeadManifest(imageRepo, tag)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error resolving the digest of %s:%s", imageRepo, tag)
+	}
+	if err := signing.VerifyImage(client, imageRepo, image.Digest, keys); err != nil {
+		return "", err
+	}
+	log.Printf("Verified signature of %s:%s (%s)\n", imageRepo, tag, image.Digest)
+	return image.Digest, nil
+}
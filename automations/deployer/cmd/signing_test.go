@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+)
+
+func TestSignReleasedImageAndVerify(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "release")
+	if err := runKeysGenerate(prefix); err != nil {
+		t.Fatal(err)
+	}
+	config := DeployConfig{Signing: &SigningConfig{TrustedKeys: map[string][]string{"production": {"release.pub"}}}}
+	keys, err := config.TrustedKeys(dir, "production")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("TrustedKeys: %v, %d keys", err, len(keys))
+	}
+	if keys, _ := config.TrustedKeys(dir, "staging"); len(keys) != 0 {
+		t.Errorf("got %d keys for staging, want none", len(keys))
+	}
+
+	image, err := client.PushArtifact("team/app", "1.0.0", registry.Artifact{
+		Layers: []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Data: []byte("release")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyImageSignature(client, "team/app", "1.0.0", keys); err == nil {
+		t.Fatal("expected an unsigned image to fail verification")
+	}
+	if err := SignReleasedImage(client, "team/app", image, prefix+".key"); err != nil {
+		t.Fatalf("SignReleasedImage: %v", err)
+	}
+	digest, err := VerifyImageSignature(client, "team/app", "1.0.0", keys)
+	if err != nil {
+		t.Fatalf("VerifyImageSignature: %v", err)
+	}
+	if digest != image.Digest {
+		t.Errorf("verified digest %s, want %s", digest, image.Digest)
+	}
+
+	// A key pair generated later is not trusted
+	if err := runKeysGenerate(filepath.Join(dir, "other")); err != nil {
+		t.Fatal(err)
+	}
+	untrusted := DeployConfig{Signing: &SigningConfig{TrustedKeys: map[string][]string{"production": {"other.pub"}}}}
+	otherKeys, err := untrusted.TrustedKeys(dir, "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyImageSignature(client, "team/app", "1.0.0", otherKeys); err == nil {
+		t.Error("expected the signature to be rejected with an untrusted key")
+	}
+}
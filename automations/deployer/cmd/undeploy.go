@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	deleteData bool
+	confirmed  bool
+)
+
+func init() {
+	rootCmd.AddCommand(undeployCmd)
+
+	undeployCmd.Flags().StringVarP(&appDir, "application_directory", "d", "", "the path of the application directory")
+	undeployCmd.MarkFlagRequired("application_directory")
+
+	undeployCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment to undeploy from")
+	undeployCmd.MarkFlagRequired("target_environment")
+
+	// Optional
+	undeployCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory, to clear the deployed version of deploy.yaml")
+	undeployCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "the namespace the application is deployed to (defaults to its name)")
+	undeployCmd.Flags().BoolVar(&deleteData, "delete-data", false, "also delete the persistent volume claims of the release")
+	undeployCmd.Flags().BoolVar(&confirmed, "yes", false, "do not ask for a confirmation in protected environments")
+}
+
+var undeployCmd = &cobra.Command{
+	Use:   "undeploy",
+	Short: "Uninstall the application from an environment",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runUndeploy(appDir, opsDir, targetEnvironment, namespace, deleteData, confirmed); err != nil {
+			log.Fatalf("Error running undeploy: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// helmReleasesLeft returns the names of the helm releases of the namespace.
+func helmReleasesLeft(namespace string) ([]string, error) {
+	cmd := HelmCommand("list", "--namespace", namespace, "--all", "--short")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error listing the helm releases of namespace %s: %s", namespace, strings.TrimSpace(stderr.String()))
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// UninstallHelmRelease uninstalls the release, deletes its persistent volume claims with
+// deleteData, and deletes the namespace when the deployer created it for the owner and no
+// release is left in it. Without deleteData, a namespace still holding persistent volume
+// claims is kept, deleting it would delete them.
+func UninstallHelmRelease(
+	client kubernetes.Interface,
+	releaseName string,
+	namespace string,
+	owner string,
+	deleteData bool,
+	logger *log.Logger,
+) error {
+	release, err := GetHelmRelease(releaseName, namespace)
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		logger.Printf("Release %s is not installed in namespace %s\n", releaseName, namespace)
+	} else {
+		cmd := HelmCommand("uninstall", releaseName, "--namespace", namespace, "--wait")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "Error uninstalling release %s: %s", releaseName, strings.TrimSpace(string(out)))
+		}
+		logger.Printf("Uninstalled release %s from namespace %s\n", releaseName, namespace)
+	}
+
+	if deleteData {
+		pvcs, err := DeleteReleasePVCs(client, namespace, releaseName)
+		if err != nil {
+			return err
+		}
+		for _, pvc := range pvcs {
+			logger.Printf("Deleted persistent volume claim %s/%s\n", namespace, pvc)
+		}
+	}
+
+	releases, err := helmReleasesLeft(namespace)
+	if err != nil {
+		return err
+	}
+	if len(releases) > 0 {
+		logger.Printf("Keeping namespace %s, it still holds releases %s\n", namespace, strings.Join(releases, ", "))
+		return nil
+	}
+	if !deleteData {
+		pvcs, err := NamespacePVCs(client, namespace)
+		if err != nil {
+			return err
+		}
+		if len(pvcs) > 0 {
+			logger.Printf("Keeping namespace %s, it still holds persistent volume claims %s (use --delete-data to delete them)\n", namespace, strings.Join(pvcs, ", "))
+			return nil
+		}
+	}
+	deleted, err := DeleteOwnedNamespace(client, namespace, owner)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		logger.Printf("Deleted namespace %s\n", namespace)
+	} else {
+		logger.Printf("Keeping namespace %s, it was not created by the deployer for %s\n", namespace, owner)
+	}
+	return nil
+}
+
+func runUndeploy(
+	appDir string,
+	opsDir string,
+	environment string,
+	namespace string,
+	deleteData bool,
+	yes bool,
+) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	if err := CheckIfPathExists(appDir); err != nil {
+		return errors.Wrapf(err, "Directory %s does not exist", appDir)
+	}
+	jsonData, err := GetFieldsFromPackageJSON(appDir, []string{"name"})
+	if err != nil {
+		return err
+	}
+	appName := jsonData["name"].(string)
+	if namespace == "" {
+		namespace = appName
+	}
+
+	action := "Undeploying " + appName
+	if deleteData {
+		action += " and deleting its data"
+	}
+	if err := ConfirmProtectedEnvironment(environment, action, yes, os.Stdin); err != nil {
+		return err
+	}
+	client, err := NewKubeClient()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Undeploying application %s from namespace %s\n", appName, namespace)
+	if err := UninstallHelmRelease(client, appName, namespace, appName, deleteData, log.Default()); err != nil {
+		return err
+	}
+
+	if opsDir != "" {
+		deployFile := filepath.Join(opsDir, appName, "deploy.yaml")
+		deployData, err := GetMapFromYamlFile(deployFile)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading YAML file %s", deployFile)
+		}
+		deployedVersions := GetStringMap(deployData["deployedVersions"])
+		if _, ok := deployedVersions[environment]; ok {
+			delete(deployedVersions, environment)
+			deployData["deployedVersions"] = deployedVersions
+			if err := WriteMapToYamlFile(deployFile, deployData); err != nil {
+				return errors.Wrapf(err, "Error writing YAML file %s", deployFile)
+			}
+			log.Printf("Removed deployedVersions.%s from %s\n", environment, deployFile)
+		}
+	}
+	log.Printf("Application %s undeployed\n", appName)
+	return nil
+}
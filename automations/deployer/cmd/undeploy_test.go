@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer/pkg/manifests"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeHelm puts on the PATH a helm that reports no installed release.
+func fakeHelm(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$*\" in *json*) echo '[]' ;; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "helm"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestUninstallHelmReleaseKeepsNamespaceWithData(t *testing.T) {
+	fakeHelm(t)
+	ctx := context.Background()
+	newClient := func() *fake.Clientset {
+		return fake.NewSimpleClientset(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "mysql", Labels: map[string]string{
+				manifests.OwnerLabel:     "mysql",
+				manifests.ManagedByLabel: manifests.ManagedBy,
+			}}},
+			&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Name:      "data-mysql-0",
+				Namespace: "mysql",
+				Labels:    map[string]string{helmInstanceLabel: "mysql"},
+			}},
+		)
+	}
+	namespaceExists := func(client *fake.Clientset) bool {
+		_, err := client.CoreV1().Namespaces().Get(ctx, "mysql", metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	var out bytes.Buffer
+	client := newClient()
+	if err := UninstallHelmRelease(client, "mysql", "mysql", "mysql", false, log.New(&out, "", 0)); err != nil {
+		t.Fatalf("UninstallHelmRelease: %v", err)
+	}
+	if !namespaceExists(client) || !strings.Contains(out.String(), "Keeping namespace mysql, it still holds persistent volume claims data-mysql-0") {
+		t.Errorf("the namespace was not kept with its data:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := UninstallHelmRelease(client, "mysql", "mysql", "mysql", true, log.New(&out, "", 0)); err != nil {
+		t.Fatalf("UninstallHelmRelease: %v", err)
+	}
+	if namespaceExists(client) {
+		t.Errorf("the namespace was kept with --delete-data:\n%s", out.String())
+	}
+
+	// Once its data is gone, the namespace is deleted without --delete-data
+	client = newClient()
+	if err := client.CoreV1().PersistentVolumeClaims("mysql").Delete(ctx, "data-mysql-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UninstallHelmRelease(client, "mysql", "mysql", "mysql", false, log.New(&out, "", 0)); err != nil {
+		t.Fatalf("UninstallHelmRelease: %v", err)
+	}
+	if namespaceExists(client) {
+		t.Error("the namespace without persistent volume claims was kept")
+	}
+}
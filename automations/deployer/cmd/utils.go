@@ -15,6 +15,9 @@ import (
 	"strings"
 	"sync"
 
+	"deployer/pkg/credentials"
+	"deployer/pkg/trivy"
+
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
@@ -95,42 +98,35 @@ func CheckTargetEnvironment(env string) error {
 	return nil
 }
 
-func ParseInfraConfigFromYaml(data []byte) (InfraConfig, error) {
-	var infraConfig InfraConfig
+// IsProtectedEnvironment tells whether destructive commands need a confirmation in the environment.
+func IsProtectedEnvironment(env string) bool {
+	return env == "production"
+}
 
-	if err := yaml.Unmarshal(data, &infraConfig); err != nil {
-		return infraConfig, errors.Wrap(err, "failed to unmarshal yaml file")
+// ConfirmProtectedEnvironment asks to type the name of a protected environment before the
+// action, unless yes is set. Other environments need no confirmation.
+func ConfirmProtectedEnvironment(env string, action string, yes bool, in io.Reader) error {
+	if !IsProtectedEnvironment(env) || yes {
+		return nil
 	}
-
-	var wrongTypeFields []string
-	for _, vendor := range infraConfig.Vendors.Charts {
-		var missingFields []string
-		if vendor.Name == "" {
-			missingFields = append(missingFields, "name")
-		}
-		if vendor.Chart == "" {
-			missingFields = append(missingFields, "chart")
-		}
-		if vendor.Namespace == "" {
-			missingFields = append(missingFields, "namespace")
-		}
-		if vendor.ReleaseName == "" {
-			missingFields = append(missingFields, "releaseName")
-		}
-		wrongTypeFields = append(wrongTypeFields, fmt.Sprintf("%s: %s", vendor, strings.Join(missingFields, ", ")))
-	}
-
-	for _, vendor := range infraConfig.Vendors.Scripts {
-		if vendor == "" {
-			wrongTypeFields = append(wrongTypeFields, fmt.Sprintf("script: %s", "empty"))
-		}
+	fmt.Printf("%s in the protected environment %s. Type %s to confirm: ", action, env, env)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "Error reading the confirmation")
 	}
-
-	if len(wrongTypeFields) > 0 {
-		return infraConfig, errors.Wrapf(nil, "wrong type fields: %s", strings.Join(wrongTypeFields, ", "))
+	if strings.TrimSpace(answer) != env {
+		return errors.Errorf("Not confirmed, nothing done in %s", env)
 	}
+	return nil
+}
 
-	return infraConfig, nil
+// ParseInfraConfigFromYaml converts the infra.yaml data to the current format version,
+// validates it against its schema and decodes it. Errors are reported with the
+// file:line:column of the problem.
+func ParseInfraConfigFromYaml(file string, data []byte) (InfraConfig, error) {
+	var infraConfig InfraConfig
+	err := infraConfigFormat.Load(file, data, &infraConfig)
+	return infraConfig, err
 }
 
 func GetMapFromYamlFile(yamlFile string) (map[string]interface{}, error) {
@@ -145,6 +141,55 @@ func GetMapFromYamlFile(yamlFile string) (map[string]interface{}, error) {
 	return yamlFileData, nil
 }
 
+// WriteMapToYamlFile marshals the map and writes it to the yaml file.
+func WriteMapToYamlFile(yamlFile string, data map[string]interface{}) error {
+	newYamlData, err := yaml.Marshal(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal yaml file %s", yamlFile)
+	}
+	if err := os.WriteFile(yamlFile, newYamlData, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write yaml file %s", yamlFile)
+	}
+	return nil
+}
+
+// GetStringMap converts a nested yaml map into a map of strings, ignoring non-string values.
+func GetStringMap(value interface{}) map[string]string {
+	result := map[string]string{}
+	switch m := value.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				result[fmt.Sprint(k)] = s
+			}
+		}
+	case map[string]interface{}:
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				result[k] = s
+			}
+		}
+	}
+	return result
+}
+
+// GetReferencedVersions returns the versions referenced by deploy.yaml: the latest
+// release, the version deployed to each environment and the version of each channel.
+func GetReferencedVersions(deployFile string) (map[string]string, error) {
+	yamlData, err := GetMapFromYamlFile(deployFile)
+	if err != nil {
+		return nil, err
+	}
+	versions := GetStringMap(yamlData["deployedVersions"])
+	for channel, version := range GetStringMap(yamlData["channels"]) {
+		versions["channels."+channel] = version
+	}
+	if latest, ok := yamlData["latestReleaseVersion"].(string); ok {
+		versions["latestReleaseVersion"] = latest
+	}
+	return versions, nil
+}
+
 // GetFieldsFromPackageJSON extracts specified fields from package.json.
 func GetFieldsFromYamlFile(yamlFile string, fields []string) (map[string]interface{}, error) {
 	data, err := GetMapFromYamlFile(yamlFile)
@@ -232,35 +277,80 @@ func CheckDockerHubTagExistence(repo string, version string, username string, to
 	return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 }
 
-// BuildDockerImage builds a Docker image for the given application directory.
-func BuildDockerImage(appDir string, imageName string) error {
-	dockerfilePath := filepath.Join(appDir, "Dockerfile")
-	cmd := exec.Command("docker", "build", "-t", imageName, "-f", dockerfilePath, appDir)
-	_, err := ExecuteCommand(cmd)
+// ResolveDockerCredentials resolves the Docker Hub credentials from the docker config,
+// credential helpers, DOCKER_USERNAME/DOCKER_PASSWORD or a terminal prompt.
+func ResolveDockerCredentials(username string, passwordStdin bool) (credentials.Credentials, error) {
+	creds, err := credentials.Resolve(credentials.Options{
+		Server:        credentials.DockerHubServer,
+		Username:      username,
+		PasswordStdin: passwordStdin,
+		UsernameEnv:   "DOCKER_USERNAME",
+		PasswordEnv:   "DOCKER_PASSWORD",
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to build Docker image")
+		return creds, err
 	}
-	return nil
+	log.Printf("Using docker credentials for %s from %s\n", creds.Username, creds.Source)
+	return creds, nil
 }
 
-// RunTrivy scans the Docker image for security vulnerabilities using Trivy.
-func RunTrivy(imageName string) error {
-	cmd := exec.Command("trivy", "image", "--severity", "HIGH,CRITICAL", "--exit-code", "1", imageName)
-	_, err := ExecuteCommand(cmd)
-	if err != nil {
-		return errors.Wrap(err, "Trivy found security vulnerabilities")
+// trivyImageArgs tells Trivy where to find the built image: an OCI archive, or a local
+// image of docker or podman.
+func trivyImageArgs(image BuiltImage) []string {
+	if image.Archive {
+		return []string{"--input", image.Name}
 	}
-	return nil
+	if image.Engine == "podman" {
+		return []string{"--image-src", "podman", image.Name}
+	}
+	return []string{image.Name}
+}
+
+// RunTrivy scans the built image for security vulnerabilities using Trivy and
+// writes the JSON report to reportFile. Findings never fail the scan itself,
+// they are evaluated against the application security policy.
+func RunTrivy(image BuiltImage, reportFile string) (trivy.Report, error) {
+	cmd := exec.Command("trivy", "image", "--format", "json", "--output", reportFile, "--exit-code", "0")
+	cmd.Args = append(cmd.Args, trivyImageArgs(image)...)
+	if _, err := ExecuteCommand(cmd); err != nil {
+		return trivy.Report{}, errors.Wrap(err, "failed to run Trivy")
+	}
+	return trivy.ReadReport(reportFile)
 }
 
-// PushDockerImage pushes the Docker image to the specified repository.
-func PushDockerImage(imageName string) error {
-	cmd := exec.Command("docker", "push", imageName)
-	_, err := ExecuteCommand(cmd)
+// ScanBuiltImages scans every built image with Trivy. A multi-platform build keeps the report
+// of each platform and writes their merged findings to reportFile, a finding of several
+// platforms is only reported for the first one.
+func ScanBuiltImages(images []BuiltImage, reportFile string) (trivy.Report, error) {
+	if len(images) == 1 && images[0].Platform == "" {
+		return RunTrivy(images[0], reportFile)
+	}
+	var merged trivy.Report
+	seen := map[string]bool{}
+	for _, image := range images {
+		report, err := RunTrivy(image, PlatformFile(reportFile, image.Platform))
+		if err != nil {
+			return trivy.Report{}, err
+		}
+		merged.SchemaVersion, merged.ArtifactType = report.SchemaVersion, report.ArtifactType
+		for _, result := range report.Results {
+			var vulnerabilities []trivy.Vulnerability
+			for _, vulnerability := range result.Vulnerabilities {
+				if !seen[vulnerability.Key()] {
+					seen[vulnerability.Key()] = true
+					vulnerabilities = append(vulnerabilities, vulnerability)
+				}
+			}
+			result.Target = fmt.Sprintf("%s (%s)", result.Target, image.Platform)
+			result.Vulnerabilities = vulnerabilities
+			merged.Results = append(merged.Results, result)
+		}
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
 	if err != nil {
-		return errors.Wrap(err, "failed to push Docker image")
+		return trivy.Report{}, errors.Wrap(err, "failed to marshal the merged Trivy report")
 	}
-	return nil
+	return merged, errors.Wrapf(os.WriteFile(reportFile, data, 0644), "Error writing file %s", reportFile)
 }
 
 // BumpPackageJSONVersion increments the patch version in package.json.
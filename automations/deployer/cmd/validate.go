@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"deployer/pkg/schema"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// ChartMetadata is the typed view of the Chart.yaml of a local chart.
+type ChartMetadata struct {
+	APIVersion   string            `yaml:"apiVersion" schema:"required"`
+	Name         string            `yaml:"name" schema:"required"`
+	Version      string            `yaml:"version" schema:"required"`
+	KubeVersion  string            `yaml:"kubeVersion"`
+	Description  string            `yaml:"description"`
+	Type         string            `yaml:"type"`
+	Keywords     []string          `yaml:"keywords"`
+	Home         string            `yaml:"home"`
+	Sources      []string          `yaml:"sources"`
+	Dependencies []ChartDependency `yaml:"dependencies"`
+	Maintainers  []ChartMaintainer `yaml:"maintainers"`
+	Icon         string            `yaml:"icon"`
+	AppVersion   string            `yaml:"appVersion"`
+	Deprecated   bool              `yaml:"deprecated"`
+	Annotations  map[string]string `yaml:"annotations"`
+}
+
+// ChartDependency is a dependency of a chart.
+type ChartDependency struct {
+	Name         string        `yaml:"name" schema:"required"`
+	Version      string        `yaml:"version"`
+	Repository   string        `yaml:"repository"`
+	Condition    string        `yaml:"condition"`
+	Tags         []string      `yaml:"tags"`
+	Enabled      bool          `yaml:"enabled"`
+	ImportValues []interface{} `yaml:"import-values"`
+	Alias        string        `yaml:"alias"`
+}
+
+// ChartMaintainer is a maintainer of a chart.
+type ChartMaintainer struct {
+	Name  string `yaml:"name" schema:"required"`
+	Email string `yaml:"email"`
+	URL   string `yaml:"url"`
+}
+
+// Validate checks the API version, the type and that the version is semver.
+func (m ChartMetadata) Validate() error {
+	if m.APIVersion != "v1" && m.APIVersion != "v2" {
+		return errors.Errorf("apiVersion must be v1 or v2, not %q", m.APIVersion)
+	}
+	if m.Type != "" && m.Type != "application" && m.Type != "library" {
+		return errors.Errorf("type must be application or library, not %q", m.Type)
+	}
+	if _, err := semver.StrictNewVersion(m.Version); err != nil {
+		return errors.Errorf("version %q is not a semver version", m.Version)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&opsDir, "operations_directory", "o", "", "the path of the operations directory")
+	validateCmd.MarkFlagRequired("operations_directory")
+
+	validateCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	validateCmd.MarkFlagRequired("infrastructure_directory")
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate infra.yaml, the deploy.yaml and values files of the applications and the local charts",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runValidate(opsDir, infrastructureDir); err != nil {
+			log.Fatalf("Error validating the configuration: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// validation collects the problems of the validated files.
+type validation struct {
+	files    int
+	problems []error
+}
+
+func (v *validation) add(file string, err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(schema.Errors); ok {
+		for _, e := range errs {
+			v.problems = append(v.problems, e)
+		}
+		return
+	}
+	v.problems = append(v.problems, errors.Errorf("%s: %v", file, err))
+}
+
+// file validates the YAML file against the type of target and decodes it into target when it
+// is valid. It returns whether the file is valid.
+func (v *validation) file(file string, target interface{}) bool {
+	v.files++
+	data, err := os.ReadFile(file)
+	if err != nil {
+		v.add(file, err)
+		return false
+	}
+	if errs := schema.Validate(file, data, target); len(errs) > 0 {
+		v.add(file, errs)
+		return false
+	}
+	if err := yaml.Unmarshal(data, target); err != nil {
+		v.add(file, err)
+		return false
+	}
+	return true
+}
+
+// format validates the versioned file against the type of target once upgraded to the current
+// version of its format, and decodes it into target when it is valid.
+func (v *validation) format(file string, format schema.Format, target interface{}) bool {
+	v.files++
+	data, err := os.ReadFile(file)
+	if err != nil {
+		v.add(file, err)
+		return false
+	}
+	if err := format.Load(file, data, target); err != nil {
+		v.add(file, err)
+		return false
+	}
+	return true
+}
+
+// valuesFiles validates the syntax and the environment of the values.<env>.yaml files of the directory.
+func (v *validation) valuesFiles(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "values.*.yaml"))
+	if err != nil {
+		v.add(dir, err)
+		return
+	}
+	for _, file := range files {
+		environment := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "values."), ".yaml")
+		if err := CheckTargetEnvironment(environment); err != nil {
+			v.add(file, err)
+		}
+		var values map[string]interface{}
+		v.file(file, &values)
+	}
+}
+
+func (v *validation) infrastructure(infrastructureDir string) {
+	infraFile := filepath.Join(infrastructureDir, "infra.yaml")
+	var infraConfig InfraConfig
+	if !v.format(infraFile, infraConfigFormat, &infraConfig) {
+		return
+	}
+	if _, err := BuildVendorGraph(infraConfig.Vendors); err != nil {
+		v.add(infraFile, err)
+	}
+	vendorsDir := filepath.Join(infrastructureDir, "vendors")
+	for _, script := range infraConfig.Vendors.Scripts {
+		vendorDir := filepath.Join(vendorsDir, strings.ToLower(script.Name))
+		if err := CheckIfPathExists(vendorDir); err != nil {
+			v.add(infraFile, errors.Errorf("directory %s of vendor %s does not exist", vendorDir, script.Name))
+		}
+	}
+	for _, vendor := range infraConfig.Vendors.Charts {
+		vendorDir := filepath.Join(vendorsDir, strings.ToLower(vendor.Name))
+		if err := CheckIfPathExists(vendorDir); err != nil {
+			v.add(infraFile, errors.Errorf("directory %s of vendor %s does not exist", vendorDir, vendor.Name))
+			continue
+		}
+		v.valuesFiles(vendorDir)
+		if IsLocalChart(vendor.Chart) && CheckIfPathExists(vendor.Chart) != nil {
+			v.add(infraFile, errors.Errorf("chart %s of vendor %s does not exist", vendor.Chart, vendor.Name))
+		}
+		if vendor.Version != "" && !strings.HasPrefix(vendor.Chart, "oci://") {
+			if _, err := semver.NewConstraint(vendor.Version); err != nil {
+				v.add(infraFile, errors.Errorf("version %q of vendor %s is not a semver constraint", vendor.Version, vendor.Name))
+			}
+		}
+	}
+	lockFile := filepath.Join(infrastructureDir, vendorLockFile)
+	var lock VendorLock
+	if CheckIfPathExists(lockFile) == nil && !v.file(lockFile, &lock) {
+		return
+	}
+	// NOTE: vendors deploy refuses the repository charts that are not locked
+	for _, vendor := range infraConfig.Vendors.Charts {
+		if IsLocalChart(vendor.Chart) {
+			continue
+		}
+		locked := lock.Get(vendor.Name)
+		if locked == nil {
+			v.add(lockFile, errors.Errorf("vendor %s is not locked, run deployer vendors lock", vendor.Name))
+		} else if !locked.Matches(vendor) {
+			v.add(lockFile, errors.Errorf("the chart or version of vendor %s changed since it was locked, run deployer vendors lock", vendor.Name))
+		}
+	}
+}
+
+func (v *validation) charts(chartsDir string) {
+	charts, err := os.ReadDir(chartsDir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		v.add(chartsDir, err)
+		return
+	}
+	_, helmErr := exec.LookPath("helm")
+	if helmErr != nil {
+		log.Printf("Warning: helm is not installed, the charts are not linted\n")
+	}
+	for _, entry := range charts {
+		if !entry.IsDir() {
+			continue
+		}
+		chartDir := filepath.Join(chartsDir, entry.Name())
+		var metadata ChartMetadata
+		if !v.file(filepath.Join(chartDir, "Chart.yaml"), &metadata) {
+			continue
+		}
+		if metadata.Name != entry.Name() {
+			v.add(filepath.Join(chartDir, "Chart.yaml"), errors.Errorf("name %s does not match the chart directory %s", metadata.Name, entry.Name()))
+		}
+		if valuesFile := filepath.Join(chartDir, "values.yaml"); CheckIfPathExists(valuesFile) == nil {
+			var values map[string]interface{}
+			v.file(valuesFile, &values)
+		}
+		if helmErr != nil {
+			continue
+		}
+		if out, err := exec.Command("helm", "lint", chartDir).CombinedOutput(); err != nil {
+			v.add(chartDir, errors.Errorf("helm lint failed:\n%s", strings.TrimSpace(string(out))))
+		}
+	}
+}
+
+func (v *validation) operations(opsDir string, chartsDir string) {
+	apps, err := os.ReadDir(opsDir)
+	if err != nil {
+		v.add(opsDir, err)
+		return
+	}
+	for _, entry := range apps {
+		appOpsDir := filepath.Join(opsDir, entry.Name())
+		deployFile := filepath.Join(appOpsDir, "deploy.yaml")
+		if !entry.IsDir() || CheckIfPathExists(deployFile) != nil {
+			continue
+		}
+		var deployConfig DeployConfig
+		if v.format(deployFile, deployConfigFormat, &deployConfig) {
+			if err := CheckIfPathExists(filepath.Join(chartsDir, deployConfig.Chart)); err != nil {
+				v.add(deployFile, errors.Errorf("chart %s does not exist in %s", deployConfig.Chart, chartsDir))
+			}
+		}
+		v.valuesFiles(appOpsDir)
+	}
+}
+
+func runValidate(opsDir string, infrastructureDir string) error {
+	if err := CheckIfPathExists(infrastructureDir); err != nil {
+		return errors.Wrapf(err, "Infrastructure directory %s does not exist", infrastructureDir)
+	}
+	chartsDir := filepath.Join(infrastructureDir, "charts")
+	v := &validation{}
+	v.infrastructure(infrastructureDir)
+	v.charts(chartsDir)
+	v.operations(opsDir, chartsDir)
+
+	for _, problem := range v.problems {
+		fmt.Println(problem)
+	}
+	log.Printf("Validated %d files, %d problems\n", v.files, len(v.problems))
+	if len(v.problems) > 0 {
+		return errors.Errorf("%d problems found", len(v.problems))
+	}
+	return nil
+}
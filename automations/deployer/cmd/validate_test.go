@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsUnlockedVendors(t *testing.T) {
+	dir := t.TempDir()
+	localChart := filepath.Join(dir, "charts", "local")
+	writeFile(t, filepath.Join(localChart, "Chart.yaml"), "apiVersion: v2\nname: local\nversion: 1.0.0\n")
+	writeFile(t, filepath.Join(dir, "infra.yaml"), `apiVersion: deployer/v2
+repositories:
+  - name: bitnami
+    url: https://charts.bitnami.com/bitnami
+vendors:
+  charts:
+    - name: MySQL
+      chart: bitnami/mysql
+      version: ^9.4.0
+      namespace: mysql
+      releaseName: mysql
+    - name: Redis
+      chart: bitnami/redis
+      namespace: redis
+      releaseName: redis
+    - name: Local
+      chart: `+localChart+`
+      namespace: local
+      releaseName: local
+`)
+	for _, vendor := range []string{"mysql", "redis", "local"} {
+		writeFile(t, filepath.Join(dir, "vendors", vendor, "values.production.yaml"), "{}\n")
+	}
+	problems := func() string {
+		v := &validation{}
+		v.infrastructure(dir)
+		return fmt.Sprint(v.problems)
+	}
+
+	got := problems()
+	if !strings.Contains(got, "vendor MySQL is not locked") || !strings.Contains(got, "vendor Redis is not locked") || strings.Contains(got, "Local") {
+		t.Errorf("got %s, want the repository charts reported as not locked", got)
+	}
+
+	writeFile(t, filepath.Join(dir, vendorLockFile), `generated: 2022-09-01T00:00:00Z
+charts:
+  - name: MySQL
+    chart: bitnami/mysql
+    constraint: ^9.3.0
+    version: 9.3.4
+    digest: sha256:0000
+  - name: Redis
+    chart: bitnami/redis
+    version: 17.1.0
+    digest: sha256:0000
+`)
+	got = problems()
+	if !strings.Contains(got, "version of vendor MySQL changed") || strings.Contains(got, "Redis") {
+		t.Errorf("got %s, want only MySQL reported as locked with another constraint", got)
+	}
+}
@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// helmHookAnnotation marks the hooks, which helm get manifest leaves out
+const helmHookAnnotation = "helm.sh/hook"
+
+func init() {
+	vendorsDiffCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsDiffCmd.MarkFlagRequired("infrastructure_directory")
+	vendorsDiffCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment whose values are rendered")
+	vendorsDiffCmd.MarkFlagRequired("target_environment")
+	addVendorSelectorFlags(vendorsDiffCmd)
+
+	vendorsCmd.AddCommand(vendorsDiffCmd)
+}
+
+var vendorsDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the changes vendors deploy would make to the installed vendor charts",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsDiff(infrastructureDir, targetEnvironment, vendorSelector); err != nil {
+			log.Fatalf("Error running vendors diff: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// RenderVendorManifests renders the manifests vendors deploy would install for the vendor,
+// with the environment variables set in the values and the images rewritten to the mirror.
+func RenderVendorManifests(vendorDir string, vendor VendorChartConfig, environment string) ([]byte, error) {
+	values, err := RenderVendorValues(vendorDir, vendor, environment)
+	if err != nil {
+		return nil, err
+	}
+	valuesTmpFile := filepath.Join("/dev/shm", fmt.Sprintf("%s.yaml", uuid.New()))
+	// NOTE: Only the original file owner can read/write the file
+	if err := os.WriteFile(valuesTmpFile, values, 0600); err != nil {
+		return nil, errors.Wrapf(err, "Error writing file %s", valuesTmpFile)
+	}
+	defer os.Remove(valuesTmpFile)
+
+	cmd := HelmCommand("template", vendor.ReleaseName, vendor.Chart, "--values", valuesTmpFile, "--namespace", vendor.Namespace)
+	if vendor.Version != "" {
+		cmd.Args = append(cmd.Args, "--version", vendor.Version)
+	}
+	postRenderer, err := MirrorPostRenderer(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	if postRenderer != "" {
+		defer os.Remove(postRenderer)
+		cmd.Args = append(cmd.Args, "--post-renderer", postRenderer)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error rendering chart %s: %s", vendor.Chart, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// GetHelmManifest returns the manifests of the installed release, nil when it is not installed.
+func GetHelmManifest(releaseName string, namespace string) ([]byte, error) {
+	release, err := GetHelmRelease(releaseName, namespace)
+	if err != nil || release == nil {
+		return nil, err
+	}
+	cmd := HelmCommand("get", "manifest", releaseName, "--namespace", namespace)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting the manifest of helm release %s: %s", releaseName, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// secretRedactionKey keys the HMAC of redacted Secret values. It is random for every run, so
+// that the printed values can show which keys changed but cannot be matched against guesses
+// or against the output of another run.
+var secretRedactionKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// redactSecret replaces the values of a Secret by their HMAC, so that changes show without
+// printing them.
+func redactSecret(resource map[interface{}]interface{}) {
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := resource[field].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range data {
+			mac := hmac.New(sha256.New, secretRedactionKey)
+			mac.Write([]byte(fmt.Sprint(value)))
+			data[key] = fmt.Sprintf("<redacted %x>", mac.Sum(nil)[:8])
+		}
+	}
+}
+
+// ManifestResources indexes the resources of a manifests stream by kind, namespace and name,
+// each encoded in a stable form. Hooks and Secret values are left out.
+func ManifestResources(manifests []byte) (map[string]string, error) {
+	documents, err := decodeManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+	resources := map[string]string{}
+	for _, document := range documents {
+		resource, ok := document.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		metadata, _ := resource["metadata"].(map[interface{}]interface{})
+		annotations, _ := metadata["annotations"].(map[interface{}]interface{})
+		if _, hook := annotations[helmHookAnnotation]; hook {
+			continue
+		}
+		if resource["kind"] == "Secret" {
+			redactSecret(resource)
+		}
+		key := fmt.Sprintf("%v/%v", resource["kind"], metadata["name"])
+		if namespace, ok := metadata["namespace"]; ok {
+			key = fmt.Sprintf("%v/%v/%v", resource["kind"], namespace, metadata["name"])
+		}
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error encoding %s", key)
+		}
+		resources[key] = string(data)
+	}
+	return resources, nil
+}
+
+// DiffManifests returns a unified diff of every resource added, removed or changed between the
+// installed and the desired manifests, and the number of changed resources.
+func DiffManifests(installed []byte, desired []byte) (string, int, error) {
+	return DiffManifestsLabeled(installed, desired, "installed", "rendered")
+}
+
+// DiffManifestsLabeled is DiffManifests with the labels of both sides of the diff.
+func DiffManifestsLabeled(installed []byte, desired []byte, fromLabel string, toLabel string) (string, int, error) {
+	installedResources, err := ManifestResources(installed)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "Error reading the %s manifests", fromLabel)
+	}
+	desiredResources, err := ManifestResources(desired)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "Error reading the %s manifests", toLabel)
+	}
+	keys := map[string]bool{}
+	for key := range installedResources {
+		keys[key] = true
+	}
+	for key := range desiredResources {
+		keys[key] = true
+	}
+	var sorted []string
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var out strings.Builder
+	changed := 0
+	for _, key := range sorted {
+		before, after := installedResources[key], desiredResources[key]
+		if before == after {
+			continue
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(before),
+			B:        difflib.SplitLines(after),
+			FromFile: fromLabel + " " + key,
+			ToFile:   toLabel + " " + key,
+			Context:  3,
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		out.WriteString(diff)
+		changed++
+	}
+	return out.String(), changed, nil
+}
+
+func runVendorsDiff(infrastructureDir string, environment string, selector VendorSelector) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	infraConfig, graph, err := LoadVendorGraph(infrastructureDir, selector)
+	if err != nil {
+		return err
+	}
+	if err := EnsureHelmRepositories(infraConfig, false); err != nil {
+		return err
+	}
+	lock, err := LoadVendorLock(infrastructureDir)
+	if err != nil {
+		return err
+	}
+	vendorsDir := filepath.Join(infrastructureDir, "vendors")
+	for _, key := range graph.Order {
+		node := graph.Nodes[key]
+		if node.Chart == nil {
+			log.Printf("Skipping vendor %s, only charts can be diffed\n", node.Name)
+			continue
+		}
+		vendor := lock.Pin(*node.Chart)
+		pulled, _, cleanup, err := PullVendorChartTemp(infraConfig, vendor)
+		if err != nil {
+			return err
+		}
+		desired, err := RenderVendorManifests(filepath.Join(vendorsDir, strings.ToLower(vendor.Name)), pulled, environment)
+		cleanup()
+		if err != nil {
+			return err
+		}
+		installed, err := GetHelmManifest(vendor.ReleaseName, vendor.Namespace)
+		if err != nil {
+			return err
+		}
+		diff, changed, err := DiffManifests(installed, desired)
+		if err != nil {
+			return errors.Wrapf(err, "Error comparing the manifests of %s", vendor.Name)
+		}
+		switch {
+		case installed == nil:
+			fmt.Printf("%s (%s/%s): not installed, %d resources would be created\n", vendor.Name, vendor.Namespace, vendor.ReleaseName, changed)
+		case changed == 0:
+			fmt.Printf("%s (%s/%s): no changes\n", vendor.Name, vendor.Namespace, vendor.ReleaseName)
+		default:
+			fmt.Printf("%s (%s/%s): %d resources would change\n", vendor.Name, vendor.Namespace, vendor.ReleaseName, changed)
+		}
+		fmt.Print(diff)
+	}
+	return nil
+}
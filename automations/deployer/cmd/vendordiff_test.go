@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestManifestResourcesRedactsSecrets(t *testing.T) {
+	manifest := func(password string) []byte {
+		return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: mysql
+  namespace: db
+data:
+  password: %s
+  user: cm9vdA==
+`, password))
+	}
+	before, err := ManifestResources(manifest("c2VjcmV0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ManifestResources(manifest("b3RoZXI="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := before["Secret/db/mysql"]
+	for _, leak := range []string{"c2VjcmV0", "cm9vdA==", fmt.Sprintf("%x", sha256.Sum256([]byte("c2VjcmV0")))[:12]} {
+		if strings.Contains(secret, leak) {
+			t.Errorf("the redacted Secret contains %s:\n%s", leak, secret)
+		}
+	}
+
+	diff, changed, err := DiffManifests(manifest("c2VjcmV0"), manifest("b3RoZXI="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 1 || strings.Count(diff, "+  password") != 1 || strings.Contains(diff, "+  user") {
+		t.Errorf("got %d changed resources, want only the password to change:\n%s", changed, diff)
+	}
+	if before["Secret/db/mysql"] == after["Secret/db/mysql"] {
+		t.Error("a changed value must show as changed")
+	}
+}
@@ -0,0 +1,417 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of a vendor in the vendors deploy summary
+const (
+	vendorStatusDeployed = "deployed"
+	vendorStatusFailed   = "failed"
+	vendorStatusSkipped  = "skipped"
+)
+
+// VendorNode is a script or chart vendor of the dependency graph.
+type VendorNode struct {
+	Name      string
+	Script    *VendorScriptConfig
+	Manifests *VendorManifestsConfig
+	Chart     *VendorChartConfig
+	// DependsOn holds the keys of the vendors deployed before this one
+	DependsOn []string
+	// Assumed holds the dependencies left out by a selection, expected to be in place
+	Assumed []string
+}
+
+// Kind returns script, manifests or chart.
+func (n *VendorNode) Kind() string {
+	if n.Chart != nil {
+		return "chart"
+	}
+	if n.Manifests != nil {
+		return "manifests"
+	}
+	return "script"
+}
+
+// VendorGraph is the dependency graph of the vendors of infra.yaml, keyed by lower case name.
+type VendorGraph struct {
+	Nodes map[string]*VendorNode
+	// Order is a topological order, keeping the file order between independent vendors
+	Order []string
+}
+
+// VendorResult is the outcome of the deploy of a vendor.
+type VendorResult struct {
+	Node     *VendorNode
+	Status   string
+	Reason   string
+	Duration time.Duration
+	Err      error
+}
+
+// vendorKey returns the key of a vendor name, names are matched case-insensitively.
+func vendorKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// BuildVendorGraph builds the dependency graph of the scripts and charts, rejecting duplicate
+// names, unknown dependencies and cycles.
+func BuildVendorGraph(config VendorConfig) (VendorGraph, error) {
+	graph := VendorGraph{Nodes: map[string]*VendorNode{}}
+	var fileOrder []string
+	add := func(node *VendorNode, dependsOn []string) error {
+		key := vendorKey(node.Name)
+		if _, ok := graph.Nodes[key]; ok {
+			return errors.Errorf("Vendor %s is declared more than once", node.Name)
+		}
+		for _, dependency := range dependsOn {
+			node.DependsOn = append(node.DependsOn, vendorKey(dependency))
+		}
+		graph.Nodes[key] = node
+		fileOrder = append(fileOrder, key)
+		return nil
+	}
+	for i := range config.Scripts {
+		script := &config.Scripts[i]
+		if err := add(&VendorNode{Name: script.Name, Script: script}, script.DependsOn); err != nil {
+			return graph, err
+		}
+	}
+	for i := range config.Manifests {
+		manifests := &config.Manifests[i]
+		if err := add(&VendorNode{Name: manifests.Name, Manifests: manifests}, manifests.DependsOn); err != nil {
+			return graph, err
+		}
+	}
+	for i := range config.Charts {
+		chart := &config.Charts[i]
+		if err := add(&VendorNode{Name: chart.Name, Chart: chart}, chart.DependsOn); err != nil {
+			return graph, err
+		}
+	}
+	for _, key := range fileOrder {
+		node := graph.Nodes[key]
+		for _, dependency := range node.DependsOn {
+			if _, ok := graph.Nodes[dependency]; !ok {
+				return graph, errors.Errorf("Vendor %s depends on unknown vendor %s", node.Name, dependency)
+			}
+		}
+	}
+
+	// Depth first topological sort, the path being visited reports the cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(key string) error
+	visit = func(key string) error {
+		switch state[key] {
+		case visited:
+			return nil
+		case visiting:
+			var cycle []string
+			for i, item := range path {
+				if item == key {
+					cycle = append(cycle, path[i:]...)
+					break
+				}
+			}
+			var names []string
+			for _, item := range append(cycle, key) {
+				names = append(names, graph.Nodes[item].Name)
+			}
+			return errors.Errorf("Vendor dependency cycle: %s", strings.Join(names, " -> "))
+		}
+		state[key] = visiting
+		path = append(path, key)
+		for _, dependency := range graph.Nodes[key].DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[key] = visited
+		graph.Order = append(graph.Order, key)
+		return nil
+	}
+	for _, key := range fileOrder {
+		if err := visit(key); err != nil {
+			return graph, err
+		}
+	}
+	return graph, nil
+}
+
+// Dependents returns the keys of the vendors depending directly on each vendor.
+func (g VendorGraph) Dependents() map[string][]string {
+	dependents := map[string][]string{}
+	for _, key := range g.Order {
+		for _, dependency := range g.Nodes[key].DependsOn {
+			dependents[dependency] = append(dependents[dependency], key)
+		}
+	}
+	return dependents
+}
+
+// RunVendorGraph deploys the vendors with up to parallelism concurrent deploys, each vendor
+// starting once its dependencies are deployed. The dependents of a failed vendor are skipped
+// while the unrelated vendors carry on. The results are in the order of the graph.
+func RunVendorGraph(graph VendorGraph, parallelism int, deploy func(node *VendorNode) error) []VendorResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	dependents := graph.Dependents()
+	waiting := map[string]int{}
+	var queue []string
+	for _, key := range graph.Order {
+		waiting[key] = len(graph.Nodes[key].DependsOn)
+		if waiting[key] == 0 {
+			queue = append(queue, key)
+		}
+	}
+
+	results := map[string]VendorResult{}
+	var skip func(key string, reason string)
+	skip = func(key string, reason string) {
+		for _, dependent := range dependents[key] {
+			if _, ok := results[dependent]; ok {
+				continue
+			}
+			results[dependent] = VendorResult{Node: graph.Nodes[dependent], Status: vendorStatusSkipped, Reason: reason}
+			log.Printf("Skipping vendor %s: %s\n", graph.Nodes[dependent].Name, reason)
+			skip(dependent, reason)
+		}
+	}
+
+	type finished struct {
+		key    string
+		result VendorResult
+	}
+	done := make(chan finished)
+	running := 0
+	for len(queue) > 0 || running > 0 {
+		for running < parallelism && len(queue) > 0 {
+			key := queue[0]
+			queue = queue[1:]
+			running++
+			go func(key string) {
+				node := graph.Nodes[key]
+				started := time.Now()
+				result := VendorResult{Node: node, Status: vendorStatusDeployed}
+				if err := deploy(node); err != nil {
+					result.Status, result.Err = vendorStatusFailed, err
+				}
+				result.Duration = time.Since(started).Round(time.Second)
+				done <- finished{key: key, result: result}
+			}(key)
+		}
+		next := <-done
+		running--
+		results[next.key] = next.result
+		if next.result.Err != nil {
+			log.Printf("Vendor %s failed: %v\n", next.result.Node.Name, next.result.Err)
+			skip(next.key, fmt.Sprintf("dependency %s failed", next.result.Node.Name))
+			continue
+		}
+		for _, dependent := range dependents[next.key] {
+			waiting[dependent]--
+			if _, skipped := results[dependent]; waiting[dependent] == 0 && !skipped {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	ordered := make([]VendorResult, 0, len(graph.Order))
+	for _, key := range graph.Order {
+		ordered = append(ordered, results[key])
+	}
+	return ordered
+}
+
+// PrintVendorSummary prints the outcome of every vendor.
+func PrintVendorSummary(results []VendorResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VENDOR\tTYPE\tSTATUS\tDURATION\tDETAILS")
+	for _, result := range results {
+		details := result.Reason
+		if result.Err != nil {
+			details = result.Err.Error()
+		}
+		duration := "-"
+		if result.Status != vendorStatusSkipped {
+			duration = result.Duration.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Node.Name, result.Node.Kind(), result.Status, duration, details)
+	}
+	w.Flush()
+}
+
+// vendorOutputMu serializes the lines written by concurrent vendor deploys
+var vendorOutputMu sync.Mutex
+
+// prefixWriter writes every complete line with the prefix, so that the output of concurrent
+// vendor deploys stays readable.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func newPrefixWriter(name string, out io.Writer) *prefixWriter {
+	return &prefixWriter{prefix: fmt.Sprintf("[%s] ", name), out: out}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush writes the last line when it has no trailing newline.
+func (w *prefixWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.writeLine(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	vendorOutputMu.Lock()
+	defer vendorOutputMu.Unlock()
+	fmt.Fprintf(w.out, "%s%s\n", w.prefix, line)
+}
+
+// VendorSelector restricts a vendor operation to some vendors of infra.yaml.
+type VendorSelector struct {
+	// Only selects these vendors, all when empty
+	Only []string
+	// Skip removes these vendors from the selection, even when they are dependencies
+	Skip []string
+	// WithDeps adds the dependencies of the selected vendors, transitively
+	WithDeps bool
+}
+
+var vendorSelector VendorSelector
+
+// addVendorSelectorFlags adds the --only, --skip and --with-deps flags to the command.
+func addVendorSelectorFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&vendorSelector.Only, "only", nil, "only these vendors, e.g. mysql,nginx (case-insensitive)")
+	cmd.Flags().StringSliceVar(&vendorSelector.Skip, "skip", nil, "all the vendors but these (case-insensitive)")
+	cmd.Flags().BoolVar(&vendorSelector.WithDeps, "with-deps", false, "add the vendors the selected vendors depend on")
+}
+
+// SelectVendors returns the graph of the selected vendors. Dependencies left out of the
+// selection are assumed to be in place and are dropped from the graph.
+func SelectVendors(graph VendorGraph, selector VendorSelector) (VendorGraph, error) {
+	keys := func(names []string) (map[string]bool, error) {
+		set := map[string]bool{}
+		for _, name := range names {
+			key := vendorKey(name)
+			if _, ok := graph.Nodes[key]; !ok {
+				return nil, errors.Errorf("Unknown vendor %s", name)
+			}
+			set[key] = true
+		}
+		return set, nil
+	}
+	only, err := keys(selector.Only)
+	if err != nil {
+		return graph, err
+	}
+	skip, err := keys(selector.Skip)
+	if err != nil {
+		return graph, err
+	}
+
+	selected := map[string]bool{}
+	var include func(key string)
+	include = func(key string) {
+		if selected[key] {
+			return
+		}
+		selected[key] = true
+		if selector.WithDeps {
+			for _, dependency := range graph.Nodes[key].DependsOn {
+				include(dependency)
+			}
+		}
+	}
+	for _, key := range graph.Order {
+		if len(only) == 0 || only[key] {
+			include(key)
+		}
+	}
+	for key := range skip {
+		delete(selected, key)
+	}
+
+	subgraph := VendorGraph{Nodes: map[string]*VendorNode{}}
+	for _, key := range graph.Order {
+		if !selected[key] {
+			continue
+		}
+		node := *graph.Nodes[key]
+		node.DependsOn = nil
+		for _, dependency := range graph.Nodes[key].DependsOn {
+			if selected[dependency] {
+				node.DependsOn = append(node.DependsOn, dependency)
+			} else {
+				node.Assumed = append(node.Assumed, graph.Nodes[dependency].Name)
+			}
+		}
+		subgraph.Nodes[key] = &node
+		subgraph.Order = append(subgraph.Order, key)
+	}
+	return subgraph, nil
+}
+
+// LoadVendorGraph loads the vendors of infra.yaml and returns the graph of the selected ones.
+func LoadVendorGraph(infrastructureDir string, selector VendorSelector) (InfraConfig, VendorGraph, error) {
+	infraConfig, err := LoadInfraConfig(infrastructureDir)
+	if err != nil {
+		return infraConfig, VendorGraph{}, err
+	}
+	graph, err := BuildVendorGraph(infraConfig.Vendors)
+	if err != nil {
+		return infraConfig, graph, err
+	}
+	graph, err = SelectVendors(graph, selector)
+	return infraConfig, graph, err
+}
+
+// Charts returns the chart vendors of the graph, in its order.
+func (g VendorGraph) Charts() []VendorChartConfig {
+	var charts []VendorChartConfig
+	for _, key := range g.Order {
+		if chart := g.Nodes[key].Chart; chart != nil {
+			charts = append(charts, *chart)
+		}
+	}
+	return charts
+}
@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// chartVendor returns a chart vendor depending on the given vendors.
+func chartVendor(name string, dependsOn ...string) VendorChartConfig {
+	return VendorChartConfig{Name: name, Chart: "bitnami/" + strings.ToLower(name), DependsOn: dependsOn}
+}
+
+func TestBuildVendorGraph(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		config    VendorConfig
+		wantOrder []string
+		wantErr   string
+	}{
+		{
+			name: "dependencies first, file order otherwise",
+			config: VendorConfig{
+				Scripts: []VendorScriptConfig{{Name: "Nginx"}},
+				Charts:  []VendorChartConfig{chartVendor("App", "mysql"), chartVendor("Redis"), chartVendor("MySQL", "NGINX")},
+			},
+			wantOrder: []string{"nginx", "mysql", "app", "redis"},
+		},
+		{
+			name: "cycle",
+			config: VendorConfig{
+				Scripts: []VendorScriptConfig{{Name: "Nginx"}},
+				Charts:  []VendorChartConfig{chartVendor("A", "b"), chartVendor("B", "c"), chartVendor("C", "a", "nginx")},
+			},
+			wantErr: "Vendor dependency cycle: A -> B -> C -> A",
+		},
+		{
+			name:    "self dependency",
+			config:  VendorConfig{Charts: []VendorChartConfig{chartVendor("A", "A")}},
+			wantErr: "Vendor dependency cycle: A -> A",
+		},
+		{
+			name:    "unknown dependency",
+			config:  VendorConfig{Charts: []VendorChartConfig{chartVendor("App", "postgres")}},
+			wantErr: "Vendor App depends on unknown vendor postgres",
+		},
+		{
+			name: "duplicate name",
+			config: VendorConfig{
+				Scripts: []VendorScriptConfig{{Name: "MySQL"}},
+				Charts:  []VendorChartConfig{chartVendor("mysql")},
+			},
+			wantErr: "Vendor mysql is declared more than once",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			graph, err := BuildVendorGraph(test.config)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("got %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(graph.Order, test.wantOrder) {
+				t.Errorf("got order %v, want %v", graph.Order, test.wantOrder)
+			}
+		})
+	}
+}
+
+// stubDeploy records the deploys and their concurrency, failing the vendors of failing.
+type stubDeploy struct {
+	mu            sync.Mutex
+	failing       map[string]bool
+	running       int
+	maxRunning    int
+	deployed      map[string]bool
+	outOfOrderErr error
+}
+
+func (s *stubDeploy) deploy(node *VendorNode) error {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.maxRunning {
+		s.maxRunning = s.running
+	}
+	for _, dependency := range node.DependsOn {
+		if !s.deployed[dependency] && s.outOfOrderErr == nil {
+			s.outOfOrderErr = errors.Errorf("%s started before its dependency %s was deployed", node.Name, dependency)
+		}
+	}
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	if s.failing[vendorKey(node.Name)] {
+		return errors.Errorf("%s failed", node.Name)
+	}
+	s.deployed[vendorKey(node.Name)] = true
+	return nil
+}
+
+func TestRunVendorGraphSkipsDependentsOfFailures(t *testing.T) {
+	graph, err := BuildVendorGraph(VendorConfig{
+		Scripts: []VendorScriptConfig{{Name: "Nginx"}},
+		Charts: []VendorChartConfig{
+			chartVendor("MySQL", "nginx"),
+			chartVendor("App", "mysql"),
+			chartVendor("Monitor", "app", "redis"),
+			chartVendor("Redis"),
+			chartVendor("Cache", "redis"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubDeploy{failing: map[string]bool{"mysql": true}, deployed: map[string]bool{}}
+	results := RunVendorGraph(graph, 2, stub.deploy)
+	if stub.outOfOrderErr != nil {
+		t.Error(stub.outOfOrderErr)
+	}
+
+	statuses := map[string]string{}
+	for _, result := range results {
+		statuses[result.Node.Name] = result.Status
+		if result.Status == vendorStatusSkipped && result.Reason != "dependency MySQL failed" {
+			t.Errorf("%s: got reason %q, want the failed dependency", result.Node.Name, result.Reason)
+		}
+	}
+	want := map[string]string{
+		"Nginx":   vendorStatusDeployed,
+		"MySQL":   vendorStatusFailed,
+		"App":     vendorStatusSkipped,
+		"Monitor": vendorStatusSkipped,
+		"Redis":   vendorStatusDeployed,
+		"Cache":   vendorStatusDeployed,
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got %v, want %v", statuses, want)
+	}
+	var order []string
+	for _, result := range results {
+		order = append(order, vendorKey(result.Node.Name))
+	}
+	if !reflect.DeepEqual(order, graph.Order) {
+		t.Errorf("got results in order %v, want the graph order %v", order, graph.Order)
+	}
+}
+
+func TestRunVendorGraphParallelism(t *testing.T) {
+	var charts []VendorChartConfig
+	for _, name := range []string{"A", "B", "C", "D", "E", "F"} {
+		charts = append(charts, chartVendor(name))
+	}
+	graph, err := BuildVendorGraph(VendorConfig{Charts: charts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		parallelism int
+		want        int
+	}{
+		{parallelism: 0, want: 1},
+		{parallelism: 1, want: 1},
+		{parallelism: 3, want: 3},
+		{parallelism: 10, want: 6},
+	} {
+		stub := &stubDeploy{deployed: map[string]bool{}}
+		results := RunVendorGraph(graph, test.parallelism, stub.deploy)
+		if stub.maxRunning != test.want {
+			t.Errorf("parallelism %d: got %d concurrent deploys, want %d", test.parallelism, stub.maxRunning, test.want)
+		}
+		for _, result := range results {
+			if result.Status != vendorStatusDeployed {
+				t.Errorf("parallelism %d: %s %s", test.parallelism, result.Node.Name, result.Status)
+			}
+		}
+	}
+}
+
+func TestSelectVendors(t *testing.T) {
+	graph, err := BuildVendorGraph(VendorConfig{
+		Scripts: []VendorScriptConfig{{Name: "Nginx"}},
+		Charts:  []VendorChartConfig{chartVendor("MySQL", "nginx"), chartVendor("App", "mysql"), chartVendor("Redis")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		selector VendorSelector
+		// want maps the selected vendors to their kept dependencies and assumed ones
+		want    map[string][2][]string
+		order   []string
+		wantErr string
+	}{
+		{
+			name:  "all",
+			order: []string{"nginx", "mysql", "app", "redis"},
+		},
+		{
+			name:     "only assumes the dependencies",
+			selector: VendorSelector{Only: []string{"APP"}},
+			order:    []string{"app"},
+			want:     map[string][2][]string{"app": {nil, {"MySQL"}}},
+		},
+		{
+			name:     "with deps adds the dependencies transitively",
+			selector: VendorSelector{Only: []string{"app"}, WithDeps: true},
+			order:    []string{"nginx", "mysql", "app"},
+			want: map[string][2][]string{
+				"nginx": {nil, nil},
+				"mysql": {{"nginx"}, nil},
+				"app":   {{"mysql"}, nil},
+			},
+		},
+		{
+			name:     "skip wins over with deps",
+			selector: VendorSelector{Only: []string{"app"}, Skip: []string{"Nginx"}, WithDeps: true},
+			order:    []string{"mysql", "app"},
+			want: map[string][2][]string{
+				"mysql": {nil, {"Nginx"}},
+				"app":   {{"mysql"}, nil},
+			},
+		},
+		{
+			name:     "skip alone",
+			selector: VendorSelector{Skip: []string{"mysql"}},
+			order:    []string{"nginx", "app", "redis"},
+			want: map[string][2][]string{
+				"nginx": {nil, nil},
+				"app":   {nil, {"MySQL"}},
+				"redis": {nil, nil},
+			},
+		},
+		{
+			name:     "unknown vendor",
+			selector: VendorSelector{Skip: []string{"postgres"}},
+			wantErr:  "Unknown vendor postgres",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := SelectVendors(graph, test.selector)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("got %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(selected.Order, test.order) {
+				t.Errorf("got %v, want %v", selected.Order, test.order)
+			}
+			for key, want := range test.want {
+				node := selected.Nodes[key]
+				if !reflect.DeepEqual(node.DependsOn, want[0]) || !reflect.DeepEqual(node.Assumed, want[1]) {
+					t.Errorf("%s: got dependencies %v and assumed %v, want %v and %v", key, node.DependsOn, node.Assumed, want[0], want[1])
+				}
+			}
+		})
+	}
+	// The selection leaves the graph untouched
+	if app := graph.Nodes["app"]; !reflect.DeepEqual(app.DependsOn, []string{"mysql"}) || app.Assumed != nil {
+		t.Errorf("got %+v, want the graph unchanged", app)
+	}
+}
+
+func TestVendorSelectorFlags(t *testing.T) {
+	defer func() { vendorSelector = VendorSelector{} }()
+	command := &cobra.Command{Use: "deploy"}
+	addVendorSelectorFlags(command)
+	if err := command.ParseFlags([]string{"--only", "app,Redis", "--skip=nginx", "--with-deps"}); err != nil {
+		t.Fatal(err)
+	}
+	want := VendorSelector{Only: []string{"app", "Redis"}, Skip: []string{"nginx"}, WithDeps: true}
+	if !reflect.DeepEqual(vendorSelector, want) {
+		t.Errorf("got %+v, want %+v", vendorSelector, want)
+	}
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// vendorLockFile is the lock file of the vendor charts, next to infra.yaml
+const vendorLockFile = "infra.lock.yaml"
+
+// LockedChart is the exact version a vendor chart resolves to.
+type LockedChart struct {
+	Name  string `yaml:"name"`
+	Chart string `yaml:"chart"`
+	// Constraint is the version constraint of infra.yaml the version was resolved from
+	Constraint string `yaml:"constraint,omitempty"`
+	Version    string `yaml:"version"`
+	AppVersion string `yaml:"appVersion,omitempty"`
+	// Digest is the sha256 digest of the chart archive
+	Digest string `yaml:"digest"`
+}
+
+// Matches tells whether the lock was resolved from the current chart and constraint of the vendor.
+func (c LockedChart) Matches(vendor VendorChartConfig) bool {
+	return c.Chart == vendor.Chart && c.Constraint == vendor.Version
+}
+
+// VendorLock is the content of infra.lock.yaml.
+type VendorLock struct {
+	Generated time.Time     `yaml:"generated"`
+	Charts    []LockedChart `yaml:"charts"`
+}
+
+// Get returns the lock of the vendor, nil when it is not locked.
+func (l VendorLock) Get(name string) *LockedChart {
+	for i := range l.Charts {
+		if vendorKey(l.Charts[i].Name) == vendorKey(name) {
+			return &l.Charts[i]
+		}
+	}
+	return nil
+}
+
+// Pin returns the vendor with the version of its lock, when it is locked with its current
+// chart and constraint.
+func (l VendorLock) Pin(vendor VendorChartConfig) VendorChartConfig {
+	if current := l.Get(vendor.Name); current != nil && current.Matches(vendor) {
+		vendor.Version = current.Version
+	}
+	return vendor
+}
+
+// Set replaces the lock of the vendor.
+func (l *VendorLock) Set(locked LockedChart) {
+	if current := l.Get(locked.Name); current != nil {
+		*current = locked
+		return
+	}
+	l.Charts = append(l.Charts, locked)
+}
+
+func init() {
+	vendorsLockCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsLockCmd.MarkFlagRequired("infrastructure_directory")
+	addVendorSelectorFlags(vendorsLockCmd)
+
+	vendorsCmd.AddCommand(vendorsLockCmd)
+}
+
+var vendorsLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Resolve the versions of the vendor charts into infra.lock.yaml",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsLock(infrastructureDir, vendorSelector); err != nil {
+			log.Fatalf("Error locking the vendors: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// LoadVendorLock reads the lock file of the infrastructure directory, empty when it does not exist.
+func LoadVendorLock(infrastructureDir string) (VendorLock, error) {
+	var lock VendorLock
+	lockFile := filepath.Join(infrastructureDir, vendorLockFile)
+	data, err := os.ReadFile(lockFile)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return lock, errors.Wrapf(err, "Failed to read file %s", lockFile)
+	}
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return lock, errors.Wrapf(err, "Failed to parse file %s", lockFile)
+	}
+	return lock, nil
+}
+
+// WriteVendorLock writes the lock file, keeping only the locks of the chart vendors of infra.yaml.
+func WriteVendorLock(infrastructureDir string, lock VendorLock, charts []VendorChartConfig) error {
+	vendors := map[string]bool{}
+	for _, vendor := range charts {
+		vendors[vendorKey(vendor.Name)] = true
+	}
+	written := VendorLock{Generated: time.Now().UTC()}
+	for _, locked := range lock.Charts {
+		if vendors[vendorKey(locked.Name)] {
+			written.Charts = append(written.Charts, locked)
+		}
+	}
+	sort.Slice(written.Charts, func(i, j int) bool {
+		return vendorKey(written.Charts[i].Name) < vendorKey(written.Charts[j].Name)
+	})
+	data, err := yaml.Marshal(written)
+	if err != nil {
+		return errors.Wrap(err, "Error encoding the vendor lock")
+	}
+	lockFile := filepath.Join(infrastructureDir, vendorLockFile)
+	return errors.Wrapf(os.WriteFile(lockFile, data, 0644), "Error writing file %s", lockFile)
+}
+
+// IsLocalChart tells whether the chart is a local directory or archive. Local charts are not locked.
+func IsLocalChart(chart string) bool {
+	return filepath.IsAbs(chart) || strings.HasPrefix(chart, ".")
+}
+
+// ResolveChartVersion resolves the version constraint of the vendor to the newest version of
+// the chart that satisfies it. Repository charts are resolved with the cached index of their
+// repository, OCI charts need an exact version.
+func ResolveChartVersion(infraConfig InfraConfig, vendor VendorChartConfig) (LockedChart, error) {
+	locked := LockedChart{Name: vendor.Name, Chart: vendor.Chart, Constraint: vendor.Version}
+	if strings.HasPrefix(vendor.Chart, "oci://") {
+		if _, err := semver.StrictNewVersion(vendor.Version); err != nil {
+			return locked, errors.Errorf("OCI chart %s of vendor %s needs an exact version, not %q", vendor.Chart, vendor.Name, vendor.Version)
+		}
+		locked.Version = vendor.Version
+		digest, err := chartArchiveDigest(infraConfig, vendor, vendor.Version)
+		locked.Digest = digest
+		return locked, err
+	}
+
+	index, chart, err := VendorChartIndex(infraConfig, vendor)
+	if err != nil {
+		return locked, err
+	}
+
+	constraint := "*"
+	if vendor.Version != "" {
+		constraint = vendor.Version
+	}
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return locked, errors.Wrapf(err, "Invalid version %q of vendor %s", vendor.Version, vendor.Name)
+	}
+	for _, candidate := range index.Entries[chart] {
+		version, err := semver.NewVersion(candidate.Version)
+		if err != nil || !constraints.Check(version) {
+			continue
+		}
+		locked.Version = candidate.Version
+		locked.AppVersion = candidate.AppVersion
+		locked.Digest = "sha256:" + candidate.Digest
+		if candidate.Digest == "" {
+			locked.Digest, err = chartArchiveDigest(infraConfig, vendor, candidate.Version)
+		}
+		return locked, err
+	}
+	return locked, errors.Errorf("No version of chart %s satisfies %q (vendor %s)", vendor.Chart, constraint, vendor.Name)
+}
+
+// VendorChartIndex returns the cached index of the repository of the vendor chart and the name
+// of the chart in it.
+func VendorChartIndex(infraConfig InfraConfig, vendor VendorChartConfig) (*repo.IndexFile, string, error) {
+	repository, chart := VendorRepository(infraConfig, vendor)
+	if repository == nil {
+		return nil, "", errors.Errorf("Repository of chart %s of vendor %s is not in the repositories section of infra.yaml", vendor.Chart, vendor.Name)
+	}
+	cache, err := HelmRepositoryCache()
+	if err != nil {
+		return nil, "", err
+	}
+	index, err := cache.LoadIndex(repository.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	return index, chart, nil
+}
+
+// chartArchiveDigest pulls the chart archive of the vendor at the version and returns its digest.
+func chartArchiveDigest(infraConfig InfraConfig, vendor VendorChartConfig, version string) (string, error) {
+	vendor.Version = version
+	_, digest, cleanup, err := PullVendorChartTemp(infraConfig, vendor)
+	if err != nil {
+		return "", err
+	}
+	cleanup()
+	return digest, nil
+}
+
+// LockVendorCharts returns the locks of the charts, by vendor key. Every repository chart must
+// be locked with its current chart and constraint; with update, the charts are resolved again
+// and infra.lock.yaml is updated.
+func LockVendorCharts(
+	infrastructureDir string,
+	infraConfig InfraConfig,
+	charts []VendorChartConfig,
+	update bool,
+) (map[string]LockedChart, error) {
+	lock, err := LoadVendorLock(infrastructureDir)
+	if err != nil {
+		return nil, err
+	}
+	locks := map[string]LockedChart{}
+	var unlocked []string
+	for _, vendor := range charts {
+		if IsLocalChart(vendor.Chart) {
+			continue
+		}
+		if update {
+			locked, err := ResolveChartVersion(infraConfig, vendor)
+			if err != nil {
+				return nil, err
+			}
+			lock.Set(locked)
+		}
+		locked := lock.Get(vendor.Name)
+		if locked == nil || !locked.Matches(vendor) {
+			unlocked = append(unlocked, vendor.Name)
+			continue
+		}
+		locks[vendorKey(vendor.Name)] = *locked
+	}
+	if len(unlocked) > 0 {
+		return nil, errors.Errorf(
+			"Vendors %s are not locked in %s or their chart or version changed, run deployer vendors lock or deploy with --update",
+			strings.Join(unlocked, ", "),
+			vendorLockFile,
+		)
+	}
+	if update {
+		if err := WriteVendorLock(infrastructureDir, lock, infraConfig.Vendors.Charts); err != nil {
+			return nil, err
+		}
+	}
+	return locks, nil
+}
+
+// LockedVendorVersions returns the charts pinned to the version of their lock. Charts that are
+// not locked keep their constraint.
+func LockedVendorVersions(infrastructureDir string, charts []VendorChartConfig) ([]VendorChartConfig, error) {
+	lock, err := LoadVendorLock(infrastructureDir)
+	if err != nil {
+		return nil, err
+	}
+	var locked []VendorChartConfig
+	for _, vendor := range charts {
+		locked = append(locked, lock.Pin(vendor))
+	}
+	return locked, nil
+}
+
+func runVendorsLock(infrastructureDir string, selector VendorSelector) error {
+	infraConfig, graph, err := LoadVendorGraph(infrastructureDir, selector)
+	if err != nil {
+		return err
+	}
+	if err := EnsureHelmRepositories(infraConfig, true); err != nil {
+		return err
+	}
+	locks, err := LockVendorCharts(infrastructureDir, infraConfig, graph.Charts(), true)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCHART\tCONSTRAINT\tVERSION\tAPP VERSION\tDIGEST")
+	for _, vendor := range graph.Charts() {
+		locked, ok := locks[vendorKey(vendor.Name)]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%s\t-\tlocal\t-\t-\n", vendor.Name, vendor.Chart)
+			continue
+		}
+		constraint := locked.Constraint
+		if constraint == "" {
+			constraint = "latest"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", locked.Name, locked.Chart, constraint, locked.Version, locked.AppVersion, locked.Digest)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	log.Printf("Vendor charts locked in %s\n", filepath.Join(infrastructureDir, vendorLockFile))
+	return nil
+}
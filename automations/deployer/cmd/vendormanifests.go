@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deployer/pkg/manifests"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// manifestsWaitInterval is the polling interval of the wait conditions
+const manifestsWaitInterval = 2 * time.Second
+
+// NewManifestsApplier returns an applier of the manifests of the vendor on the cluster of the
+// current kubeconfig context.
+func NewManifestsApplier(vendor VendorManifestsConfig) (manifests.Applier, error) {
+	config, err := KubeRestConfig()
+	if err != nil {
+		return manifests.Applier{}, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return manifests.Applier{}, errors.Wrap(err, "Error creating the dynamic kubernetes client")
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return manifests.Applier{}, errors.Wrap(err, "Error creating the discovery client")
+	}
+	return manifests.Applier{
+		Client:    client,
+		Mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+		Owner:     vendorKey(vendor.Name),
+		Namespace: vendor.Namespace,
+	}, nil
+}
+
+// WaitConditions converts the wait conditions of the vendor.
+func WaitConditions(vendor VendorManifestsConfig) ([]manifests.WaitCondition, error) {
+	var conditions []manifests.WaitCondition
+	for _, wait := range vendor.Wait {
+		condition := manifests.WaitCondition{
+			APIVersion: wait.APIVersion,
+			Kind:       wait.Kind,
+			Namespace:  wait.Namespace,
+			Name:       wait.Name,
+			Selector:   wait.Selector,
+			Condition:  wait.Condition,
+		}
+		if condition.Namespace == "" {
+			condition.Namespace = vendor.Namespace
+		}
+		if wait.Kind == "" || wait.Condition == "" {
+			return nil, errors.Errorf("Wait condition of vendor %s needs a kind and a condition", vendor.Name)
+		}
+		if wait.Timeout != "" {
+			timeout, err := time.ParseDuration(wait.Timeout)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid timeout %s of vendor %s", wait.Timeout, vendor.Name)
+			}
+			condition.Timeout = timeout
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+func deployVendorManifests(
+	vendorsDir string,
+	vendor VendorManifestsConfig,
+	logger *log.Logger,
+) error {
+	logger.Printf("Deploying vendor %s\n", vendor.Name)
+	conditions, err := WaitConditions(vendor)
+	if err != nil {
+		return err
+	}
+	vendorDir := filepath.Join(vendorsDir, strings.ToLower(vendor.Name))
+	objects, err := manifests.Load(vendor.Sources, vendorDir, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading the manifests of %s", vendor.Name)
+	}
+	applier, err := NewManifestsApplier(vendor)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	applied, pruned, err := applier.Sync(ctx, objects)
+	if err != nil {
+		return errors.Wrapf(err, "Error applying the manifests of %s", vendor.Name)
+	}
+	logger.Printf("Applied %d objects\n", len(applied))
+	for _, ref := range pruned {
+		logger.Printf("Pruned %s\n", ref)
+	}
+	for _, condition := range conditions {
+		logger.Printf("Waiting for %s\n", condition)
+		if err := applier.Wait(ctx, condition, manifestsWaitInterval); err != nil {
+			return err
+		}
+	}
+	logger.Printf("Vendor %s deployed\n", vendor.Name)
+	return nil
+}
@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// VendorOutdated compares the current version of a vendor chart to the versions of its repository.
+type VendorOutdated struct {
+	Vendor VendorChartConfig
+	// Current is the locked version, or the installed one when the chart is not locked
+	Current string
+	// Source tells where the current version comes from: lock, installed or none
+	Source string
+	// Wanted is the newest version satisfying the constraint of infra.yaml
+	Wanted string
+	// Latest is the newest stable version of the repository
+	Latest            string
+	CurrentAppVersion string
+	LatestAppVersion  string
+	// Locked is the version of the lock, also when the chart or constraint changed since
+	Locked string
+	// CurrentDeprecated, LockedDeprecated and LatestDeprecated are set when the version is
+	// deprecated in the repository index
+	CurrentDeprecated bool
+	LockedDeprecated  bool
+	LatestDeprecated  bool
+}
+
+// DeprecatedVersions returns the versions that are deprecated among current, locked and latest.
+// The locked version is left out when it is the current one.
+func (o VendorOutdated) DeprecatedVersions() []string {
+	var deprecated []string
+	if o.CurrentDeprecated {
+		deprecated = append(deprecated, fmt.Sprintf("current %s", o.Current))
+	}
+	if o.LockedDeprecated && o.Source != "lock" {
+		deprecated = append(deprecated, fmt.Sprintf("locked %s", o.Locked))
+	}
+	if o.LatestDeprecated {
+		deprecated = append(deprecated, fmt.Sprintf("latest %s", o.Latest))
+	}
+	return deprecated
+}
+
+// Gap returns the part of the version the latest version is ahead by: major, minor or patch.
+func (o VendorOutdated) Gap() string {
+	current, errCurrent := semver.NewVersion(o.Current)
+	latest, errLatest := semver.NewVersion(o.Latest)
+	switch {
+	case errCurrent != nil || errLatest != nil:
+		return "unknown"
+	case !latest.GreaterThan(current):
+		return "up to date"
+	case latest.Major() != current.Major():
+		return "major"
+	case latest.Minor() != current.Minor():
+		return "minor"
+	default:
+		return "patch"
+	}
+}
+
+var vendorsOutdatedPlan bool
+
+func init() {
+	vendorsOutdatedCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsOutdatedCmd.MarkFlagRequired("infrastructure_directory")
+	vendorsOutdatedCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment whose values are checked by the upgrade plan")
+	vendorsOutdatedCmd.MarkFlagRequired("target_environment")
+	vendorsOutdatedCmd.Flags().BoolVar(&vendorsOutdatedPlan, "plan", false, "render the upgrade plan of the outdated vendors")
+	addVendorSelectorFlags(vendorsOutdatedCmd)
+
+	vendorsCmd.AddCommand(vendorsOutdatedCmd)
+}
+
+var vendorsOutdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "Compare the vendor chart versions to the latest versions of their repositories",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsOutdated(infrastructureDir, targetEnvironment, vendorsOutdatedPlan, vendorSelector); err != nil {
+			log.Fatalf("Error running vendors outdated: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// GetVendorOutdated looks up the current, wanted and latest versions of the vendor chart.
+func GetVendorOutdated(infraConfig InfraConfig, lock VendorLock, vendor VendorChartConfig) (VendorOutdated, error) {
+	outdated := VendorOutdated{Vendor: vendor, Source: "none"}
+	index, chart, err := VendorChartIndex(infraConfig, vendor)
+	if err != nil {
+		return outdated, err
+	}
+
+	locked := lock.Get(vendor.Name)
+	if locked != nil {
+		outdated.Locked = locked.Version
+		if version, err := index.Get(chart, locked.Version); err == nil {
+			outdated.LockedDeprecated = version.Deprecated
+		}
+	}
+	if locked != nil && locked.Matches(vendor) {
+		outdated.Current, outdated.Source = locked.Version, "lock"
+	} else {
+		release, err := GetHelmRelease(vendor.ReleaseName, vendor.Namespace)
+		if err != nil {
+			return outdated, err
+		}
+		if release != nil {
+			outdated.Current, outdated.Source = strings.TrimPrefix(release.Chart, chart+"-"), "installed"
+		}
+	}
+	if outdated.Current != "" {
+		if current, err := index.Get(chart, outdated.Current); err == nil {
+			outdated.CurrentAppVersion = current.AppVersion
+			outdated.CurrentDeprecated = current.Deprecated
+		}
+	}
+
+	latest, err := index.Get(chart, "")
+	if err != nil {
+		return outdated, err
+	}
+	outdated.Latest = latest.Version
+	outdated.LatestAppVersion = latest.AppVersion
+	outdated.LatestDeprecated = latest.Deprecated
+
+	constraint := "*"
+	if vendor.Version != "" {
+		constraint = vendor.Version
+	}
+	if constraints, err := semver.NewConstraint(constraint); err == nil {
+		for _, candidate := range index.Entries[chart] {
+			if version, err := semver.NewVersion(candidate.Version); err == nil && constraints.Check(version) {
+				outdated.Wanted = candidate.Version
+				break
+			}
+		}
+	}
+	return outdated, nil
+}
+
+// PrintVendorOutdated prints a table of the versions of the vendor charts.
+func PrintVendorOutdated(report []VendorOutdated) error {
+	orDash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VENDOR\tCHART\tCURRENT\tWANTED\tLATEST\tGAP\tAPP VERSION\tDEPRECATED")
+	for _, outdated := range report {
+		current := orDash(outdated.Current)
+		if outdated.Source != "none" {
+			current = fmt.Sprintf("%s (%s)", outdated.Current, outdated.Source)
+		}
+		appVersion := orDash(outdated.LatestAppVersion)
+		if outdated.CurrentAppVersion != outdated.LatestAppVersion {
+			appVersion = fmt.Sprintf("%s -> %s", orDash(outdated.CurrentAppVersion), orDash(outdated.LatestAppVersion))
+		}
+		deprecated := orDash(strings.Join(outdated.DeprecatedVersions(), ", "))
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			outdated.Vendor.Name, outdated.Vendor.Chart, current, orDash(outdated.Wanted),
+			orDash(outdated.Latest), outdated.Gap(), appVersion, deprecated)
+	}
+	return w.Flush()
+}
+
+// GetChartDefaultValues returns the default values of the chart version of the vendor.
+func GetChartDefaultValues(infraConfig InfraConfig, vendor VendorChartConfig, version string) (interface{}, error) {
+	vendor.Version = version
+	pulled, _, cleanup, err := PullVendorChartTemp(infraConfig, vendor)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	cmd := HelmCommand("show", "values", pulled.Chart)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting the values of chart %s %s: %s", vendor.Chart, version, strings.TrimSpace(stderr.String()))
+	}
+	var values interface{}
+	if err := yaml.Unmarshal(out, &values); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing the values of chart %s %s", vendor.Chart, version)
+	}
+	return values, nil
+}
+
+// ValuesKeys returns the dotted paths of every key of the values tree, lists being leaves.
+func ValuesKeys(path string, values interface{}, keys map[string]bool) map[string]bool {
+	if keys == nil {
+		keys = map[string]bool{}
+	}
+	valuesMap, ok := values.(map[interface{}]interface{})
+	if !ok {
+		return keys
+	}
+	for key, value := range valuesMap {
+		keyPath := fmt.Sprint(key)
+		if path != "" {
+			keyPath = path + "." + keyPath
+		}
+		keys[keyPath] = true
+		ValuesKeys(keyPath, value, keys)
+	}
+	return keys
+}
+
+// hasValuesKey tells whether the key, or one of its parents, is a key of the chart values.
+// Free-form keys, e.g. under resources: {}, only exist as their parent.
+func hasValuesKey(keys map[string]bool, key string) bool {
+	parts := strings.Split(key, ".")
+	for i := len(parts); i > 0; i-- {
+		if keys[strings.Join(parts[:i], ".")] {
+			return true
+		}
+	}
+	return false
+}
+
+// StaleValuesKeys returns the keys set by the vendor values that are values of the old chart
+// but not of the new one, each with the new keys of the same name it may have been renamed to.
+// Only the leaf keys of the vendor values are compared.
+func StaleValuesKeys(vendorValues interface{}, oldDefaults interface{}, newDefaults interface{}) map[string][]string {
+	oldKeys := ValuesKeys("", oldDefaults, nil)
+	newKeys := ValuesKeys("", newDefaults, nil)
+	leaf := func(key string) string {
+		return key[strings.LastIndex(key, ".")+1:]
+	}
+	vendorKeys := ValuesKeys("", vendorValues, nil)
+	isParent := map[string]bool{}
+	for key := range vendorKeys {
+		if i := strings.LastIndex(key, "."); i > 0 {
+			isParent[key[:i]] = true
+		}
+	}
+	stale := map[string][]string{}
+	for key := range vendorKeys {
+		if isParent[key] || !hasValuesKey(oldKeys, key) || hasValuesKey(newKeys, key) {
+			continue
+		}
+		var candidates []string
+		for newKey := range newKeys {
+			if !oldKeys[newKey] && leaf(newKey) == leaf(key) {
+				candidates = append(candidates, newKey)
+			}
+		}
+		sort.Strings(candidates)
+		stale[key] = candidates
+	}
+	return stale
+}
+
+// PrintVendorUpgradePlan renders the vendor chart at its current and latest versions with the
+// values of the environment, and prints the manifests changes and the stale values keys.
+func PrintVendorUpgradePlan(infraConfig InfraConfig, vendorsDir string, outdated VendorOutdated, environment string) error {
+	vendorDir := filepath.Join(vendorsDir, strings.ToLower(outdated.Vendor.Name))
+	current, latest := outdated.Vendor, outdated.Vendor
+	current.Version, latest.Version = outdated.Current, outdated.Latest
+	fmt.Printf("\n%s: upgrade plan from %s to %s\n", outdated.Vendor.Name, outdated.Current, outdated.Latest)
+
+	current, _, cleanupCurrent, err := PullVendorChartTemp(infraConfig, current)
+	if err != nil {
+		return err
+	}
+	defer cleanupCurrent()
+	latest, _, cleanupLatest, err := PullVendorChartTemp(infraConfig, latest)
+	if err != nil {
+		return err
+	}
+	defer cleanupLatest()
+	before, err := RenderVendorManifests(vendorDir, current, environment)
+	if err != nil {
+		return err
+	}
+	after, err := RenderVendorManifests(vendorDir, latest, environment)
+	if err != nil {
+		return err
+	}
+	diff, changed, err := DiffManifestsLabeled(before, after, outdated.Current, outdated.Latest)
+	if err != nil {
+		return errors.Wrapf(err, "Error comparing the manifests of %s", outdated.Vendor.Name)
+	}
+	fmt.Printf("%d resources change\n", changed)
+	fmt.Print(diff)
+
+	rendered, err := RenderVendorValues(vendorDir, outdated.Vendor, environment)
+	if err != nil {
+		return err
+	}
+	var vendorValues interface{}
+	if err := yaml.Unmarshal(rendered, &vendorValues); err != nil {
+		return errors.Wrapf(err, "Error parsing the values of %s", outdated.Vendor.Name)
+	}
+	oldDefaults, err := GetChartDefaultValues(infraConfig, outdated.Vendor, outdated.Current)
+	if err != nil {
+		return err
+	}
+	newDefaults, err := GetChartDefaultValues(infraConfig, outdated.Vendor, outdated.Latest)
+	if err != nil {
+		return err
+	}
+	stale := StaleValuesKeys(vendorValues, oldDefaults, newDefaults)
+	if len(stale) == 0 {
+		fmt.Printf("All the keys of values.%s.yaml are values of %s\n", environment, outdated.Latest)
+		return nil
+	}
+	var keys []string
+	for key := range stale {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Printf("Keys of values.%s.yaml removed in %s:\n", environment, outdated.Latest)
+	for _, key := range keys {
+		if len(stale[key]) > 0 {
+			fmt.Printf("  %s (renamed to %s?)\n", key, strings.Join(stale[key], " or "))
+		} else {
+			fmt.Printf("  %s\n", key)
+		}
+	}
+	return nil
+}
+
+func runVendorsOutdated(infrastructureDir string, environment string, plan bool, selector VendorSelector) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	infraConfig, graph, err := LoadVendorGraph(infrastructureDir, selector)
+	if err != nil {
+		return err
+	}
+	if err := EnsureHelmRepositories(infraConfig, true); err != nil {
+		return err
+	}
+	lock, err := LoadVendorLock(infrastructureDir)
+	if err != nil {
+		return err
+	}
+
+	var report []VendorOutdated
+	for _, vendor := range graph.Charts() {
+		if IsLocalChart(vendor.Chart) || strings.HasPrefix(vendor.Chart, "oci://") {
+			log.Printf("Skipping vendor %s, chart %s has no repository index\n", vendor.Name, vendor.Chart)
+			continue
+		}
+		outdated, err := GetVendorOutdated(infraConfig, lock, vendor)
+		if err != nil {
+			return err
+		}
+		report = append(report, outdated)
+	}
+	if err := PrintVendorOutdated(report); err != nil {
+		return err
+	}
+	if !plan {
+		return nil
+	}
+
+	vendorsDir := filepath.Join(infrastructureDir, "vendors")
+	for _, outdated := range report {
+		switch outdated.Gap() {
+		case "up to date":
+			continue
+		case "unknown":
+			log.Printf("Skipping the upgrade plan of %s, its current version is unknown\n", outdated.Vendor.Name)
+			continue
+		}
+		if err := PrintVendorUpgradePlan(infraConfig, vendorsDir, outdated, environment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetVendorOutdatedReportsDeprecatedVersions(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	cache, err := HelmRepositoryCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, cache.IndexFile("bitnami"), `apiVersion: v1
+entries:
+  mysql:
+    - name: mysql
+      version: 9.5.0
+      appVersion: 8.0.31
+      digest: "2222"
+    - name: mysql
+      version: 9.4.1
+      appVersion: 8.0.30
+      deprecated: true
+      digest: "1111"
+generated: "2022-09-01T00:00:00Z"
+`)
+	infraConfig := InfraConfig{Repositories: []RepositoryConfig{{Name: "bitnami", URL: "https://charts.bitnami.com/bitnami"}}}
+	vendor := VendorChartConfig{Name: "MySQL", Chart: "bitnami/mysql", Version: "^9.4.0"}
+	lock := VendorLock{Charts: []LockedChart{{Name: "MySQL", Chart: "bitnami/mysql", Constraint: "^9.4.0", Version: "9.4.1"}}}
+
+	outdated, err := GetVendorOutdated(infraConfig, lock, vendor)
+	if err != nil {
+		t.Fatalf("GetVendorOutdated: %v", err)
+	}
+	if outdated.Current != "9.4.1" || outdated.Source != "lock" || outdated.Latest != "9.5.0" || outdated.Wanted != "9.5.0" {
+		t.Errorf("got %+v, want 9.4.1 from the lock and 9.5.0 wanted and latest", outdated)
+	}
+	if !outdated.CurrentDeprecated || !outdated.LockedDeprecated || outdated.LatestDeprecated {
+		t.Errorf("got %+v, want the current and locked versions deprecated", outdated)
+	}
+	if got := outdated.DeprecatedVersions(); !reflect.DeepEqual(got, []string{"current 9.4.1"}) {
+		t.Errorf("got %v, want the current version only, it is the locked one", got)
+	}
+
+	// A lock resolved from another constraint is still reported
+	outdated.Source, outdated.Current, outdated.CurrentDeprecated = "installed", "9.5.0", false
+	if got := outdated.DeprecatedVersions(); !reflect.DeepEqual(got, []string{"locked 9.4.1"}) {
+		t.Errorf("got %v, want the locked version", got)
+	}
+}
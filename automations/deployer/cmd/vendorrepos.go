@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"deployer/pkg/helmrepo"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// RepositoryConfig is a chart repository of the repositories section of infra.yaml.
+type RepositoryConfig struct {
+	Name string `yaml:"name" schema:"required"`
+	// URL is the repository URL, or an oci:// registry URL
+	URL string `yaml:"url" schema:"required"`
+	// UsernameEnv and PasswordEnv name the environment variables holding the credentials
+	UsernameEnv string `yaml:"usernameEnv"`
+	PasswordEnv string `yaml:"passwordEnv"`
+}
+
+// helmEnv selects the deployer repository cache in the helm commands, it is set once the
+// repositories of infra.yaml are in place
+var helmEnv []string
+
+func init() {
+	vendorsReposUpdateCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsReposUpdateCmd.MarkFlagRequired("infrastructure_directory")
+
+	vendorsReposCmd.AddCommand(vendorsReposUpdateCmd)
+	vendorsCmd.AddCommand(vendorsReposCmd)
+}
+
+var vendorsReposCmd = &cobra.Command{
+	Use:   "repos",
+	Short: "Manage the chart repositories of infra.yaml",
+	Run:   func(cmd *cobra.Command, args []string) {},
+}
+
+var vendorsReposUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Download the indexes of the chart repositories and log in to the OCI registries",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsReposUpdate(infrastructureDir); err != nil {
+			log.Fatalf("Error updating the chart repositories: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// HelmRepositoryCache returns the cache holding the repositories of infra.yaml and their indexes.
+func HelmRepositoryCache() (helmrepo.Cache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return helmrepo.Cache{}, errors.Wrap(err, "Error getting the cache directory")
+	}
+	return helmrepo.Cache{Dir: filepath.Join(dir, "deployer", "helm", "repository")}, nil
+}
+
+// HelmCommand returns a helm command that resolves the charts of the repositories of infra.yaml.
+func HelmCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("helm", args...)
+	if len(helmEnv) > 0 {
+		cmd.Env = append(os.Environ(), helmEnv...)
+	}
+	return cmd
+}
+
+// RepositoryEntry returns the repository with the credentials of its environment variables.
+func RepositoryEntry(repository RepositoryConfig) (helmrepo.Entry, error) {
+	if repository.Name == "" || repository.URL == "" {
+		return helmrepo.Entry{}, errors.New("Repositories of infra.yaml need a name and a url")
+	}
+	entry := helmrepo.Entry{Name: repository.Name, URL: repository.URL}
+	if repository.UsernameEnv != "" {
+		entry.Username = os.Getenv(repository.UsernameEnv)
+	}
+	if repository.PasswordEnv != "" {
+		entry.Password = os.Getenv(repository.PasswordEnv)
+	}
+	if (entry.Username == "") != (entry.Password == "") {
+		return helmrepo.Entry{}, errors.Errorf(
+			"Repository %s needs both %s and %s to be set",
+			repository.Name,
+			repository.UsernameEnv,
+			repository.PasswordEnv,
+		)
+	}
+	return entry, nil
+}
+
+// EnsureHelmRepositories makes the repositories of infra.yaml available to the helm commands.
+// The indexes are downloaded when refresh is set or when they are not cached yet; a cached
+// index is used when the repository cannot be reached. Without repositories, helm keeps using
+// its own configuration.
+func EnsureHelmRepositories(infraConfig InfraConfig, refresh bool) error {
+	if len(infraConfig.Repositories) == 0 {
+		return nil
+	}
+	cache, err := HelmRepositoryCache()
+	if err != nil {
+		return err
+	}
+	var entries []helmrepo.Entry
+	for _, repository := range infraConfig.Repositories {
+		entry, err := RepositoryEntry(repository)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		if entry.IsOCI() {
+			if err := helmRegistryLogin(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		if !refresh && cache.HasIndex(entry.Name) {
+			continue
+		}
+		log.Printf("Updating chart repository %s (%s)\n", entry.Name, entry.URL)
+		if _, err := cache.DownloadIndex(entry); err != nil {
+			if !cache.HasIndex(entry.Name) {
+				return errors.Wrapf(err, "Error updating chart repository %s", entry.Name)
+			}
+			log.Printf("Warning: using the cached index of chart repository %s: %v\n", entry.Name, err)
+		}
+	}
+	if err := cache.WriteRepositories(entries); err != nil {
+		return err
+	}
+	helmEnv = cache.Env()
+	return nil
+}
+
+// VendorRepository returns the repository of infra.yaml the chart of the vendor comes from,
+// with the name of the chart in it. It is nil for OCI and local charts, and for charts of the
+// repositories helm is configured with.
+func VendorRepository(infraConfig InfraConfig, vendor VendorChartConfig) (*RepositoryConfig, string) {
+	parts := strings.SplitN(vendor.Chart, "/", 2)
+	if len(parts) != 2 || IsLocalChart(vendor.Chart) {
+		return nil, ""
+	}
+	for i := range infraConfig.Repositories {
+		repository := &infraConfig.Repositories[i]
+		if repository.Name == parts[0] && !strings.HasPrefix(repository.URL, "oci://") {
+			return repository, parts[1]
+		}
+	}
+	return nil, ""
+}
+
+// PullVendorChart downloads the archive of the vendor chart, at its version or newest version
+// of its constraint, into the directory. It returns the vendor with its chart set to the
+// archive, and the sha256 digest of the archive. Charts of the repositories of infra.yaml are
+// downloaded with the credentials of their repository and verified against its cached index;
+// the others are pulled with helm. Local charts are returned as they are.
+func PullVendorChart(infraConfig InfraConfig, vendor VendorChartConfig, dir string) (VendorChartConfig, string, error) {
+	if IsLocalChart(vendor.Chart) {
+		return vendor, "", nil
+	}
+	var archive, digest string
+	if repository, chart := VendorRepository(infraConfig, vendor); repository != nil {
+		entry, err := RepositoryEntry(*repository)
+		if err != nil {
+			return vendor, "", err
+		}
+		cache, err := HelmRepositoryCache()
+		if err != nil {
+			return vendor, "", err
+		}
+		index, err := cache.LoadIndex(repository.Name)
+		if err != nil {
+			return vendor, "", err
+		}
+		version, err := index.Get(chart, vendor.Version)
+		if err != nil {
+			return vendor, "", errors.Wrapf(err, "Chart %s %s of vendor %s not found", vendor.Chart, vendor.Version, vendor.Name)
+		}
+		if archive, digest, err = helmrepo.DownloadChart(entry, version, dir); err != nil {
+			return vendor, "", errors.Wrapf(err, "Error pulling chart %s of vendor %s", vendor.Chart, vendor.Name)
+		}
+	} else {
+		var err error
+		if archive, err = helmPullChart(vendor, dir); err != nil {
+			return vendor, "", err
+		}
+		hash, err := fileSHA256(archive)
+		if err != nil {
+			return vendor, "", errors.Wrapf(err, "Error hashing %s", archive)
+		}
+		digest = "sha256:" + hash
+	}
+	vendor.Chart, vendor.Version = archive, ""
+	return vendor, digest, nil
+}
+
+// PullVendorChartTemp pulls the chart of the vendor like PullVendorChart into a temporary
+// directory, removed by the returned function.
+func PullVendorChartTemp(infraConfig InfraConfig, vendor VendorChartConfig) (VendorChartConfig, string, func(), error) {
+	dir, err := os.MkdirTemp("", "deployer-chart-")
+	if err != nil {
+		return vendor, "", nil, errors.Wrap(err, "Error creating the chart directory")
+	}
+	pulled, digest, err := PullVendorChart(infraConfig, vendor, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return vendor, "", nil, err
+	}
+	return pulled, digest, func() { os.RemoveAll(dir) }, nil
+}
+
+// helmPullChart downloads the chart archive of the vendor into the directory with helm pull.
+func helmPullChart(vendor VendorChartConfig, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	cmd := HelmCommand("pull", vendor.Chart, "--destination", dir)
+	if vendor.Version != "" {
+		cmd.Args = append(cmd.Args, "--version", vendor.Version)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "Error pulling chart %s: %s", vendor.Chart, strings.TrimSpace(string(out)))
+	}
+	archives, err := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	if err != nil || len(archives) != 1 {
+		return "", errors.Errorf("Expected one chart archive of %s in %s", vendor.Chart, dir)
+	}
+	return archives[0], nil
+}
+
+// helmRegistryLogin logs helm in to the OCI registry when it has credentials.
+func helmRegistryLogin(entry helmrepo.Entry) error {
+	if entry.Username == "" {
+		return nil
+	}
+	host := strings.SplitN(strings.TrimPrefix(entry.URL, "oci://"), "/", 2)[0]
+	cmd := exec.Command("helm", "registry", "login", host, "--username", entry.Username, "--password-stdin")
+	cmd.Stdin = strings.NewReader(entry.Password)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "Error logging in to OCI registry %s: %s", host, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func runVendorsReposUpdate(infrastructureDir string) error {
+	infraConfig, err := LoadInfraConfig(infrastructureDir)
+	if err != nil {
+		return err
+	}
+	if len(infraConfig.Repositories) == 0 {
+		log.Printf("No chart repository in the repositories section of infra.yaml\n")
+		return nil
+	}
+	if err := EnsureHelmRepositories(infraConfig, true); err != nil {
+		return err
+	}
+	cache, err := HelmRepositoryCache()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tURL\tCHARTS")
+	for _, repository := range infraConfig.Repositories {
+		charts := "OCI registry"
+		if !strings.HasPrefix(repository.URL, "oci://") {
+			index, err := cache.LoadIndex(repository.Name)
+			if err != nil {
+				return err
+			}
+			charts = fmt.Sprint(len(index.Entries))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", repository.Name, repository.URL, charts)
+	}
+	return w.Flush()
+}
@@ -14,22 +14,79 @@ import (
 )
 
 type VendorChartConfig struct {
-	Name        string   `yaml:"name"`
-	Chart       string   `yaml:"chart"`
-	Namespace   string   `yaml:"namespace"`
-	ReleaseName string   `yaml:"releaseName"`
+	Name        string   `yaml:"name" schema:"required"`
+	Chart       string   `yaml:"chart" schema:"required"`
+	Version     string   `yaml:"version"`
+	Namespace   string   `yaml:"namespace" schema:"required"`
+	ReleaseName string   `yaml:"releaseName" schema:"required"`
 	Envs        []string `yaml:"envs"`
+	DependsOn   []string `yaml:"dependsOn"`
+}
+
+// VendorScriptConfig is a vendor deployed by its deploy.<env>.sh script. The plain name form
+// of deployer/v1 is converted by convertV1.
+type VendorScriptConfig struct {
+	Name      string   `yaml:"name" schema:"required"`
+	DependsOn []string `yaml:"dependsOn"`
+}
+
+// VendorManifestsConfig is a vendor applied from plain Kubernetes manifests with server-side
+// apply, then waited for.
+type VendorManifestsConfig struct {
+	Name string `yaml:"name" schema:"required"`
+	// Namespace is given to the namespaced objects without one
+	Namespace string `yaml:"namespace"`
+	// Sources are URLs, or files and directories relative to the vendor directory
+	Sources   []string           `yaml:"sources" schema:"required"`
+	Wait      []VendorWaitConfig `yaml:"wait"`
+	DependsOn []string           `yaml:"dependsOn"`
+}
+
+// VendorWaitConfig waits for objects to report a status condition, e.g. the Ready pods of a
+// controller, selected by name or by label selector.
+type VendorWaitConfig struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind" schema:"required"`
+	Namespace  string `yaml:"namespace"`
+	Name       string `yaml:"name"`
+	Selector   string `yaml:"selector"`
+	Condition  string `yaml:"condition" schema:"required"`
+	// Timeout is a duration, e.g. 90s
+	Timeout string `yaml:"timeout"`
 }
 
 type VendorConfig struct {
-	Scripts []string            `yaml:"scripts"`
-	Charts  []VendorChartConfig `yaml:"charts"`
+	Scripts   []VendorScriptConfig    `yaml:"scripts"`
+	Manifests []VendorManifestsConfig `yaml:"manifests"`
+	Charts    []VendorChartConfig     `yaml:"charts"`
 }
 
+// InfraConfig is the typed view of infrastructure/infra.yaml.
 type InfraConfig struct {
-	Vendors VendorConfig `yaml:"vendors"`
+	// APIVersion is the version of the infra.yaml format, see infraConfigFormat
+	APIVersion   string             `yaml:"apiVersion"`
+	Repositories []RepositoryConfig `yaml:"repositories"`
+	Vendors      VendorConfig       `yaml:"vendors"`
+	Mirror       MirrorConfig       `yaml:"mirror"`
+}
+
+// Validate checks that the repository names are unique.
+func (c InfraConfig) Validate() error {
+	repositories := map[string]bool{}
+	for _, repository := range c.Repositories {
+		if repositories[repository.Name] {
+			return errors.Errorf("repository %s is declared more than once", repository.Name)
+		}
+		repositories[repository.Name] = true
+	}
+	return nil
 }
 
+var (
+	vendorsParallelism int
+	vendorsUpdate      bool
+)
+
 func init() {
 	vendorsDeployCmd.Flags().StringVarP(
 		&infrastructureDir,
@@ -39,6 +96,9 @@ func init() {
 		"the path of the infrastructure directory",
 	)
 	vendorsDeployCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment to deploy to")
+	vendorsDeployCmd.Flags().IntVar(&vendorsParallelism, "parallelism", 2, "the maximum number of vendors deployed concurrently")
+	vendorsDeployCmd.Flags().BoolVar(&vendorsUpdate, "update", false, "resolve the chart versions again and update infra.lock.yaml")
+	addVendorSelectorFlags(vendorsDeployCmd)
 
 	vendorsDeployCmd.MarkFlagRequired("target_environment")
 	vendorsDeployCmd.MarkFlagRequired("infrastructure_directory")
@@ -56,7 +116,7 @@ var vendorsDeployCmd = &cobra.Command{
 	Use:              "deploy",
 	TraverseChildren: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := runVendorsDeploy(infrastructureDir, targetEnvironment); err != nil {
+		if err := runVendorsDeploy(infrastructureDir, targetEnvironment, vendorsParallelism, vendorsUpdate, vendorSelector); err != nil {
 			log.Fatalf("Error running vendors deploy: %v", err)
 			os.Exit(1)
 		}
@@ -66,134 +126,224 @@ var vendorsDeployCmd = &cobra.Command{
 func runVendorsDeploy(
 	infrastructureDir string,
 	targetEnvironment string,
+	parallelism int,
+	update bool,
+	selector VendorSelector,
 ) error {
 	log.Printf("Running vendors deploy for environment %s\n", targetEnvironment)
 	if err := CheckTargetEnvironment(targetEnvironment); err != nil {
 		return err
 	}
-	if err := CheckIfPathExists(infrastructureDir); err != nil {
-		return errors.Wrapf(err, "Infrastructure directory %s does not exist", infrastructureDir)
-	}
-	infraConfigFile := filepath.Join(infrastructureDir, "infra.yaml")
-	if err := CheckIfPathExists(infraConfigFile); err != nil {
-		return errors.Wrapf(err, "Infrastructure file %s does not exist", infraConfigFile)
-	}
-	infraConfigData, err := os.ReadFile(infraConfigFile)
+	infraConfig, graph, err := LoadVendorGraph(infrastructureDir, selector)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to read file %s", infraConfigFile)
+		return err
 	}
-	infraConfig, err := ParseInfraConfigFromYaml(infraConfigData)
-	if err != nil {
-		return errors.Wrapf(err, "Failed to parse vendors config from file %s", infraConfigFile)
+	if len(graph.Order) == 0 {
+		log.Printf("No vendor selected\n")
+		return nil
 	}
-	vendorsDir := filepath.Join(infrastructureDir, "vendors")
-	for _, vendor := range infraConfig.Vendors.Scripts {
-		log.Printf("Deploying vendor %s\n", vendor)
-		vendorDir := filepath.Join(vendorsDir, strings.ToLower(vendor))
-		log.Printf("Vendor directory %s\n", vendorDir)
-		if err := CheckIfPathExists(vendorDir); err != nil {
-			return errors.Wrapf(err, "Vendor directory %s does not exist", vendorDir)
+	for _, key := range graph.Order {
+		if node := graph.Nodes[key]; len(node.Assumed) > 0 {
+			log.Printf("Vendor %s depends on %s, not selected, assuming it is in place\n", node.Name, strings.Join(node.Assumed, ", "))
 		}
-		deployScript := filepath.Join(vendorDir, fmt.Sprintf("deploy.%s.sh", targetEnvironment))
-		if err := CheckIfPathExists(deployScript); err != nil {
-			return errors.Wrapf(err, "Deploy script %s does not exist", deployScript)
+	}
+
+	// NOTE: Repository charts are only installed at the version of infra.lock.yaml
+	locks := map[string]LockedChart{}
+	if len(graph.Charts()) > 0 {
+		if err := EnsureHelmRepositories(infraConfig, true); err != nil {
+			return err
 		}
-		deployCmd := exec.Command(deployScript)
-		_, err := ExecuteCommand(deployCmd)
+		locks, err = LockVendorCharts(infrastructureDir, infraConfig, graph.Charts(), update)
 		if err != nil {
-			log.Fatalf("Error running deploy script %s: %v", deployScript, err)
-			continue
+			return err
 		}
-		log.Printf("Deployed script %s \n", deployScript)
 	}
 
-	for _, vendor := range infraConfig.Vendors.Charts {
-		log.Printf("Deploying vendor %s\n", vendor.Name)
-
-		vendorDir := filepath.Join(vendorsDir, strings.ToLower(vendor.Name))
-		log.Printf("Vendor directory %s\n", vendorDir)
-		if err := CheckIfPathExists(vendorDir); err != nil {
-			return errors.Wrapf(err, "Vendor directory %s does not exist", vendorDir)
+	vendorsDir := filepath.Join(infrastructureDir, "vendors")
+	results := RunVendorGraph(graph, parallelism, func(node *VendorNode) error {
+		out := newPrefixWriter(vendorKey(node.Name), os.Stdout)
+		defer out.Flush()
+		logger := log.New(out, "", log.Flags())
+		if node.Chart != nil {
+			vendor := *node.Chart
+			locked, isLocked := locks[vendorKey(vendor.Name)]
+			if isLocked {
+				logger.Printf("Vendor %s is locked to chart %s version %s (%s)\n", vendor.Name, locked.Chart, locked.Version, locked.Digest)
+				vendor.Version = locked.Version
+			}
+			// NOTE: The chart is installed from the pulled archive, once it matches the digest of the lock
+			pulled, digest, cleanup, err := PullVendorChartTemp(infraConfig, vendor)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			if isLocked && digest != locked.Digest {
+				return errors.Errorf(
+					"Chart %s %s of vendor %s does not match %s: expected %s, got %s",
+					locked.Chart,
+					locked.Version,
+					vendor.Name,
+					vendorLockFile,
+					locked.Digest,
+					digest,
+				)
+			}
+			return deployVendorChart(vendorsDir, pulled, targetEnvironment, infraConfig.Mirror, logger, out)
 		}
-
-		helmValuesFile := filepath.Join(
-			vendorDir,
-			fmt.Sprintf("values.%s.yaml", targetEnvironment),
-		)
-		if err := CheckIfPathExists(helmValuesFile); err != nil {
-			return errors.Wrapf(err, "Helm values file %s does not exist", helmValuesFile)
+		if node.Manifests != nil {
+			return deployVendorManifests(vendorsDir, *node.Manifests, logger)
 		}
+		return deployVendorScript(vendorsDir, *node.Script, targetEnvironment, logger, out)
+	})
+	PrintVendorSummary(results)
 
-		valuesTmpFile := filepath.Join("/dev/shm", fmt.Sprintf("%s.yaml", uuid.New()))
-		bytesRead, err := os.ReadFile(helmValuesFile)
-		if err != nil {
-			return errors.Wrapf(err, "Error reading file %s", helmValuesFile)
-		}
-		// NOTE: Only the original file owner can read/write the file
-		if err := os.WriteFile(valuesTmpFile, bytesRead, 0600); err != nil {
-			return errors.Wrapf(err, "Error writing file %s", valuesTmpFile)
-		}
-		if err := ChownFileToCurrentUser(valuesTmpFile); err != nil {
-			return errors.Wrapf(err, "Error changing ownership of file %s", valuesTmpFile)
+	var failed []string
+	for _, result := range results {
+		if result.Status != vendorStatusDeployed {
+			failed = append(failed, result.Node.Name)
 		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("Vendors not deployed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
 
-		var envErrors []string
-		for _, envVar := range vendor.Envs {
-			envValue, ok := os.LookupEnv(envVar)
-			if envVar == "" || !ok {
-				envErrors = append(envErrors, fmt.Sprintf("Environment variable %s not set", envVar))
-				continue
-			}
-			log.Printf(
+// runVendorCommand runs the command with its output written to out.
+func runVendorCommand(cmd *exec.Cmd, logger *log.Logger, out *prefixWriter) error {
+	logger.Printf("Running command: %s\n", strings.Join(cmd.Args, " "))
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+func deployVendorScript(
+	vendorsDir string,
+	vendor VendorScriptConfig,
+	targetEnvironment string,
+	logger *log.Logger,
+	out *prefixWriter,
+) error {
+	logger.Printf("Deploying vendor %s\n", vendor.Name)
+	vendorDir := filepath.Join(vendorsDir, strings.ToLower(vendor.Name))
+	logger.Printf("Vendor directory %s\n", vendorDir)
+	if err := CheckIfPathExists(vendorDir); err != nil {
+		return errors.Wrapf(err, "Vendor directory %s does not exist", vendorDir)
+	}
+	deployScript := filepath.Join(vendorDir, fmt.Sprintf("deploy.%s.sh", targetEnvironment))
+	if err := CheckIfPathExists(deployScript); err != nil {
+		return errors.Wrapf(err, "Deploy script %s does not exist", deployScript)
+	}
+	if err := runVendorCommand(exec.Command(deployScript), logger, out); err != nil {
+		return errors.Wrapf(err, "Error running deploy script %s", deployScript)
+	}
+	logger.Printf("Deployed script %s \n", deployScript)
+	return nil
+}
+
+func deployVendorChart(
+	vendorsDir string,
+	vendor VendorChartConfig,
+	targetEnvironment string,
+	mirror MirrorConfig,
+	logger *log.Logger,
+	out *prefixWriter,
+) error {
+	logger.Printf("Deploying vendor %s\n", vendor.Name)
+
+	vendorDir := filepath.Join(vendorsDir, strings.ToLower(vendor.Name))
+	logger.Printf("Vendor directory %s\n", vendorDir)
+	if err := CheckIfPathExists(vendorDir); err != nil {
+		return errors.Wrapf(err, "Vendor directory %s does not exist", vendorDir)
+	}
+
+	for _, envVar := range vendor.Envs {
+		if envValue, ok := os.LookupEnv(envVar); ok {
+			logger.Printf(
 				"Setting environment variable %s=%s\n",
 				envVar,
 				MaskSensitiveData(envValue, targetEnvironment),
 			)
-			cmd := exec.Command(
-				"sed",
-				"-i",
-				fmt.Sprintf("s/<%s>/%s/g", envVar, envValue),
-				valuesTmpFile,
-			)
-			_, err := cmd.CombinedOutput()
-			if err != nil {
-				envErrors = append(
-					envErrors,
-					fmt.Sprintf("Error setting environment variable %s: %s", envVar, err.Error()),
-				)
-				continue
-			}
-			log.Printf("Environment variable %s set\n", envVar)
 		}
+	}
+	values, err := RenderVendorValues(vendorDir, vendor, targetEnvironment)
+	if err != nil {
+		return err
+	}
+	valuesTmpFile := filepath.Join("/dev/shm", fmt.Sprintf("%s.yaml", uuid.New()))
+	// NOTE: Only the original file owner can read/write the file
+	if err := os.WriteFile(valuesTmpFile, values, 0600); err != nil {
+		return errors.Wrapf(err, "Error writing file %s", valuesTmpFile)
+	}
+	defer os.Remove(valuesTmpFile)
+	if err := ChownFileToCurrentUser(valuesTmpFile); err != nil {
+		return errors.Wrapf(err, "Error changing ownership of file %s", valuesTmpFile)
+	}
 
-		if len(envErrors) > 0 {
-			return errors.Errorf(
-				"Error setting environment variables: %s",
-				strings.Join(envErrors, "\n"),
-			)
-		}
+	logger.Printf("Deploying vendor %s to namespace %s\n", vendor.Name, vendor.Namespace)
+	CreateOwnedNamespace(vendor.Namespace, vendorKey(vendor.Name), logger)
+	helmCmd := HelmCommand(
+		"upgrade",
+		"--install",
+		vendor.ReleaseName,
+		vendor.Chart,
+		"--values",
+		valuesTmpFile,
+		"--namespace",
+		vendor.Namespace,
+		"--create-namespace",
+		"--wait",
+	)
+	if vendor.Version != "" {
+		helmCmd.Args = append(helmCmd.Args, "--version", vendor.Version)
+	}
+	postRenderer, err := MirrorPostRenderer(vendorDir)
+	if err != nil {
+		return err
+	}
+	if postRenderer != "" {
+		defer os.Remove(postRenderer)
+		logger.Printf("Rewriting the images of vendor %s to the mirror %s\n", vendor.Name, mirror.Registry)
+		helmCmd.Args = append(helmCmd.Args, "--post-renderer", postRenderer)
+	}
+	if err := runVendorCommand(helmCmd, logger, out); err != nil {
+		return errors.Wrapf(err, "Error deploying chart %s", vendor.Chart)
+	}
+	logger.Printf("Vendor %s deployed\n", vendor.Name)
+	return nil
+}
 
-		log.Printf("Deploying vendor %s to namespace %s\n", vendor.Name, namespace)
-		helmCmd := exec.Command(
-			"helm",
-			"upgrade",
-			"--install",
-			vendor.ReleaseName,
-			vendor.Chart,
-			"--values",
-			valuesTmpFile,
-			"--namespace",
-			vendor.Namespace,
-			"--create-namespace",
-			"--wait",
-		)
-		_, err = ExecuteCommand(helmCmd)
-		if err != nil {
-			log.Fatalf("Error running deploy script %s: %v", helmCmd, err)
+// RenderVendorValues returns the values.<env>.yaml file of the vendor with the <ENV_VAR>
+// placeholders replaced by the environment variables listed in its envs.
+func RenderVendorValues(vendorDir string, vendor VendorChartConfig, targetEnvironment string) ([]byte, error) {
+	helmValuesFile := filepath.Join(
+		vendorDir,
+		fmt.Sprintf("values.%s.yaml", targetEnvironment),
+	)
+	if err := CheckIfPathExists(helmValuesFile); err != nil {
+		return nil, errors.Wrapf(err, "Helm values file %s does not exist", helmValuesFile)
+	}
+	bytesRead, err := os.ReadFile(helmValuesFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading file %s", helmValuesFile)
+	}
+
+	values := string(bytesRead)
+	var envErrors []string
+	for _, envVar := range vendor.Envs {
+		envValue, ok := os.LookupEnv(envVar)
+		if envVar == "" || !ok {
+			envErrors = append(envErrors, fmt.Sprintf("Environment variable %s not set", envVar))
 			continue
 		}
-		log.Printf("Vendor %s deployed\n", vendor.Name)
-		os.Remove(valuesTmpFile)
+		values = strings.ReplaceAll(values, fmt.Sprintf("<%s>", envVar), envValue)
 	}
-	return nil
+	if len(envErrors) > 0 {
+		return nil, errors.Errorf(
+			"Error setting environment variables: %s",
+			strings.Join(envErrors, "\n"),
+		)
+	}
+	return []byte(values), nil
 }
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// HelmRelease is a release as listed by helm list -o json
+type HelmRelease struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Revision   string `json:"revision"`
+	Status     string `json:"status"`
+	Chart      string `json:"chart"`
+	AppVersion string `json:"app_version"`
+}
+
+// VendorStatus is the state of a vendor chart in the cluster
+type VendorStatus struct {
+	Vendor  VendorChartConfig
+	Release *HelmRelease
+	// PodsReady and Pods count the running pods of the namespace
+	PodsReady int
+	Pods      int
+	PodsErr   error
+	// ValuesDrift lists the keys whose installed value differs from values.<env>.yaml
+	ValuesDrift []string
+	ValuesErr   error
+}
+
+func init() {
+	vendorsListCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsListCmd.MarkFlagRequired("infrastructure_directory")
+
+	vendorsStatusCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsStatusCmd.MarkFlagRequired("infrastructure_directory")
+	vendorsStatusCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment whose values are compared to the installed ones")
+	vendorsStatusCmd.MarkFlagRequired("target_environment")
+	addVendorSelectorFlags(vendorsStatusCmd)
+
+	vendorsCmd.AddCommand(vendorsListCmd)
+	vendorsCmd.AddCommand(vendorsStatusCmd)
+}
+
+var vendorsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the vendor scripts and charts configured in infra.yaml",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsList(infrastructureDir); err != nil {
+			log.Fatalf("Error running vendors list: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var vendorsStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the release, pods and values drift of every vendor chart",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsStatus(infrastructureDir, targetEnvironment, vendorSelector); err != nil {
+			log.Fatalf("Error running vendors status: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ChartVersion returns the version of the chart installed for the vendor: its locked version or
+// constraint, the version of a local chart directory, or latest.
+func ChartVersion(vendor VendorChartConfig) string {
+	if vendor.Version != "" {
+		return vendor.Version
+	}
+	chartFile := filepath.Join(vendor.Chart, "Chart.yaml")
+	if CheckIfPathExists(chartFile) == nil {
+		fields, err := GetFieldsFromYamlFile(chartFile, []string{"version"})
+		if err == nil && fields["version"] != nil {
+			return fmt.Sprint(fields["version"])
+		}
+	}
+	return "latest"
+}
+
+func runVendorsList(infrastructureDir string) error {
+	infraConfig, err := LoadInfraConfig(infrastructureDir)
+	if err != nil {
+		return err
+	}
+	infraConfig.Vendors.Charts, err = LockedVendorVersions(infrastructureDir, infraConfig.Vendors.Charts)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tCHART\tVERSION\tNAMESPACE\tRELEASE\tDEPENDS ON")
+	dependsOn := func(names []string) string {
+		if len(names) == 0 {
+			return "-"
+		}
+		return strings.Join(names, ",")
+	}
+	for _, script := range infraConfig.Vendors.Scripts {
+		fmt.Fprintf(w, "script\t%s\t-\t-\t-\t-\t%s\n", script.Name, dependsOn(script.DependsOn))
+	}
+	for _, vendor := range infraConfig.Vendors.Manifests {
+		fmt.Fprintf(w, "manifests\t%s\t%s\t-\t%s\t-\t%s\n", vendor.Name, strings.Join(vendor.Sources, ","), vendor.Namespace, dependsOn(vendor.DependsOn))
+	}
+	for _, vendor := range infraConfig.Vendors.Charts {
+		fmt.Fprintf(w, "chart\t%s\t%s\t%s\t%s\t%s\t%s\n", vendor.Name, vendor.Chart, ChartVersion(vendor), vendor.Namespace, vendor.ReleaseName, dependsOn(vendor.DependsOn))
+	}
+	return w.Flush()
+}
+
+// GetHelmRelease returns the release of the namespace, nil when it is not installed.
+func GetHelmRelease(releaseName string, namespace string) (*HelmRelease, error) {
+	cmd := HelmCommand("list", "--namespace", namespace, "--filter", fmt.Sprintf("^%s$", releaseName), "--all", "--output", "json")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error listing helm release %s: %s", releaseName, strings.TrimSpace(stderr.String()))
+	}
+	var releases []HelmRelease
+	if err := json.Unmarshal(out, &releases); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing helm release %s", releaseName)
+	}
+	for _, release := range releases {
+		if release.Name == releaseName {
+			return &release, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetHelmValues returns the values supplied to the installed release.
+func GetHelmValues(releaseName string, namespace string) (interface{}, error) {
+	cmd := HelmCommand("get", "values", releaseName, "--namespace", namespace, "--output", "yaml")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error getting the values of helm release %s: %s", releaseName, strings.TrimSpace(stderr.String()))
+	}
+	var values interface{}
+	if err := yaml.Unmarshal(out, &values); err != nil {
+		return nil, errors.Wrapf(err, "Error parsing the values of helm release %s", releaseName)
+	}
+	return values, nil
+}
+
+// DiffValues returns the dotted paths of the keys whose value differs between the two values
+// trees. Scalars are compared by their string form, as helm round trips values through JSON.
+func DiffValues(path string, installed interface{}, desired interface{}) []string {
+	keyPath := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	installedMap, installedIsMap := installed.(map[interface{}]interface{})
+	desiredMap, desiredIsMap := desired.(map[interface{}]interface{})
+	if installedIsMap && desiredIsMap {
+		keys := map[string]interface{}{}
+		for key := range installedMap {
+			keys[fmt.Sprint(key)] = key
+		}
+		for key := range desiredMap {
+			keys[fmt.Sprint(key)] = key
+		}
+		var diff []string
+		for name, key := range keys {
+			diff = append(diff, DiffValues(keyPath(name), installedMap[key], desiredMap[key])...)
+		}
+		sort.Strings(diff)
+		return diff
+	}
+
+	installedList, installedIsList := installed.([]interface{})
+	desiredList, desiredIsList := desired.([]interface{})
+	if installedIsList && desiredIsList && len(installedList) == len(desiredList) {
+		var diff []string
+		for i := range installedList {
+			diff = append(diff, DiffValues(fmt.Sprintf("%s[%d]", path, i), installedList[i], desiredList[i])...)
+		}
+		return diff
+	}
+	if fmt.Sprint(installed) != fmt.Sprint(desired) {
+		return []string{path}
+	}
+	return nil
+}
+
+// countReadyPods returns the number of ready pods and of pods expected to run in the namespace.
+func countReadyPods(client kubernetes.Interface, namespace string) (int, int, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "Error listing the pods of namespace %s", namespace)
+	}
+	ready, total := 0, 0
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		total++
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	return ready, total, nil
+}
+
+// GetVendorStatus collects the release, pods and values drift of the vendor chart.
+func GetVendorStatus(client kubernetes.Interface, vendorsDir string, vendor VendorChartConfig, environment string) (VendorStatus, error) {
+	status := VendorStatus{Vendor: vendor}
+	release, err := GetHelmRelease(vendor.ReleaseName, vendor.Namespace)
+	if err != nil {
+		return status, err
+	}
+	status.Release = release
+
+	if client == nil {
+		status.PodsErr = errors.New("no cluster connection")
+	} else {
+		status.PodsReady, status.Pods, status.PodsErr = countReadyPods(client, vendor.Namespace)
+	}
+
+	if release == nil {
+		return status, nil
+	}
+	rendered, err := RenderVendorValues(filepath.Join(vendorsDir, strings.ToLower(vendor.Name)), vendor, environment)
+	if err != nil {
+		status.ValuesErr = err
+		return status, nil
+	}
+	var desired interface{}
+	if err := yaml.Unmarshal(rendered, &desired); err != nil {
+		status.ValuesErr = errors.Wrapf(err, "Error parsing the values of %s", vendor.Name)
+		return status, nil
+	}
+	installed, err := GetHelmValues(vendor.ReleaseName, vendor.Namespace)
+	if err != nil {
+		status.ValuesErr = err
+		return status, nil
+	}
+	if installed == nil {
+		installed = map[interface{}]interface{}{}
+	}
+	if desired == nil {
+		desired = map[interface{}]interface{}{}
+	}
+	status.ValuesDrift = DiffValues("", installed, desired)
+	return status, nil
+}
+
+// PrintVendorStatus prints a table of the vendors followed by the details of drift and errors.
+func PrintVendorStatus(statuses []VendorStatus, environment string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VENDOR\tRELEASE\tNAMESPACE\tSTATUS\tCHART\tAPP VERSION\tREVISION\tPODS\tVALUES")
+	var details []string
+	for _, status := range statuses {
+		release := HelmRelease{Status: "not installed", Chart: "-", AppVersion: "-", Revision: "-"}
+		if status.Release != nil {
+			release = *status.Release
+		}
+		pods := fmt.Sprintf("%d/%d", status.PodsReady, status.Pods)
+		if status.PodsErr != nil {
+			pods = "unknown"
+			details = append(details, fmt.Sprintf("%s pods: %v", status.Vendor.Name, status.PodsErr))
+		}
+		values := "-"
+		switch {
+		case status.Release == nil:
+		case status.ValuesErr != nil:
+			values = "unknown"
+			details = append(details, fmt.Sprintf("%s values: %v", status.Vendor.Name, status.ValuesErr))
+		case len(status.ValuesDrift) > 0:
+			values = "drifted"
+			details = append(details, fmt.Sprintf("%s values differ from values.%s.yaml: %s", status.Vendor.Name, environment, strings.Join(status.ValuesDrift, ", ")))
+		default:
+			values = "in sync"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			status.Vendor.Name, status.Vendor.ReleaseName, status.Vendor.Namespace, release.Status,
+			release.Chart, release.AppVersion, release.Revision, pods, values)
+	}
+	w.Flush()
+	if len(details) > 0 {
+		fmt.Println()
+		for _, detail := range details {
+			fmt.Println(detail)
+		}
+	}
+}
+
+func runVendorsStatus(infrastructureDir string, environment string, selector VendorSelector) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	_, graph, err := LoadVendorGraph(infrastructureDir, selector)
+	if err != nil {
+		return err
+	}
+	client, err := NewKubeClient()
+	if err != nil {
+		log.Printf("Warning: pod readiness is not available: %v\n", err)
+	}
+	vendorsDir := filepath.Join(infrastructureDir, "vendors")
+	var statuses []VendorStatus
+	for _, chart := range graph.Charts() {
+		status, err := GetVendorStatus(client, vendorsDir, chart, environment)
+		if err != nil {
+			return err
+		}
+		statuses = append(statuses, status)
+	}
+	PrintVendorStatus(statuses, environment)
+	return nil
+}
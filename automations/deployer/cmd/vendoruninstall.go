@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+func init() {
+	vendorsUninstallCmd.Flags().StringVarP(&infrastructureDir, "infrastructure_directory", "i", "", "the path of the infrastructure directory")
+	vendorsUninstallCmd.MarkFlagRequired("infrastructure_directory")
+	vendorsUninstallCmd.Flags().StringVarP(&targetEnvironment, "target_environment", "e", "", "the environment to uninstall the vendors from")
+	vendorsUninstallCmd.MarkFlagRequired("target_environment")
+	vendorsUninstallCmd.Flags().BoolVar(&deleteData, "delete-data", false, "also delete the persistent volume claims of the vendor charts")
+	vendorsUninstallCmd.Flags().BoolVar(&confirmed, "yes", false, "do not ask for a confirmation in protected environments")
+	addVendorSelectorFlags(vendorsUninstallCmd)
+
+	vendorsCmd.AddCommand(vendorsUninstallCmd)
+}
+
+var vendorsUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Uninstall the vendor charts and delete the resources of the manifests vendors",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVendorsUninstall(infrastructureDir, targetEnvironment, deleteData, confirmed, vendorSelector); err != nil {
+			log.Fatalf("Error running vendors uninstall: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func uninstallVendorManifests(vendor VendorManifestsConfig, logger *log.Logger) error {
+	applier, err := NewManifestsApplier(vendor)
+	if err != nil {
+		return err
+	}
+	deleted, err := applier.Uninstall(context.Background())
+	for _, ref := range deleted {
+		logger.Printf("Deleted %s\n", ref)
+	}
+	return errors.Wrapf(err, "Error deleting the manifests of %s", vendor.Name)
+}
+
+// uninstallVendor tears down the vendor. Scripts have no uninstall, they are left in place.
+func uninstallVendor(client kubernetes.Interface, node *VendorNode, deleteData bool, logger *log.Logger) error {
+	switch {
+	case node.Chart != nil:
+		vendor := *node.Chart
+		return UninstallHelmRelease(client, vendor.ReleaseName, vendor.Namespace, vendorKey(vendor.Name), deleteData, logger)
+	case node.Manifests != nil:
+		return uninstallVendorManifests(*node.Manifests, logger)
+	default:
+		logger.Printf("Warning: vendor script %s cannot be uninstalled, remove it by hand\n", node.Name)
+		return nil
+	}
+}
+
+func runVendorsUninstall(
+	infrastructureDir string,
+	environment string,
+	deleteData bool,
+	yes bool,
+	selector VendorSelector,
+) error {
+	if err := CheckTargetEnvironment(environment); err != nil {
+		return err
+	}
+	infraConfig, graph, err := LoadVendorGraph(infrastructureDir, selector)
+	if err != nil {
+		return err
+	}
+	if len(graph.Order) == 0 {
+		log.Printf("No vendor selected\n")
+		return nil
+	}
+	var names []string
+	for _, key := range graph.Order {
+		names = append(names, graph.Nodes[key].Name)
+	}
+	action := "Uninstalling vendors " + strings.Join(names, ", ")
+	if deleteData {
+		action += " and deleting their data"
+	}
+	if err := ConfirmProtectedEnvironment(environment, action, yes, os.Stdin); err != nil {
+		return err
+	}
+
+	// Vendors left installed may need the ones uninstalled
+	full, err := BuildVendorGraph(infraConfig.Vendors)
+	if err != nil {
+		return err
+	}
+	for key, dependents := range full.Dependents() {
+		if _, selected := graph.Nodes[key]; !selected {
+			continue
+		}
+		for _, dependent := range dependents {
+			if _, selected := graph.Nodes[dependent]; !selected {
+				log.Printf("Warning: vendor %s depends on %s, which is uninstalled\n", full.Nodes[dependent].Name, graph.Nodes[key].Name)
+			}
+		}
+	}
+
+	client, err := NewKubeClient()
+	if err != nil {
+		return err
+	}
+	// The dependents are uninstalled before the vendors they depend on
+	var failed []string
+	for i := len(graph.Order) - 1; i >= 0; i-- {
+		node := graph.Nodes[graph.Order[i]]
+		out := newPrefixWriter(vendorKey(node.Name), os.Stdout)
+		logger := log.New(out, "", log.Flags())
+		logger.Printf("Uninstalling vendor %s\n", node.Name)
+		if err := uninstallVendor(client, node, deleteData, logger); err != nil {
+			logger.Printf("Error uninstalling vendor %s: %v\n", node.Name, err)
+			failed = append(failed, node.Name)
+		}
+		out.Flush()
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("Vendors not uninstalled: %s", strings.Join(failed, ", "))
+	}
+	log.Printf("Vendors uninstalled from %s\n", environment)
+	return nil
+}
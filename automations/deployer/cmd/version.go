@@ -6,13 +6,16 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Version is the Deployer version, recorded in the provenance of releases
+const Version = "1.0.0"
+
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show Deployer version",
 	Long:  `Show Deployer version.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Println("Deployer version: 1.0.0")
+		log.Printf("Deployer version: %s\n", Version)
 	},
 }
 
@@ -0,0 +1,126 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+)
+
+// DockerHubServer is the key docker uses for Docker Hub in config.json and credential helpers.
+const DockerHubServer = "https://index.docker.io/v1/"
+
+// AuthConfig is a single entry of the "auths" section of the docker config file.
+type AuthConfig struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// DockerConfig holds the parts of ~/.docker/config.json used to resolve credentials.
+type DockerConfig struct {
+	Auths       map[string]AuthConfig `json:"auths"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+}
+
+// DockerConfigFile returns the path of the docker config file, honouring DOCKER_CONFIG.
+func DockerConfigFile() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get home directory")
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// LoadDockerConfig reads the docker config file. A missing file yields an empty config.
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	config := &DockerConfig{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read docker config %s", path)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse docker config %s", path)
+	}
+	return config, nil
+}
+
+// NormalizeRegistry reduces a registry reference to its host, mapping the
+// Docker Hub aliases to a single name.
+func NormalizeRegistry(server string) string {
+	host := server
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "index.docker.io"
+	}
+	return host
+}
+
+// serverKey returns the key docker uses for the registry in config.json and credential helpers.
+func serverKey(server string) string {
+	if NormalizeRegistry(server) == "index.docker.io" {
+		return DockerHubServer
+	}
+	return NormalizeRegistry(server)
+}
+
+// authFor returns the "auths" entry for the registry, matching keys by normalized host.
+func (c *DockerConfig) authFor(server string) (AuthConfig, bool) {
+	host := NormalizeRegistry(server)
+	for key, auth := range c.Auths {
+		if NormalizeRegistry(key) == host {
+			return auth, true
+		}
+	}
+	return AuthConfig{}, false
+}
+
+// helperFor returns the credential helper configured for the registry, if any.
+func (c *DockerConfig) helperFor(server string) string {
+	host := NormalizeRegistry(server)
+	for key, helper := range c.CredHelpers {
+		if NormalizeRegistry(key) == host {
+			return helper
+		}
+	}
+	return c.CredsStore
+}
+
+// decodeAuth decodes an "auths" entry into credentials. Entries without
+// any credential data (as written by docker when a credsStore is used) are ignored.
+func decodeAuth(auth AuthConfig) (Credentials, bool, error) {
+	if auth.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return Credentials{}, false, errors.Wrap(err, "failed to decode auth field")
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return Credentials{}, false, errors.New("invalid auth field, expected username:password")
+		}
+		return Credentials{Username: parts[0], Secret: parts[1]}, true, nil
+	}
+	if auth.IdentityToken != "" {
+		return Credentials{Username: auth.Username, Secret: auth.IdentityToken}, true, nil
+	}
+	if auth.Username != "" && auth.Password != "" {
+		return Credentials{Username: auth.Username, Secret: auth.Password}, true, nil
+	}
+	return Credentials{}, false, nil
+}
@@ -0,0 +1,54 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// helperPrefix is the prefix of the credential helper binaries looked up in PATH.
+const helperPrefix = "docker-credential-"
+
+// ErrCredentialsNotFound is returned by a credential helper that has no entry for the registry.
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// helperResponse is the payload printed by "docker-credential-<name> get".
+type helperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// HelperGet asks the docker credential helper for the credentials of the
+// server, following the docker-credential-helpers protocol: the server URL is
+// written to stdin of "docker-credential-<helper> get" and a JSON document is read back.
+func HelperGet(helper string, server string) (Credentials, error) {
+	cmd := exec.Command(helperPrefix+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(message, ErrCredentialsNotFound.Error()) {
+			return Credentials{}, ErrCredentialsNotFound
+		}
+		return Credentials{}, errors.Wrapf(err, "credential helper %s%s failed: %s", helperPrefix, helper, message)
+	}
+	var resp helperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return Credentials{}, errors.Wrapf(err, "failed to parse output of credential helper %s%s", helperPrefix, helper)
+	}
+	if resp.Secret == "" {
+		return Credentials{}, ErrCredentialsNotFound
+	}
+	username := resp.Username
+	// Helpers store identity tokens with this placeholder username
+	if username == "<token>" {
+		username = ""
+	}
+	return Credentials{Username: username, Secret: resp.Secret}, nil
+}
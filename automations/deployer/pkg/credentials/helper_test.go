@@ -0,0 +1,111 @@
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeHelper answers "get" for registry.example.com and docker.io, reports a missing entry
+// for unknown.example.com and fails for any other server, like the docker-credential-helpers do.
+const fakeHelper = `#!/bin/sh
+[ "$1" = "get" ] || { echo "unknown action $1" >&2; exit 1; }
+read -r server
+case "$server" in
+registry.example.com)
+  echo '{"ServerURL":"registry.example.com","Username":"deployer","Secret":"s3cret"}' ;;
+https://index.docker.io/v1/)
+  echo '{"ServerURL":"https://index.docker.io/v1/","Username":"<token>","Secret":"identity-token"}' ;;
+unknown.example.com)
+  echo "credentials not found in native keychain"; exit 1 ;;
+*)
+  echo "keychain is locked" >&2; exit 1 ;;
+esac
+`
+
+// installFakeHelper puts docker-credential-fake first in PATH.
+func installFakeHelper(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, helperPrefix+"fake"), []byte(fakeHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestHelperGet(t *testing.T) {
+	installFakeHelper(t)
+
+	creds, err := HelperGet("fake", "registry.example.com")
+	if err != nil {
+		t.Fatalf("HelperGet: %v", err)
+	}
+	if creds.Username != "deployer" || creds.Secret != "s3cret" {
+		t.Errorf("got %+v, want deployer/s3cret", creds)
+	}
+
+	creds, err = HelperGet("fake", DockerHubServer)
+	if err != nil {
+		t.Fatalf("HelperGet: %v", err)
+	}
+	if creds.Username != "" || creds.Secret != "identity-token" {
+		t.Errorf("got %+v, want an identity token without username", creds)
+	}
+}
+
+func TestHelperGetNotFound(t *testing.T) {
+	installFakeHelper(t)
+
+	if _, err := HelperGet("fake", "unknown.example.com"); err != ErrCredentialsNotFound {
+		t.Errorf("got %v, want ErrCredentialsNotFound", err)
+	}
+}
+
+func TestHelperGetError(t *testing.T) {
+	installFakeHelper(t)
+
+	_, err := HelperGet("fake", "broken.example.com")
+	if err == nil || err == ErrCredentialsNotFound {
+		t.Fatalf("got %v, want the helper failure", err)
+	}
+	if !strings.Contains(err.Error(), "keychain is locked") {
+		t.Errorf("error %q does not contain the helper output", err)
+	}
+
+	if _, err := HelperGet("missing", "registry.example.com"); err == nil {
+		t.Error("expected an error for a helper that is not installed")
+	}
+}
+
+func TestResolveFromCredsStore(t *testing.T) {
+	installFakeHelper(t)
+	dir := t.TempDir()
+	config := `{"auths":{"registry.example.com":{}},"credsStore":"fake"}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	creds, err := Resolve(Options{Server: "registry.example.com"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if creds.Secret != "s3cret" || creds.Source != "credential helper docker-credential-fake" {
+		t.Errorf("got %+v, want the credentials of the helper", creds)
+	}
+
+	// A missing entry falls through to the environment
+	t.Setenv("TEST_REGISTRY_PASSWORD", "from-env")
+	creds, err = Resolve(Options{Server: "unknown.example.com", Username: "ci", PasswordEnv: "TEST_REGISTRY_PASSWORD"})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if creds.Secret != "from-env" {
+		t.Errorf("got %+v, want the environment password", creds)
+	}
+
+	if _, err := Resolve(Options{Server: "broken.example.com"}); err == nil {
+		t.Error("expected the helper error")
+	}
+}
@@ -0,0 +1,165 @@
+package credentials
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// Credentials are the username and secret (password or token) used to access a registry.
+type Credentials struct {
+	Username string
+	Secret   string
+	// Source describes where the credentials were found, for logging purposes
+	Source string
+}
+
+// Options controls how credentials are resolved.
+type Options struct {
+	// Server is the registry the credentials are for, e.g. docker.io
+	Server string
+	// Username is the username requested by the user, if any
+	Username string
+	// PasswordStdin reads the secret from Stdin instead of looking it up
+	PasswordStdin bool
+	// UsernameEnv and PasswordEnv are the environment variables checked after the docker config
+	UsernameEnv string
+	PasswordEnv string
+	// Stdin is used for --password-stdin and prompting, defaults to os.Stdin
+	Stdin *os.File
+	// Prompt is where prompts are written, defaults to os.Stderr
+	Prompt io.Writer
+}
+
+// Resolve finds registry credentials the way docker does: the "auths" section of
+// the docker config, then the configured credential helpers, then environment
+// variables and finally a prompt on the terminal. When stdin is not a terminal
+// it fails instead of prompting.
+func Resolve(opts Options) (Credentials, error) {
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.Prompt == nil {
+		opts.Prompt = os.Stderr
+	}
+	if opts.Username == "" && opts.UsernameEnv != "" {
+		opts.Username = os.Getenv(opts.UsernameEnv)
+	}
+
+	if opts.PasswordStdin {
+		return readPasswordStdin(opts)
+	}
+
+	creds, err := fromDockerConfig(opts.Server, opts.Username)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if creds.Secret != "" {
+		return creds, nil
+	}
+
+	if opts.PasswordEnv != "" {
+		if secret := os.Getenv(opts.PasswordEnv); secret != "" && opts.Username != "" {
+			return Credentials{
+				Username: opts.Username,
+				Secret:   secret,
+				Source:   fmt.Sprintf("environment variable %s", opts.PasswordEnv),
+			}, nil
+		}
+	}
+
+	if !term.IsTerminal(int(opts.Stdin.Fd())) {
+		return Credentials{}, errors.Errorf(
+			"no credentials found for %s and stdin is not a terminal, use --password-stdin or run docker login",
+			opts.Server,
+		)
+	}
+	return prompt(opts)
+}
+
+// fromDockerConfig looks up the registry in the docker config file and its credential helpers.
+// Credentials for a different user than the requested one are ignored.
+func fromDockerConfig(server string, username string) (Credentials, error) {
+	configFile, err := DockerConfigFile()
+	if err != nil {
+		return Credentials{}, err
+	}
+	config, err := LoadDockerConfig(configFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	if auth, ok := config.authFor(server); ok {
+		creds, found, err := decodeAuth(auth)
+		if err != nil {
+			return Credentials{}, errors.Wrapf(err, "invalid auths entry for %s in %s", server, configFile)
+		}
+		if found && (username == "" || creds.Username == "" || creds.Username == username) {
+			if creds.Username == "" {
+				creds.Username = username
+			}
+			creds.Source = configFile
+			return creds, nil
+		}
+	}
+
+	if helper := config.helperFor(server); helper != "" {
+		creds, err := HelperGet(helper, serverKey(server))
+		if err == ErrCredentialsNotFound {
+			return Credentials{}, nil
+		}
+		if err != nil {
+			return Credentials{}, err
+		}
+		if username == "" || creds.Username == "" || creds.Username == username {
+			if creds.Username == "" {
+				creds.Username = username
+			}
+			creds.Source = fmt.Sprintf("credential helper %s%s", helperPrefix, helper)
+			return creds, nil
+		}
+	}
+	return Credentials{}, nil
+}
+
+func readPasswordStdin(opts Options) (Credentials, error) {
+	if opts.Username == "" {
+		return Credentials{}, errors.New("--password-stdin requires a username")
+	}
+	data, err := io.ReadAll(opts.Stdin)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "failed to read password from stdin")
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return Credentials{}, errors.New("empty password read from stdin")
+	}
+	return Credentials{Username: opts.Username, Secret: secret, Source: "stdin"}, nil
+}
+
+func prompt(opts Options) (Credentials, error) {
+	username := opts.Username
+	if username == "" {
+		fmt.Fprintf(opts.Prompt, "Username for %s: ", opts.Server)
+		line, err := bufio.NewReader(opts.Stdin).ReadString('\n')
+		if err != nil {
+			return Credentials{}, errors.Wrap(err, "failed to read username")
+		}
+		username = strings.TrimSpace(line)
+	}
+	fmt.Fprintf(opts.Prompt, "Password/token for %s@%s: ", username, opts.Server)
+	secret, err := term.ReadPassword(int(opts.Stdin.Fd()))
+	fmt.Fprintln(opts.Prompt)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "failed to read password")
+	}
+	if username == "" || len(secret) == 0 {
+		return Credentials{}, errors.New("username and password are required")
+	}
+	return Credentials{Username: username, Secret: string(secret), Source: "prompt"}, nil
+}
@@ -0,0 +1,139 @@
+package dockerlint
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// Instruction is a Dockerfile instruction with its continuation lines joined.
+type Instruction struct {
+	// Command is the upper-cased instruction, e.g. FROM
+	Command string
+	// Args is the rest of the instruction
+	Args string
+	// Line is the line number the instruction starts at
+	Line int
+	// Suppressed lists the rule IDs disabled by a comment right above the instruction
+	Suppressed map[string]bool
+}
+
+// Dockerfile is a parsed Dockerfile.
+type Dockerfile struct {
+	Instructions []Instruction
+	// Suppressed lists the rule IDs disabled for the whole file
+	Suppressed map[string]bool
+}
+
+// suppressionPrefix starts a comment disabling rules, e.g.
+// "# deployer-lint ignore=DF002" for the next instruction or
+// "# deployer-lint ignore-file=DF004" for the whole file.
+const suppressionPrefix = "deployer-lint"
+
+// Parse splits the Dockerfile into instructions. Heredocs are not interpreted.
+func Parse(data []byte) Dockerfile {
+	dockerfile := Dockerfile{Suppressed: map[string]bool{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	pending := map[string]bool{}
+	var current *Instruction
+	var parts []string
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#") {
+			parseSuppression(strings.TrimSpace(strings.TrimPrefix(line, "#")), pending, dockerfile.Suppressed)
+			continue
+		}
+		if line == "" && current == nil {
+			continue
+		}
+
+		continued := strings.HasSuffix(line, "\\")
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+		if current == nil {
+			fields := strings.SplitN(line, " ", 2)
+			current = &Instruction{Command: strings.ToUpper(fields[0]), Line: lineNumber, Suppressed: pending}
+			pending = map[string]bool{}
+			if len(fields) > 1 {
+				parts = append(parts, strings.TrimSpace(fields[1]))
+			}
+		} else if line != "" {
+			parts = append(parts, line)
+		}
+		if !continued {
+			current.Args = strings.Join(parts, " ")
+			dockerfile.Instructions = append(dockerfile.Instructions, *current)
+			current = nil
+			parts = nil
+		}
+	}
+	if current != nil {
+		current.Args = strings.Join(parts, " ")
+		dockerfile.Instructions = append(dockerfile.Instructions, *current)
+	}
+	return dockerfile
+}
+
+// parseSuppression records the rule IDs of a deployer-lint comment.
+func parseSuppression(comment string, next map[string]bool, file map[string]bool) {
+	if !strings.HasPrefix(comment, suppressionPrefix) {
+		return
+	}
+	for _, field := range strings.Fields(strings.TrimPrefix(comment, suppressionPrefix)) {
+		target := next
+		var ids string
+		switch {
+		case strings.HasPrefix(field, "ignore-file="):
+			target, ids = file, strings.TrimPrefix(field, "ignore-file=")
+		case strings.HasPrefix(field, "ignore="):
+			ids = strings.TrimPrefix(field, "ignore=")
+		default:
+			continue
+		}
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				target[strings.ToUpper(id)] = true
+			}
+		}
+	}
+}
+
+// Stage is a build stage: its FROM instruction and the instructions that follow it.
+type Stage struct {
+	From         Instruction
+	Image        string
+	Name         string
+	Instructions []Instruction
+}
+
+// Stages groups the instructions by build stage.
+func (d Dockerfile) Stages() []Stage {
+	var stages []Stage
+	for _, instruction := range d.Instructions {
+		if instruction.Command == "FROM" {
+			stage := Stage{From: instruction}
+			var fields []string
+			for _, field := range strings.Fields(instruction.Args) {
+				if !strings.HasPrefix(field, "--") {
+					fields = append(fields, field)
+				}
+			}
+			if len(fields) > 0 {
+				stage.Image = fields[0]
+			}
+			if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+				stage.Name = fields[2]
+			}
+			stages = append(stages, stage)
+			continue
+		}
+		if len(stages) > 0 {
+			stages[len(stages)-1].Instructions = append(stages[len(stages)-1].Instructions, instruction)
+		}
+	}
+	return stages
+}
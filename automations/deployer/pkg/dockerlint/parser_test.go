@@ -0,0 +1,50 @@
+package dockerlint_test
+
+import (
+	"reflect"
+	"testing"
+
+	"deployer/pkg/dockerlint"
+)
+
+func TestParse(t *testing.T) {
+	dockerfile := dockerlint.Parse([]byte(`# deployer-lint ignore-file=DF004,df002
+from node:18 as build
+
+# deployer-lint ignore=DF005
+RUN npm ci \
+    && npm run build \
+
+ENV PORT=3000
+`))
+	want := []dockerlint.Instruction{
+		{Command: "FROM", Args: "node:18 as build", Line: 2, Suppressed: map[string]bool{}},
+		{Command: "RUN", Args: "npm ci && npm run build", Line: 5, Suppressed: map[string]bool{"DF005": true}},
+		{Command: "ENV", Args: "PORT=3000", Line: 8, Suppressed: map[string]bool{}},
+	}
+	if !reflect.DeepEqual(dockerfile.Instructions, want) {
+		t.Errorf("got %+v, want %+v", dockerfile.Instructions, want)
+	}
+	if want := map[string]bool{"DF004": true, "DF002": true}; !reflect.DeepEqual(dockerfile.Suppressed, want) {
+		t.Errorf("got file suppressions %v, want %v", dockerfile.Suppressed, want)
+	}
+}
+
+func TestStages(t *testing.T) {
+	dockerfile := dockerlint.Parse([]byte(`FROM --platform=$BUILDPLATFORM golang:1.22 AS build
+RUN go build ./...
+FROM gcr.io/distroless/static:nonroot
+COPY --from=build /out /app
+USER nonroot
+`))
+	stages := dockerfile.Stages()
+	if len(stages) != 2 {
+		t.Fatalf("got %d stages, want 2", len(stages))
+	}
+	if stages[0].Image != "golang:1.22" || stages[0].Name != "build" || len(stages[0].Instructions) != 1 {
+		t.Errorf("got first stage %+v", stages[0])
+	}
+	if stages[1].Image != "gcr.io/distroless/static:nonroot" || stages[1].Name != "" || len(stages[1].Instructions) != 2 {
+		t.Errorf("got final stage %+v", stages[1])
+	}
+}
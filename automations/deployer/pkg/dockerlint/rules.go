@@ -0,0 +1,210 @@
+package dockerlint
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severities of the findings, only errors fail a release
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Rule describes a lint rule.
+type Rule struct {
+	ID          string
+	Severity    string
+	Description string
+}
+
+// Rules are the rules enforced by the linter.
+var Rules = []Rule{
+	{ID: "DF001", Severity: SeverityError, Description: "base images must use an explicit tag other than latest"},
+	{ID: "DF002", Severity: SeverityWarning, Description: "base images should be pinned by digest"},
+	{ID: "DF003", Severity: SeverityError, Description: "the final stage must switch to a non-root USER"},
+	{ID: "DF004", Severity: SeverityWarning, Description: "the image needs a HEALTHCHECK or Kubernetes probes"},
+	{ID: "DF005", Severity: SeverityError, Description: "secrets must not be set with ENV or ARG"},
+	{ID: "DF006", Severity: SeverityError, Description: ".dockerignore must exclude node_modules and .git"},
+}
+
+// Finding is a rule violation at a line of a file.
+type Finding struct {
+	RuleID   string
+	Severity string
+	File     string
+	Line     int
+	Message  string
+}
+
+// String formats the finding as file:line: severity rule: message.
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s %s: %s", f.File, f.Line, f.Severity, f.RuleID, f.Message)
+}
+
+// Options are the context the linter needs beyond the Dockerfile.
+type Options struct {
+	// DockerfileName is used in findings
+	DockerfileName string
+	// Dockerignore is the content of .dockerignore, nil when the file does not exist
+	Dockerignore []byte
+	// HasProbes tells whether the Kubernetes values declare liveness or readiness probes
+	HasProbes bool
+}
+
+// secretName matches variable names that usually hold secrets
+var secretName = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|API_?KEY|PRIVATE_?KEY|ACCESS_?KEY|CREDENTIAL)`)
+
+// Lint runs every rule against the Dockerfile and returns the findings not suppressed by comments.
+func Lint(dockerfile Dockerfile, opts Options) []Finding {
+	var findings []Finding
+	report := func(ruleID string, instruction *Instruction, file string, line int, message string) {
+		if dockerfile.Suppressed[ruleID] || (instruction != nil && instruction.Suppressed[ruleID]) {
+			return
+		}
+		findings = append(findings, Finding{RuleID: ruleID, Severity: severityOf(ruleID), File: file, Line: line, Message: message})
+	}
+	name := opts.DockerfileName
+
+	stages := dockerfile.Stages()
+	stageNames := map[string]bool{}
+	for _, stage := range stages {
+		from := stage.From
+		image := stage.Image
+		if image != "" && image != "scratch" && !stageNames[strings.ToLower(image)] && !strings.Contains(image, "$") {
+			tag, digest := splitImage(image)
+			if digest == "" && (tag == "" || tag == "latest") {
+				report("DF001", &from, name, from.Line, fmt.Sprintf("base image %s uses the latest tag", image))
+			}
+			if digest == "" {
+				report("DF002", &from, name, from.Line, fmt.Sprintf("base image %s is not pinned by digest", image))
+			}
+		}
+		if stage.Name != "" {
+			stageNames[strings.ToLower(stage.Name)] = true
+		}
+		for _, instruction := range stage.Instructions {
+			instruction := instruction
+			if instruction.Command != "ENV" && instruction.Command != "ARG" {
+				continue
+			}
+			for _, variable := range variableNames(instruction) {
+				if secretName.MatchString(variable) {
+					report("DF005", &instruction, name, instruction.Line, fmt.Sprintf("%s %s looks like a secret, use build secrets or runtime configuration", instruction.Command, variable))
+				}
+			}
+		}
+	}
+
+	if len(stages) > 0 {
+		final := stages[len(stages)-1]
+		var user *Instruction
+		var healthcheck *Instruction
+		for i, instruction := range final.Instructions {
+			switch instruction.Command {
+			case "USER":
+				user = &final.Instructions[i]
+			case "HEALTHCHECK":
+				healthcheck = &final.Instructions[i]
+			}
+		}
+		if user == nil {
+			report("DF003", &final.From, name, final.From.Line, "the final stage runs as root, add a non-root USER")
+		} else if isRoot(user.Args) {
+			report("DF003", user, name, user.Line, fmt.Sprintf("USER %s runs as root", user.Args))
+		}
+		disabled := healthcheck != nil && strings.EqualFold(strings.TrimSpace(healthcheck.Args), "NONE")
+		if (healthcheck == nil || disabled) && !opts.HasProbes {
+			report("DF004", &final.From, name, final.From.Line, "no HEALTHCHECK and no liveness/readiness probes in the chart values")
+		}
+	}
+
+	var missing []string
+	for _, pattern := range []string{"node_modules", ".git"} {
+		if !dockerignoreExcludes(opts.Dockerignore, pattern) {
+			missing = append(missing, pattern)
+		}
+	}
+	if opts.Dockerignore == nil {
+		report("DF006", nil, ".dockerignore", 1, "the build context has no .dockerignore")
+	} else if len(missing) > 0 {
+		report("DF006", nil, ".dockerignore", 1, fmt.Sprintf("missing exclusions: %s", strings.Join(missing, ", ")))
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings
+}
+
+func severityOf(ruleID string) string {
+	for _, rule := range Rules {
+		if rule.ID == ruleID {
+			return rule.Severity
+		}
+	}
+	return SeverityError
+}
+
+// splitImage returns the tag and digest of an image reference.
+func splitImage(image string) (string, string) {
+	var digest string
+	if i := strings.Index(image, "@"); i >= 0 {
+		image, digest = image[:i], image[i+1:]
+	}
+	lastSlash := strings.LastIndex(image, "/")
+	if i := strings.LastIndex(image, ":"); i > lastSlash {
+		return image[i+1:], digest
+	}
+	return "", digest
+}
+
+// variableNames returns the names declared by an ENV or ARG instruction.
+func variableNames(instruction Instruction) []string {
+	args := strings.TrimSpace(instruction.Args)
+	if args == "" {
+		return nil
+	}
+	// Legacy "ENV NAME value" form
+	if instruction.Command == "ENV" && !strings.Contains(strings.Fields(args)[0], "=") {
+		return []string{strings.Fields(args)[0]}
+	}
+	var names []string
+	for _, field := range strings.Fields(args) {
+		if i := strings.Index(field, "="); i > 0 {
+			names = append(names, field[:i])
+		} else if instruction.Command == "ARG" {
+			names = append(names, field)
+		}
+	}
+	return names
+}
+
+func isRoot(user string) bool {
+	name := strings.SplitN(strings.TrimSpace(user), ":", 2)[0]
+	return name == "root" || name == "0"
+}
+
+// dockerignoreExcludes reports whether a pattern of the .dockerignore content excludes the directory.
+func dockerignoreExcludes(content []byte, dir string) bool {
+	excluded := false
+	for _, line := range strings.Split(string(content), "\n") {
+		pattern := strings.TrimSpace(line)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "/")
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "**/"), "/")
+		if matched, _ := path.Match(pattern, dir); matched {
+			excluded = !negate
+		}
+	}
+	return excluded
+}
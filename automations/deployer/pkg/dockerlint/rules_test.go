@@ -0,0 +1,117 @@
+package dockerlint_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"deployer/pkg/dockerlint"
+)
+
+// dockerignore excludes what DF006 requires
+var dockerignore = []byte("node_modules\n.git\n")
+
+// ruleLines formats the findings as <rule>:<line>.
+func ruleLines(findings []dockerlint.Finding) []string {
+	var lines []string
+	for _, finding := range findings {
+		lines = append(lines, fmt.Sprintf("%s:%d", finding.RuleID, finding.Line))
+	}
+	return lines
+}
+
+func TestLintFixtures(t *testing.T) {
+	for _, test := range []struct {
+		fixture   string
+		hasProbes bool
+		want      []string
+	}{
+		{fixture: "clean.Dockerfile"},
+		// FROM --platform is skipped to find the image, a missing tag counts as latest
+		{fixture: "tags.Dockerfile", want: []string{"DF001:1", "DF002:1", "DF002:3"}},
+		// Stages built from an earlier stage alias are not base images
+		{fixture: "stages.Dockerfile", want: []string{"DF004:5"}},
+		{fixture: "stages.Dockerfile", hasProbes: true},
+		// Only the USER of the final stage counts
+		{fixture: "root.Dockerfile", want: []string{"DF003:4", "DF004:4"}},
+		{fixture: "rootuser.Dockerfile", want: []string{"DF003:2"}},
+		// Continuation lines are reported at the start of the instruction, legacy ENV k v included
+		{fixture: "secrets.Dockerfile", want: []string{"DF004:1", "DF005:2", "DF005:3", "DF005:5"}},
+		{fixture: "secrets.Dockerfile", hasProbes: true, want: []string{"DF005:2", "DF005:3", "DF005:5"}},
+		// ignore= applies to the next instruction, ignore-file= to the whole file
+		{fixture: "suppressed.Dockerfile", want: []string{"DF002:2", "DF005:7"}},
+	} {
+		t.Run(fmt.Sprintf("%s probes=%v", test.fixture, test.hasProbes), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			findings := dockerlint.Lint(dockerlint.Parse(data), dockerlint.Options{
+				DockerfileName: test.fixture,
+				Dockerignore:   dockerignore,
+				HasProbes:      test.hasProbes,
+			})
+			if got := ruleLines(findings); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+				for _, finding := range findings {
+					t.Log(finding)
+				}
+			}
+		})
+	}
+}
+
+func TestLintSecretsMessages(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "secrets.Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := dockerlint.Lint(dockerlint.Parse(data), dockerlint.Options{DockerfileName: "Dockerfile", Dockerignore: dockerignore, HasProbes: true})
+	var messages []string
+	for _, finding := range findings {
+		messages = append(messages, finding.String())
+	}
+	want := []string{
+		"Dockerfile:2: error DF005: ARG NPM_TOKEN looks like a secret, use build secrets or runtime configuration",
+		"Dockerfile:3: error DF005: ENV DB_PASSWORD looks like a secret, use build secrets or runtime configuration",
+		"Dockerfile:5: error DF005: ENV API_KEY looks like a secret, use build secrets or runtime configuration",
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("got %q, want %q", messages, want)
+	}
+}
+
+func TestLintDockerignore(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "clean.Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dockerfile := dockerlint.Parse(data)
+	for _, test := range []struct {
+		name         string
+		dockerignore []byte
+		want         string
+	}{
+		{name: "missing file", want: ".dockerignore:1: error DF006: the build context has no .dockerignore"},
+		{name: "plain patterns", dockerignore: []byte("node_modules\n.git\n")},
+		{name: "anchored and recursive patterns", dockerignore: []byte("**/node_modules/\n/.git\n")},
+		{name: "wildcard", dockerignore: []byte("*\n!src\n")},
+		{name: "commented out", dockerignore: []byte("# .git\nnode_modules\n"), want: ".dockerignore:1: error DF006: missing exclusions: .git"},
+		{name: "negated", dockerignore: []byte("node_modules\n.git\n!node_modules\n"), want: ".dockerignore:1: error DF006: missing exclusions: node_modules"},
+		{name: "negated then excluded again", dockerignore: []byte("!.git\n.git\nnode_modules\n")},
+		{name: "empty", dockerignore: []byte{}, want: ".dockerignore:1: error DF006: missing exclusions: node_modules, .git"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			findings := dockerlint.Lint(dockerfile, dockerlint.Options{DockerfileName: "Dockerfile", Dockerignore: test.dockerignore})
+			var got string
+			if len(findings) > 0 {
+				got = findings[0].String()
+			}
+			if got != test.want || len(findings) > 1 {
+				t.Errorf("got %v, want %q", findings, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package helmrepo
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// DownloadChart downloads the archive of the chart version of the repository index into the
+// directory and returns its path and sha256 digest. The archive is verified against the
+// digest of the index when it has one.
+func DownloadChart(entry Entry, version *repo.ChartVersion, dir string) (string, string, error) {
+	if len(version.URLs) == 0 {
+		return "", "", errors.Errorf("chart %s %s of repository %s has no url", version.Name, version.Version, entry.Name)
+	}
+	chartURL, err := repo.ResolveReferenceURL(entry.URL, version.URLs[0])
+	if err != nil {
+		return "", "", err
+	}
+	parsed, err := url.Parse(chartURL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid chart url %s", chartURL)
+	}
+	client, err := getters.ByScheme(parsed.Scheme)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "unsupported chart url %s", chartURL)
+	}
+	// The credentials are only sent to the host of the repository
+	data, err := client.Get(chartURL, getter.WithURL(entry.URL), getter.WithBasicAuth(entry.Username, entry.Password))
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to download chart %s %s", version.Name, version.Version)
+	}
+	digest := fmt.Sprintf("%x", sha256.Sum256(data.Bytes()))
+	if version.Digest != "" && version.Digest != digest {
+		return "", "", errors.Errorf("chart %s %s does not match the digest of the index of repository %s: expected sha256:%s, got sha256:%s", version.Name, version.Version, entry.Name, version.Digest, digest)
+	}
+	archive := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", version.Name, version.Version))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", errors.Wrapf(err, "failed to create %s", dir)
+	}
+	if err := os.WriteFile(archive, data.Bytes(), 0644); err != nil {
+		return "", "", errors.Wrapf(err, "failed to write %s", archive)
+	}
+	return archive, "sha256:" + digest, nil
+}
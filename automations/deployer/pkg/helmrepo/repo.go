@@ -0,0 +1,105 @@
+package helmrepo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// getters download the indexes and charts of http and https repositories
+var getters = getter.Providers{{Schemes: []string{"http", "https"}, New: getter.NewHTTPGetter}}
+
+// Entry is a chart repository with its credentials. The credentials are only kept in memory,
+// they are never written to the cache.
+type Entry struct {
+	Name     string
+	URL      string
+	Username string
+	Password string
+}
+
+// IsOCI tells whether the repository is an OCI registry. OCI registries have no index,
+// their charts are referenced with oci:// URLs.
+func (e Entry) IsOCI() bool {
+	return strings.HasPrefix(e.URL, "oci://")
+}
+
+// config returns the Helm repository entry with the credentials.
+func (e Entry) config() *repo.Entry {
+	return &repo.Entry{Name: e.Name, URL: e.URL, Username: e.Username, Password: e.Password}
+}
+
+// Cache holds a Helm repositories file and the indexes of its repositories in the layout of
+// the Helm repository cache, so that the helm CLI resolves the repository charts with
+// HELM_REPOSITORY_CONFIG and HELM_REPOSITORY_CACHE pointing to it.
+type Cache struct {
+	Dir string
+}
+
+// RepositoryFile returns the path of the repositories file.
+func (c Cache) RepositoryFile() string {
+	return filepath.Join(c.Dir, "repositories.yaml")
+}
+
+// IndexFile returns the path of the cached index of the repository.
+func (c Cache) IndexFile(name string) string {
+	return filepath.Join(c.Dir, name+"-index.yaml")
+}
+
+// Env returns the helm environment variables that select the cache.
+func (c Cache) Env() []string {
+	return []string{
+		"HELM_REPOSITORY_CONFIG=" + c.RepositoryFile(),
+		"HELM_REPOSITORY_CACHE=" + c.Dir,
+	}
+}
+
+// WriteRepositories replaces the repositories file with the entries, OCI registries excluded.
+// The credentials are left out: the charts of repositories that need them are downloaded
+// with DownloadChart.
+func (c Cache) WriteRepositories(entries []Entry) error {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create the repository cache %s", c.Dir)
+	}
+	file := repo.NewFile()
+	for _, entry := range entries {
+		if !entry.IsOCI() {
+			file.Update(&repo.Entry{Name: entry.Name, URL: entry.URL})
+		}
+	}
+	return errors.Wrapf(file.WriteFile(c.RepositoryFile(), 0600), "failed to write %s", c.RepositoryFile())
+}
+
+// LoadIndex reads the cached index of the repository, the versions of each chart sorted
+// newest first.
+func (c Cache) LoadIndex(name string) (*repo.IndexFile, error) {
+	index, err := repo.LoadIndexFile(c.IndexFile(name))
+	return index, errors.Wrapf(err, "invalid index of repository %s", name)
+}
+
+// HasIndex tells whether the index of the repository is cached.
+func (c Cache) HasIndex(name string) bool {
+	_, err := os.Stat(c.IndexFile(name))
+	return err == nil
+}
+
+// DownloadIndex downloads the index.yaml of the repository into the cache. The cached index
+// is only replaced by a valid one.
+func (c Cache) DownloadIndex(entry Entry) (*repo.IndexFile, error) {
+	if entry.IsOCI() {
+		return nil, errors.Errorf("repository %s is an OCI registry, it has no index", entry.Name)
+	}
+	chartRepository, err := repo.NewChartRepository(entry.config(), getters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid repository %s", entry.Name)
+	}
+	chartRepository.CachePath = c.Dir
+	if _, err := chartRepository.DownloadIndexFile(); err != nil {
+		return nil, errors.Wrapf(err, "failed to download the index of repository %s", entry.Name)
+	}
+	return c.LoadIndex(entry.Name)
+}
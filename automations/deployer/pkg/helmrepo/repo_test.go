@@ -0,0 +1,156 @@
+package helmrepo_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer/pkg/helmrepo"
+)
+
+const indexTemplate = `apiVersion: v1
+entries:
+  nginx:
+    - name: nginx
+      version: 9.4.1
+      appVersion: 1.21.0
+      digest: %s
+      urls:
+        - charts/nginx-9.4.1.tgz
+    - name: nginx
+      version: 9.5.0
+      appVersion: 1.22.0
+      digest: %s
+      urls:
+        - charts/nginx-9.5.0.tgz
+    - name: nginx
+      version: 10.0.0-rc.1
+      urls:
+        - charts/nginx-10.0.0-rc.1.tgz
+generated: "2022-09-01T00:00:00Z"
+`
+
+// serveRepository serves an index.yaml and chart archives behind basic auth. The archive of
+// nginx 9.5.0 does not match the digest of the index.
+func serveRepository(t *testing.T) *httptest.Server {
+	t.Helper()
+	archive := []byte("nginx 9.4.1 archive")
+	index := fmt.Sprintf(indexTemplate, fmt.Sprintf("%x", sha256.Sum256(archive)), strings.Repeat("0", 64))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "reader" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/index.yaml":
+			w.Write([]byte(index))
+		case "/charts/nginx-9.4.1.tgz":
+			w.Write(archive)
+		case "/charts/nginx-9.5.0.tgz":
+			w.Write([]byte("tampered archive"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadIndex(t *testing.T) {
+	server := serveRepository(t)
+	cache := helmrepo.Cache{Dir: t.TempDir()}
+	entry := helmrepo.Entry{Name: "private", URL: server.URL, Username: "reader", Password: "s3cret"}
+
+	if _, err := cache.DownloadIndex(helmrepo.Entry{Name: "private", URL: server.URL}); err == nil {
+		t.Error("expected an error without credentials")
+	}
+	if cache.HasIndex("private") {
+		t.Error("a failed download left an index in the cache")
+	}
+
+	index, err := cache.DownloadIndex(entry)
+	if err != nil {
+		t.Fatalf("DownloadIndex: %v", err)
+	}
+	if !cache.HasIndex("private") {
+		t.Fatal("the index is not cached")
+	}
+	versions := index.Entries["nginx"]
+	if len(versions) != 3 || versions[0].Version != "10.0.0-rc.1" || versions[2].Version != "9.4.1" {
+		t.Errorf("got versions %v, want them sorted newest first", versions)
+	}
+	cached, err := cache.LoadIndex("private")
+	if err != nil {
+		t.Fatalf("LoadIndex: %v", err)
+	}
+	latest, err := cached.Get("nginx", "")
+	if err != nil || latest.Version != "9.5.0" {
+		t.Errorf("got %v (%v), want the latest stable version 9.5.0", latest, err)
+	}
+}
+
+func TestWriteRepositoriesLeavesCredentialsOut(t *testing.T) {
+	cache := helmrepo.Cache{Dir: t.TempDir()}
+	err := cache.WriteRepositories([]helmrepo.Entry{
+		{Name: "private", URL: "https://charts.example.com", Username: "reader", Password: "s3cret"},
+		{Name: "oci", URL: "oci://registry.example.com/charts", Username: "reader", Password: "s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("WriteRepositories: %v", err)
+	}
+	data, err := os.ReadFile(cache.RepositoryFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), "reader") {
+		t.Errorf("the repositories file holds the credentials:\n%s", data)
+	}
+	if !strings.Contains(string(data), "https://charts.example.com") || strings.Contains(string(data), "oci://") {
+		t.Errorf("got repositories file\n%s\nwant the repository without the OCI registry", data)
+	}
+	if info, err := os.Stat(cache.RepositoryFile()); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestDownloadChart(t *testing.T) {
+	server := serveRepository(t)
+	cache := helmrepo.Cache{Dir: t.TempDir()}
+	entry := helmrepo.Entry{Name: "private", URL: server.URL, Username: "reader", Password: "s3cret"}
+	index, err := cache.DownloadIndex(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	version, err := index.Get("nginx", "9.4.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, digest, err := helmrepo.DownloadChart(entry, version, dir)
+	if err != nil {
+		t.Fatalf("DownloadChart: %v", err)
+	}
+	if archive != filepath.Join(dir, "nginx-9.4.1.tgz") || digest != "sha256:"+version.Digest {
+		t.Errorf("got %s %s, want the archive of nginx 9.4.1 with the digest of the index", archive, digest)
+	}
+	if data, err := os.ReadFile(archive); err != nil || string(data) != "nginx 9.4.1 archive" {
+		t.Errorf("got archive %q (%v)", data, err)
+	}
+
+	version, err = index.Get("nginx", "9.5.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := helmrepo.DownloadChart(entry, version, dir); err == nil || !strings.Contains(err.Error(), "does not match the digest") {
+		t.Errorf("got %v, want a digest mismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "nginx-9.5.0.tgz")); !os.IsNotExist(err) {
+		t.Error("the tampered archive was written")
+	}
+}
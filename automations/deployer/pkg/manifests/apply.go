@@ -0,0 +1,331 @@
+package manifests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// OwnerLabel holds the name of the vendor owning an applied resource
+	OwnerLabel = "deployer/owner"
+	// ManagedByLabel marks the resources applied by the deployer
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedBy is the value of the managed-by label
+	ManagedBy = "deployer"
+	// FieldManager is the server-side apply field manager of the deployer
+	FieldManager = "deployer"
+	// inventoryKey is the ConfigMap key holding the applied resources
+	inventoryKey = "resources"
+)
+
+// configMaps is the resource of the inventory ConfigMaps
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// applyPriority orders the kinds other resources depend on first, the rest keep the file order
+var applyPriority = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+}
+
+// Ref identifies an applied resource.
+type Ref struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// RefFor returns the reference of the object.
+func RefFor(object *unstructured.Unstructured) Ref {
+	gvk := object.GroupVersionKind()
+	return Ref{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Namespace: object.GetNamespace(), Name: object.GetName()}
+}
+
+// Object returns the reference without its version: the same object is served under every
+// version of its kind, so that an object moved to another apiVersion is still the same one.
+func (r Ref) Object() Ref {
+	r.Version = ""
+	return r
+}
+
+func (r Ref) String() string {
+	kind := r.Kind
+	if r.Group != "" {
+		kind = fmt.Sprintf("%s.%s", r.Kind, r.Group)
+	}
+	if r.Namespace != "" {
+		return fmt.Sprintf("%s %s/%s", kind, r.Namespace, r.Name)
+	}
+	return fmt.Sprintf("%s %s", kind, r.Name)
+}
+
+// Applier applies the objects of a vendor with server-side apply, labels them with the owner
+// and records them in an inventory ConfigMap, so that the objects dropped from the manifests,
+// or all of them on uninstall, are deleted.
+type Applier struct {
+	Client dynamic.Interface
+	Mapper meta.RESTMapper
+	// Owner is the value of the owner label, the name of the vendor
+	Owner string
+	// Namespace is given to the namespaced objects without one, default when empty
+	Namespace string
+	// InventoryNamespace holds the inventory ConfigMap, default when empty
+	InventoryNamespace string
+}
+
+// resource returns the client of the resource of the kind, in the namespace when it is namespaced.
+func (a Applier) resource(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, bool, error) {
+	mapping, err := a.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may come from a CustomResourceDefinition applied in the meantime
+		if resettable, ok := a.Mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = a.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to find the resource of %s", gvk)
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return a.Client.Resource(mapping.Resource).Namespace(namespace), true, nil
+	}
+	return a.Client.Resource(mapping.Resource), false, nil
+}
+
+func (a Applier) namespace() string {
+	if a.Namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return a.Namespace
+}
+
+func (a Applier) inventoryName() string {
+	return fmt.Sprintf("deployer-inventory-%s", a.Owner)
+}
+
+func (a Applier) inventoryClient() dynamic.ResourceInterface {
+	namespace := a.InventoryNamespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return a.Client.Resource(configMaps).Namespace(namespace)
+}
+
+// Apply applies the objects, namespaces and custom resource definitions first, and returns
+// the references of the applied objects.
+func (a Applier) Apply(ctx context.Context, objects []*unstructured.Unstructured) ([]Ref, error) {
+	ordered := make([]*unstructured.Unstructured, len(objects))
+	copy(ordered, objects)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return kindPriority(ordered[i].GetKind()) < kindPriority(ordered[j].GetKind())
+	})
+
+	force := true
+	var applied []Ref
+	for _, original := range ordered {
+		object := original.DeepCopy()
+		labels := object.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[OwnerLabel] = a.Owner
+		labels[ManagedByLabel] = ManagedBy
+		object.SetLabels(labels)
+
+		namespace := object.GetNamespace()
+		if namespace == "" {
+			namespace = a.namespace()
+		}
+		client, namespaced, err := a.resource(object.GroupVersionKind(), namespace)
+		if err != nil {
+			return applied, err
+		}
+		if namespaced {
+			object.SetNamespace(namespace)
+		} else {
+			object.SetNamespace("")
+		}
+		data, err := object.MarshalJSON()
+		if err != nil {
+			return applied, errors.Wrapf(err, "failed to encode %s", RefFor(object))
+		}
+		_, err = client.Patch(ctx, object.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: FieldManager, Force: &force})
+		if err != nil {
+			return applied, errors.Wrapf(err, "failed to apply %s", RefFor(object))
+		}
+		applied = append(applied, RefFor(object))
+	}
+	return applied, nil
+}
+
+func kindPriority(kind string) int {
+	if priority, ok := applyPriority[kind]; ok {
+		return priority
+	}
+	return len(applyPriority)
+}
+
+// Delete deletes the referenced objects still labelled with the owner, in the reverse apply
+// order, and returns the deleted ones. Objects already gone are skipped.
+func (a Applier) Delete(ctx context.Context, refs []Ref) ([]Ref, error) {
+	ordered := make([]Ref, 0, len(refs))
+	for i := len(refs) - 1; i >= 0; i-- {
+		ordered = append(ordered, refs[i])
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return kindPriority(ordered[i].Kind) > kindPriority(ordered[j].Kind)
+	})
+	var deleted []Ref
+	for _, ref := range ordered {
+		gvk := schema.GroupVersionKind{Group: ref.Group, Version: ref.Version, Kind: ref.Kind}
+		client, _, err := a.resource(gvk, ref.Namespace)
+		if meta.IsNoMatchError(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		live, err := client.Get(ctx, ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return deleted, errors.Wrapf(err, "failed to get %s", ref)
+		}
+		if live.GetLabels()[OwnerLabel] != a.Owner {
+			// Taken over by someone else, leave it alone
+			continue
+		}
+		if err := client.Delete(ctx, ref.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return deleted, errors.Wrapf(err, "failed to delete %s", ref)
+		}
+		deleted = append(deleted, ref)
+	}
+	return deleted, nil
+}
+
+// Inventory returns the objects recorded by the last apply of the owner.
+func (a Applier) Inventory(ctx context.Context) ([]Ref, error) {
+	inventory, err := a.inventoryClient().Get(ctx, a.inventoryName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the inventory of %s", a.Owner)
+	}
+	data, _, _ := unstructured.NestedString(inventory.Object, "data", inventoryKey)
+	var refs []Ref
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &refs); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode the inventory of %s", a.Owner)
+		}
+	}
+	return refs, nil
+}
+
+func (a Applier) writeInventory(ctx context.Context, refs []Ref) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the inventory")
+	}
+	inventory := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name": a.inventoryName(),
+			"labels": map[string]interface{}{
+				OwnerLabel:     a.Owner,
+				ManagedByLabel: ManagedBy,
+			},
+		},
+		"data": map[string]interface{}{inventoryKey: string(data)},
+	}}
+	body, err := inventory.MarshalJSON()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the inventory")
+	}
+	force := true
+	_, err = a.inventoryClient().Patch(ctx, a.inventoryName(), types.ApplyPatchType, body, metav1.PatchOptions{FieldManager: FieldManager, Force: &force})
+	return errors.Wrapf(err, "failed to write the inventory of %s", a.Owner)
+}
+
+// Sync applies the objects, deletes the objects of the previous apply that are gone from them
+// and records the new inventory. It returns the applied and the pruned objects.
+func (a Applier) Sync(ctx context.Context, objects []*unstructured.Unstructured) ([]Ref, []Ref, error) {
+	previous, err := a.Inventory(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	applied, err := a.Apply(ctx, objects)
+	if err != nil {
+		// Keep track of everything that may exist so that a later run can still prune it
+		return applied, nil, a.keepInventory(ctx, union(previous, applied), err)
+	}
+	current := map[Ref]bool{}
+	for _, ref := range applied {
+		current[ref.Object()] = true
+	}
+	var stale []Ref
+	for _, ref := range previous {
+		if !current[ref.Object()] {
+			stale = append(stale, ref)
+		}
+	}
+	pruned, err := a.Delete(ctx, stale)
+	if err != nil {
+		return applied, pruned, a.keepInventory(ctx, union(previous, applied), err)
+	}
+	return applied, pruned, a.writeInventory(ctx, applied)
+}
+
+// keepInventory records the objects after a failure and returns the failure.
+func (a Applier) keepInventory(ctx context.Context, refs []Ref, failure error) error {
+	if err := a.writeInventory(ctx, refs); err != nil {
+		return errors.Errorf("%v (%v)", failure, err)
+	}
+	return failure
+}
+
+// Uninstall deletes every object of the inventory, then the inventory itself.
+func (a Applier) Uninstall(ctx context.Context) ([]Ref, error) {
+	refs, err := a.Inventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := a.Delete(ctx, refs)
+	if err != nil {
+		return deleted, err
+	}
+	err = a.inventoryClient().Delete(ctx, a.inventoryName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return deleted, errors.Wrapf(err, "failed to delete the inventory of %s", a.Owner)
+	}
+	return deleted, nil
+}
+
+// union returns the objects of both lists, with the version of b for the objects of both.
+func union(a []Ref, b []Ref) []Ref {
+	seen := map[Ref]int{}
+	var refs []Ref
+	for _, ref := range append(append([]Ref{}, a...), b...) {
+		if i, ok := seen[ref.Object()]; ok {
+			refs[i] = ref
+			continue
+		}
+		seen[ref.Object()] = len(refs)
+		refs = append(refs, ref)
+	}
+	return refs
+}
@@ -0,0 +1,218 @@
+package manifests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	widgetsV1   = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	widgetsV2   = schema.GroupVersionResource{Group: "example.com", Version: "v2", Resource: "widgets"}
+)
+
+// applyReactor implements server-side apply on the object tracker of the fake client, which
+// only knows the other patch types: the applied object replaces the tracked one.
+func applyReactor(tracker k8stesting.ObjectTracker) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		object := &unstructured.Unstructured{}
+		if err := object.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		_, err := tracker.Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		if apierrors.IsNotFound(err) {
+			return true, object, tracker.Create(patch.GetResource(), object, patch.GetNamespace())
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		return true, object, tracker.Update(patch.GetResource(), object, patch.GetNamespace())
+	}
+}
+
+func newApplier(owner string) (Applier, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMaps:  "ConfigMapList",
+		deployments: "DeploymentList",
+		widgetsV1:   "WidgetList",
+		widgetsV2:   "WidgetList",
+	})
+	client.PrependReactor("patch", "*", applyReactor(client.Tracker()))
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "example.com", Version: "v2", Kind: "Widget"}, meta.RESTScopeNamespace)
+	return Applier{Client: client, Mapper: mapper, Owner: owner, Namespace: "vendors"}, client
+}
+
+func object(apiVersion string, kind string, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+	}}
+}
+
+func exists(t *testing.T, client *dynamicfake.FakeDynamicClient, gvr schema.GroupVersionResource, name string) bool {
+	t.Helper()
+	_, err := client.Tracker().Get(gvr, "vendors", name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestSyncPrunesDroppedObjects(t *testing.T) {
+	ctx := context.Background()
+	applier, client := newApplier("nginx")
+
+	applied, pruned, err := applier.Sync(ctx, []*unstructured.Unstructured{
+		object("v1", "ConfigMap", "config"),
+		object("v1", "ConfigMap", "dropped"),
+		object("v1", "ConfigMap", "taken-over"),
+		object("apps/v1", "Deployment", "nginx"),
+	})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(applied) != 4 || len(pruned) != 0 {
+		t.Fatalf("applied %v, pruned %v", applied, pruned)
+	}
+	live, err := client.Resource(deployments).Namespace("vendors").Get(ctx, "nginx", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live.GetLabels()[OwnerLabel] != "nginx" || live.GetLabels()[ManagedByLabel] != ManagedBy {
+		t.Errorf("got labels %v, want the owner and managed-by labels", live.GetLabels())
+	}
+
+	// An object labelled with another owner in the meantime is left alone
+	takenOver := object("v1", "ConfigMap", "taken-over")
+	takenOver.SetNamespace("vendors")
+	takenOver.SetLabels(map[string]string{OwnerLabel: "other"})
+	if err := client.Tracker().Update(configMaps, takenOver, "vendors"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, pruned, err = applier.Sync(ctx, []*unstructured.Unstructured{object("v1", "ConfigMap", "config")})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(pruned) != 2 {
+		t.Errorf("pruned %v, want the dropped ConfigMap and the Deployment", pruned)
+	}
+	if exists(t, client, configMaps, "dropped") || exists(t, client, deployments, "nginx") {
+		t.Error("the dropped objects still exist")
+	}
+	if !exists(t, client, configMaps, "config") || !exists(t, client, configMaps, "taken-over") {
+		t.Error("the kept objects were deleted")
+	}
+	inventory, err := applier.Inventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory) != 1 || inventory[0].Name != "config" {
+		t.Errorf("got inventory %v, want only the applied ConfigMap", inventory)
+	}
+}
+
+func TestSyncKeepsObjectMovedToAnotherVersion(t *testing.T) {
+	ctx := context.Background()
+	applier, client := newApplier("widgets")
+
+	if _, _, err := applier.Sync(ctx, []*unstructured.Unstructured{object("example.com/v1", "Widget", "blue")}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	applied, pruned, err := applier.Sync(ctx, []*unstructured.Unstructured{object("example.com/v2", "Widget", "blue")})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(pruned) != 0 {
+		t.Errorf("pruned %v, the object only moved to v2", pruned)
+	}
+	// The fake client tracks each version apart, a real cluster serves the same object under both
+	if !exists(t, client, widgetsV1, "blue") {
+		t.Error("the object was deleted through its previous version")
+	}
+	inventory, err := applier.Inventory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory) != 1 || inventory[0] != applied[0] {
+		t.Errorf("got inventory %v, want %v", inventory, applied)
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	ctx := context.Background()
+	applier, client := newApplier("nginx")
+	if _, _, err := applier.Sync(ctx, []*unstructured.Unstructured{
+		object("v1", "ConfigMap", "config"),
+		object("apps/v1", "Deployment", "nginx"),
+	}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	deleted, err := applier.Uninstall(ctx)
+	if err != nil {
+		t.Fatalf("Uninstall: %v", err)
+	}
+	if len(deleted) != 2 || exists(t, client, configMaps, "config") || exists(t, client, deployments, "nginx") {
+		t.Errorf("deleted %v, want every object", deleted)
+	}
+	if inventory, err := applier.Inventory(ctx); err != nil || inventory != nil {
+		t.Errorf("got inventory %v (%v) after uninstall, want none", inventory, err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	applier, client := newApplier("nginx")
+	deployment := func(name string, available string) *unstructured.Unstructured {
+		object := object("apps/v1", "Deployment", name)
+		object.SetNamespace("vendors")
+		object.SetLabels(map[string]string{"app": "nginx"})
+		unstructured.SetNestedSlice(object.Object, []interface{}{
+			map[string]interface{}{"type": "Available", "status": available},
+		}, "status", "conditions")
+		return object
+	}
+	if err := client.Tracker().Create(deployments, deployment("web", "True"), "vendors"); err != nil {
+		t.Fatal(err)
+	}
+
+	condition := WaitCondition{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "vendors", Name: "web", Condition: "Available", Timeout: time.Second}
+	if err := applier.Wait(ctx, condition, 10*time.Millisecond); err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+
+	if err := client.Tracker().Create(deployments, deployment("worker", "False"), "vendors"); err != nil {
+		t.Fatal(err)
+	}
+	condition = WaitCondition{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "vendors", Selector: "app=nginx", Condition: "Available", Timeout: 50 * time.Millisecond}
+	err := applier.Wait(ctx, condition, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "worker") || strings.Contains(err.Error(), "web") {
+		t.Errorf("got %v, want a timeout waiting for worker only", err)
+	}
+
+	condition = WaitCondition{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "vendors", Name: "missing", Condition: "Available", Timeout: 50 * time.Millisecond}
+	if err := applier.Wait(ctx, condition, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "no object found yet") {
+		t.Errorf("got %v, want a timeout without objects", err)
+	}
+}
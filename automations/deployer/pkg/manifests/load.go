@@ -0,0 +1,132 @@
+package manifests
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// manifestExtensions are the files read from a source directory
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// Load reads the objects of the sources, in order. A source is an http(s) URL, a file or a
+// directory whose yaml and json files are read in name order. Relative paths are relative
+// to baseDir.
+func Load(sources []string, baseDir string, client *http.Client) ([]*unstructured.Unstructured, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var objects []*unstructured.Unstructured
+	for _, source := range sources {
+		files, err := readSource(source, baseDir, client)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			decoded, err := Decode(file.data, file.name)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, decoded...)
+		}
+	}
+	return objects, nil
+}
+
+type sourceFile struct {
+	name string
+	data []byte
+}
+
+func readSource(source string, baseDir string, client *http.Client) ([]sourceFile, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to download %s", source)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("failed to download %s: %s", source, resp.Status)
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to download %s", source)
+		}
+		return []sourceFile{{name: source, data: data}}, nil
+	}
+
+	path := source
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", source)
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", source)
+		}
+		paths = nil
+		for _, entry := range entries {
+			if !entry.IsDir() && manifestExtensions[filepath.Ext(entry.Name())] {
+				paths = append(paths, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(paths)
+	}
+	var files []sourceFile
+	for _, file := range paths {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", file)
+		}
+		files = append(files, sourceFile{name: file, data: data})
+	}
+	return files, nil
+}
+
+// Decode decodes a multi-document yaml or json stream, expanding lists and skipping empty
+// documents. The name is used in the errors.
+func Decode(data []byte, name string) ([]*unstructured.Unstructured, error) {
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	var objects []*unstructured.Unstructured
+	for document := 1; ; document++ {
+		var content map[string]interface{}
+		if err := decoder.Decode(&content); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, errors.Wrapf(err, "failed to decode document %d of %s", document, name)
+		}
+		if len(content) == 0 {
+			continue
+		}
+		object := &unstructured.Unstructured{Object: content}
+		if object.IsList() {
+			err := object.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to decode the list of document %d of %s", document, name)
+			}
+			continue
+		}
+		if object.GetKind() == "" || object.GetAPIVersion() == "" || object.GetName() == "" {
+			return nil, errors.Errorf("document %d of %s has no apiVersion, kind or name", document, name)
+		}
+		objects = append(objects, object)
+	}
+}
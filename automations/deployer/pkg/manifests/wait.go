@@ -0,0 +1,126 @@
+package manifests
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultWaitTimeout is the timeout of the wait conditions without one
+const DefaultWaitTimeout = 5 * time.Minute
+
+// WaitCondition waits for the objects of a kind, selected by name or label selector, to
+// report a status condition, e.g. the Ready condition of the pods of a controller.
+type WaitCondition struct {
+	// APIVersion of the kind, the preferred version of the cluster when empty
+	APIVersion string
+	Kind       string
+	Namespace  string
+	// Name selects a single object, Selector a set of objects by their labels
+	Name     string
+	Selector string
+	// Condition is the type of the status condition that must be True, e.g. Ready or Available
+	Condition string
+	Timeout   time.Duration
+}
+
+func (c WaitCondition) String() string {
+	target := c.Kind
+	if c.Name != "" {
+		target = fmt.Sprintf("%s %s", c.Kind, c.Name)
+	} else if c.Selector != "" {
+		target = fmt.Sprintf("%s %s", c.Kind, c.Selector)
+	}
+	if c.Namespace != "" {
+		target = fmt.Sprintf("%s in %s", target, c.Namespace)
+	}
+	return fmt.Sprintf("%s to be %s", target, c.Condition)
+}
+
+// HasCondition tells whether the status condition of the type is True on the object.
+func HasCondition(object *unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(fmt.Sprint(condition["type"]), conditionType) {
+			return fmt.Sprint(condition["status"]) == "True"
+		}
+	}
+	return false
+}
+
+// Wait polls the objects of the condition every interval until they all report it, there
+// being at least one, or the timeout of the condition expires.
+func (a Applier) Wait(ctx context.Context, condition WaitCondition, interval time.Duration) error {
+	timeout := condition.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	gvk := schema.FromAPIVersionAndKind(condition.APIVersion, condition.Kind)
+	if condition.APIVersion == "" {
+		mapping, err := a.Mapper.RESTMapping(schema.GroupKind{Kind: condition.Kind})
+		if err != nil {
+			return errors.Wrapf(err, "failed to find the resource of %s", condition.Kind)
+		}
+		gvk = mapping.GroupVersionKind
+	}
+	client, _, err := a.resource(gvk, condition.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for {
+		var objects []unstructured.Unstructured
+		if condition.Name != "" {
+			object, err := client.Get(ctx, condition.Name, metav1.GetOptions{})
+			if err == nil {
+				objects = append(objects, *object)
+			} else if !apierrors.IsNotFound(err) && ctx.Err() == nil {
+				return errors.Wrapf(err, "failed to get %s %s", condition.Kind, condition.Name)
+			}
+		} else {
+			list, err := client.List(ctx, metav1.ListOptions{LabelSelector: condition.Selector})
+			if err != nil && ctx.Err() == nil {
+				return errors.Wrapf(err, "failed to list %s", condition.Kind)
+			}
+			if list != nil {
+				objects = list.Items
+			}
+		}
+
+		pending = nil
+		for i := range objects {
+			if !HasCondition(&objects[i], condition.Condition) {
+				pending = append(pending, objects[i].GetName())
+			}
+		}
+		if len(objects) > 0 && len(pending) == 0 {
+			return nil
+		}
+		if len(objects) == 0 {
+			pending = []string{"no object found yet"}
+		}
+
+		select {
+		case <-ctx.Done():
+			sort.Strings(pending)
+			return errors.Errorf("timed out after %s waiting for %s: %s", timeout, condition, strings.Join(pending, ", "))
+		case <-time.After(interval):
+		}
+	}
+}
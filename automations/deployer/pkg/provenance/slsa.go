@@ -0,0 +1,115 @@
+package provenance
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// in-toto statement and SLSA provenance identifiers
+const (
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	PredicateType = "https://slsa.dev/provenance/v0.2"
+	BuildType     = "https://github.com/lucascicco/liferay-devops-challenge/deployer/release@v1"
+)
+
+// DigestSet maps an algorithm to a digest value, e.g. sha256 -> <hex>.
+type DigestSet map[string]string
+
+// Subject is an artifact the statement is about.
+type Subject struct {
+	Name   string    `json:"name"`
+	Digest DigestSet `json:"digest"`
+}
+
+// Statement is an in-toto statement carrying a SLSA provenance predicate.
+type Statement struct {
+	Type          string    `json:"_type"`
+	PredicateType string    `json:"predicateType"`
+	Subject       []Subject `json:"subject"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Builder identifies the entity that ran the build.
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// ConfigSource is the source the build was started from.
+type ConfigSource struct {
+	URI        string    `json:"uri,omitempty"`
+	Digest     DigestSet `json:"digest,omitempty"`
+	EntryPoint string    `json:"entryPoint,omitempty"`
+}
+
+// Invocation describes how the build was started.
+type Invocation struct {
+	ConfigSource ConfigSource           `json:"configSource"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Environment  map[string]interface{} `json:"environment,omitempty"`
+}
+
+// Completeness tells which invocation fields are complete.
+type Completeness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// Metadata holds the timestamps of the build.
+type Metadata struct {
+	BuildInvocationID string       `json:"buildInvocationId,omitempty"`
+	BuildStartedOn    time.Time    `json:"buildStartedOn"`
+	BuildFinishedOn   time.Time    `json:"buildFinishedOn"`
+	Completeness      Completeness `json:"completeness"`
+	Reproducible      bool         `json:"reproducible"`
+}
+
+// Material is an input of the build.
+type Material struct {
+	URI    string    `json:"uri"`
+	Digest DigestSet `json:"digest,omitempty"`
+}
+
+// Predicate is the SLSA provenance v0.2 predicate.
+type Predicate struct {
+	Builder    Builder    `json:"builder"`
+	BuildType  string     `json:"buildType"`
+	Invocation Invocation `json:"invocation"`
+	Metadata   Metadata   `json:"metadata"`
+	Materials  []Material `json:"materials,omitempty"`
+}
+
+// Validate checks that the statement is a SLSA provenance for the image digest. The image name
+// is the canonical name the image was released under, e.g. docker.io/team/app, not the
+// registry it is read from: mirrors, replicas and bundles serve the same subject.
+func (s Statement) Validate(imageName string, digest string) error {
+	var problems []string
+	if s.Type != StatementType {
+		problems = append(problems, "unexpected statement type "+s.Type)
+	}
+	if s.PredicateType != PredicateType {
+		problems = append(problems, "unexpected predicate type "+s.PredicateType)
+	}
+	if s.Predicate.BuildType != BuildType {
+		problems = append(problems, "unexpected build type "+s.Predicate.BuildType)
+	}
+	hex := strings.TrimPrefix(digest, "sha256:")
+	found := false
+	for _, subject := range s.Subject {
+		if subject.Digest["sha256"] == hex && subject.Name == imageName {
+			found = true
+		}
+	}
+	if !found {
+		problems = append(problems, "no subject matches "+imageName+"@"+digest)
+	}
+	if s.Predicate.Metadata.BuildStartedOn.IsZero() || s.Predicate.Metadata.BuildFinishedOn.Before(s.Predicate.Metadata.BuildStartedOn) {
+		problems = append(problems, "invalid build timestamps")
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("invalid provenance: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
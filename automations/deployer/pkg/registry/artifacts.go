@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// emptyConfig is the OCI empty descriptor content used as config of artifacts
+var emptyConfig = []byte("{}")
+
+// Layer is a blob of an artifact to push.
+type Layer struct {
+	MediaType   string
+	Data        []byte
+	Annotations map[string]string
+}
+
+// Artifact is an OCI artifact attached to an image, such as an SBOM, a signature or an attestation.
+type Artifact struct {
+	ArtifactType string
+	// ConfigMediaType and Config default to the OCI empty config
+	ConfigMediaType string
+	Config          []byte
+	Layers          []Layer
+	// Subject is the image the artifact refers to
+	Subject     *Descriptor
+	Annotations map[string]string
+}
+
+// ReferrerTag returns the tag under which artifacts of the image digest are stored, following
+// the cosign convention, e.g. sha256-<hex>.sig. It is used by registries without the referrers API.
+func ReferrerTag(digest string, suffix string) string {
+	return strings.Replace(digest, ":", "-", 1) + "." + suffix
+}
+
+// PushArtifact uploads the blobs of the artifact and its manifest under the tag.
+func (c *Client) PushArtifact(repo string, tag string, artifact Artifact) (Descriptor, error) {
+	configMediaType := artifact.ConfigMediaType
+	config := artifact.Config
+	if config == nil {
+		configMediaType = MediaTypeOCIEmptyConfig
+		config = emptyConfig
+	}
+	configDesc, err := c.PutBlob(repo, config)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	configDesc.MediaType = configMediaType
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  artifact.ArtifactType,
+		Config:        &configDesc,
+		Subject:       artifact.Subject,
+		Annotations:   artifact.Annotations,
+	}
+	for _, layer := range artifact.Layers {
+		desc, err := c.PutBlob(repo, layer.Data)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		desc.MediaType = layer.MediaType
+		desc.Annotations = layer.Annotations
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return Descriptor{}, errors.Wrap(err, "failed to marshal artifact manifest")
+	}
+	digest, err := c.PutManifest(repo, tag, MediaTypeOCIManifest, body)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{
+		MediaType:    MediaTypeOCIManifest,
+		Digest:       digest,
+		Size:         int64(len(body)),
+		ArtifactType: artifact.ArtifactType,
+	}, nil
+}
+
+// PullArtifact fetches the manifest of the artifact and the content of its layers.
+func (c *Client) PullArtifact(repo string, reference string) (Manifest, [][]byte, error) {
+	manifest, _, err := c.GetImageManifest(repo, reference)
+	if err != nil {
+		return Manifest{}, nil, err
+	}
+	var layers [][]byte
+	for _, layer := range manifest.Layers {
+		data, err := c.GetBlob(repo, layer.Digest)
+		if err != nil {
+			return Manifest{}, nil, err
+		}
+		layers = append(layers, data)
+	}
+	return manifest, layers, nil
+}
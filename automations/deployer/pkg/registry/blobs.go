@@ -0,0 +1,246 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GetBlob downloads the blob and verifies its digest. Large blobs such as layers are streamed
+// with OpenBlob instead.
+func (c *Client) GetBlob(repo string, digest string) ([]byte, error) {
+	blob, _, err := c.OpenBlob(repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read blob %s@%s", repo, digest)
+	}
+	return data, nil
+}
+
+// OpenBlob starts downloading the blob and returns its content with its size. The digest is
+// verified while reading: the reader fails at the end of a blob that does not match it.
+func (c *Client) OpenBlob(repo string, digest string) (io.ReadCloser, int64, error) {
+	rawURL := fmt.Sprintf("%s/v2/%s/blobs/%s", c.endpoint(), repo, digest)
+	resp, err := c.do(http.MethodGet, rawURL, nil, nil, pullScope(repo))
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := checkResponse(resp, fmt.Sprintf("failed to get blob %s@%s", repo, digest), http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+	blob, err := NewVerifier(resp.Body, digest)
+	if err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+	return blob, resp.ContentLength, nil
+}
+
+// BlobExists reports whether the repository already has the blob.
+func (c *Client) BlobExists(repo string, digest string) (bool, error) {
+	rawURL := fmt.Sprintf("%s/v2/%s/blobs/%s", c.endpoint(), repo, digest)
+	resp, err := c.do(http.MethodHead, rawURL, nil, nil, pullScope(repo))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := checkResponse(resp, fmt.Sprintf("failed to check blob %s@%s", repo, digest), http.StatusOK); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutBlob uploads the blob, skipping it when it already exists.
+func (c *Client) PutBlob(repo string, data []byte) (Descriptor, error) {
+	desc := Descriptor{Digest: Digest(data), Size: int64(len(data))}
+	return desc, c.UploadBlob(repo, desc, bytes.NewReader(data))
+}
+
+// UploadBlob streams the content of the blob described by desc, skipping it when it already
+// exists. Blobs larger than the chunk size are sent in chunks, so that only one chunk is held
+// in memory. The digest of the content is verified before the upload is completed, a
+// mismatching upload is cancelled.
+func (c *Client) UploadBlob(repo string, desc Descriptor, content io.Reader) error {
+	exists, err := c.BlobExists(repo, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	blob, err := NewVerifier(io.NopCloser(content), desc.Digest)
+	if err != nil {
+		return err
+	}
+	location, err := c.startUpload(repo, "")
+	if err != nil {
+		return err
+	}
+
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	// The last chunk, possibly the whole blob, is sent with the request completing the upload
+	chunk := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(blob, chunk)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			chunk = chunk[:n]
+			break
+		}
+		if err != nil {
+			c.cancelUpload(repo, location)
+			return errors.Wrapf(err, "failed to read blob %s", desc.Digest)
+		}
+		if location, err = c.uploadChunk(repo, location, offset, chunk); err != nil {
+			c.cancelUpload(repo, location)
+			return err
+		}
+		offset += int64(n)
+	}
+	if desc.Size > 0 && offset+int64(len(chunk)) != desc.Size {
+		c.cancelUpload(repo, location)
+		return errors.Errorf("blob %s has %d bytes, expected %d", desc.Digest, offset+int64(len(chunk)), desc.Size)
+	}
+
+	uploadURL, err := url.Parse(location)
+	if err != nil {
+		return errors.Wrapf(err, "invalid upload location %s", location)
+	}
+	query := uploadURL.Query()
+	query.Set("digest", desc.Digest)
+	uploadURL.RawQuery = query.Encode()
+
+	headers := map[string]string{"Content-Type": "application/octet-stream"}
+	resp, err := c.do(http.MethodPut, uploadURL.String(), headers, chunk, pushScope(repo))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, fmt.Sprintf("failed to upload blob %s@%s", repo, desc.Digest), http.StatusCreated)
+}
+
+// uploadChunk sends a chunk of an upload and returns the location of the next request.
+func (c *Client) uploadChunk(repo string, location string, offset int64, chunk []byte) (string, error) {
+	headers := map[string]string{
+		"Content-Type":  "application/octet-stream",
+		"Content-Range": fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1),
+	}
+	resp, err := c.do(http.MethodPatch, location, headers, chunk, pushScope(repo))
+	if err != nil {
+		return location, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, fmt.Sprintf("failed to upload chunk of blob to %s", repo), http.StatusAccepted); err != nil {
+		return location, err
+	}
+	return c.absoluteLocation(resp, location), nil
+}
+
+// cancelUpload abandons the upload session, failures are ignored since the registry expires
+// abandoned uploads anyway.
+func (c *Client) cancelUpload(repo string, location string) {
+	resp, err := c.do(http.MethodDelete, location, nil, nil, pushScope(repo))
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// MountBlob asks the registry to mount the blob from another repository of the same registry.
+// It returns false when the registry does not support cross-repository mounts.
+func (c *Client) MountBlob(repo string, from string, digest string) (bool, error) {
+	query := url.Values{}
+	query.Set("mount", digest)
+	query.Set("from", from)
+	rawURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/?%s", c.endpoint(), repo, query.Encode())
+	resp, err := c.do(http.MethodPost, rawURL, nil, []byte{}, fmt.Sprintf("%s %s", pushScope(repo), pullScope(from)))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return true, nil
+	}
+	return false, checkResponse(resp, fmt.Sprintf("failed to mount blob %s into %s", digest, repo), http.StatusAccepted)
+}
+
+// startUpload starts a blob upload session and returns its absolute location.
+func (c *Client) startUpload(repo string, query string) (string, error) {
+	rawURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/%s", c.endpoint(), repo, query)
+	resp, err := c.do(http.MethodPost, rawURL, nil, []byte{}, pushScope(repo))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, fmt.Sprintf("failed to start blob upload to %s", repo), http.StatusAccepted); err != nil {
+		return "", err
+	}
+	location := c.absoluteLocation(resp, "")
+	if location == "" {
+		return "", errors.Errorf("registry did not return an upload location for %s", repo)
+	}
+	return location, nil
+}
+
+// absoluteLocation returns the Location header of the response as an absolute URL, or the
+// fallback when there is none.
+func (c *Client) absoluteLocation(resp *http.Response, fallback string) string {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return fallback
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		location = c.endpoint() + location
+	}
+	return location
+}
+
+// Verifier reads a blob and checks its digest once all of it has been read: the read
+// reaching the end of the content fails when the digest does not match. A blob that is not
+// read to the end is not verified.
+type Verifier struct {
+	io.ReadCloser
+	digest string
+	hash   hash.Hash
+	err    error
+}
+
+// NewVerifier returns a reader verifying the sha256 digest of the content.
+func NewVerifier(content io.ReadCloser, digest string) (*Verifier, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil, errors.Errorf("unsupported digest %s", digest)
+	}
+	return &Verifier{ReadCloser: content, digest: digest, hash: sha256.New()}, nil
+}
+
+func (v *Verifier) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := fmt.Sprintf("sha256:%x", v.hash.Sum(nil)); actual != v.digest {
+			v.err = errors.Errorf("blob digest mismatch: expected %s, got %s", v.digest, actual)
+			return n, v.err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+package registry_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+)
+
+func TestUploadBlobChunked(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		patches int
+	}{
+		{"abc", 0},
+		{"abcdefgh", 2},
+		{"abcdefghij", 2},
+	} {
+		server := registrytest.New()
+		client := server.Client()
+		client.ChunkSize = 4
+
+		desc, err := client.PutBlob("team/app", []byte(test.content))
+		if err != nil {
+			t.Fatalf("PutBlob(%q): %v", test.content, err)
+		}
+		if data, ok := server.Blob(desc.Digest); !ok || string(data) != test.content {
+			t.Errorf("stored %q, want %q", data, test.content)
+		}
+		if got := server.Requests["PATCH upload"]; got != test.patches {
+			t.Errorf("%q: got %d chunks, want %d", test.content, got, test.patches)
+		}
+		server.Close()
+	}
+}
+
+func TestUploadBlobDigestMismatch(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+	client.ChunkSize = 4
+
+	desc := registry.Descriptor{Digest: registry.Digest([]byte("expected content")), Size: 16}
+	err := client.UploadBlob("team/app", desc, strings.NewReader("tampered content"))
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("got %v, want a digest mismatch", err)
+	}
+	if _, ok := server.Blob(desc.Digest); ok {
+		t.Error("the mismatching blob was stored")
+	}
+	if server.Uploads() != 0 {
+		t.Error("the upload was not cancelled")
+	}
+}
+
+func TestOpenBlobVerifiesDigest(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+
+	desc, err := client.PutBlob("team/app", []byte("layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := client.GetBlob("team/app", desc.Digest)
+	if err != nil || string(data) != "layer" {
+		t.Fatalf("GetBlob: %q, %v", data, err)
+	}
+
+	server.SetBlob(desc.Digest, []byte("tampered"))
+	blob, _, err := client.OpenBlob("team/app", desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, err := io.Copy(io.Discard, blob); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("got %v, want a digest mismatch", err)
+	}
+}
+
+func TestCopyManifestStreamsBlobs(t *testing.T) {
+	src := registrytest.New()
+	defer src.Close()
+	dst := registrytest.New()
+	defer dst.Close()
+	dstClient := dst.Client()
+	dstClient.ChunkSize = 8
+
+	layer := bytes.Repeat([]byte("layer"), 10)
+	image, err := src.Client().PushArtifact("team/app", "1.0.0", registry.Artifact{
+		Layers: []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Data: layer}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := registry.CopyManifest(src.Client(), "team/app", "1.0.0", dstClient, "mirror/app", "1.0.0")
+	if err != nil {
+		t.Fatalf("CopyManifest: %v", err)
+	}
+	if copied.Digest != image.Digest {
+		t.Errorf("copied %s, want %s", copied.Digest, image.Digest)
+	}
+	if data, ok := dst.Blob(registry.Digest(layer)); !ok || !bytes.Equal(data, layer) {
+		t.Error("the layer was not copied")
+	}
+	if dst.Requests["PATCH upload"] == 0 {
+		t.Error("expected the layer to be uploaded in chunks")
+	}
+
+	// A corrupted source blob is not copied
+	corrupted := registrytest.New()
+	defer corrupted.Close()
+	src.SetBlob(registry.Digest(layer), []byte("corrupted"))
+	if _, err := registry.CopyManifest(src.Client(), "team/app", "1.0.0", corrupted.Client(), "mirror/app", "1.0.0"); err == nil {
+		t.Error("expected the corrupted layer to fail the copy")
+	}
+	if _, ok := corrupted.Blob(registry.Digest(layer)); ok {
+		t.Error("the corrupted layer was stored")
+	}
+}
+
+func TestLayoutCopyBlob(t *testing.T) {
+	layout := registry.Layout{Dir: t.TempDir()}
+	digest := registry.Digest([]byte("layer"))
+	if err := layout.CopyBlob(digest, strings.NewReader("tampered")); err == nil {
+		t.Fatal("expected a digest mismatch")
+	}
+	entries, _ := os.ReadDir(filepath.Join(layout.Dir, "blobs", "sha256"))
+	if len(entries) != 0 {
+		t.Errorf("got %d files left in the layout, want none", len(entries))
+	}
+	if err := layout.CopyBlob(digest, strings.NewReader("layer")); err != nil {
+		t.Fatalf("CopyBlob: %v", err)
+	}
+	if data, err := layout.ReadBlob(digest); err != nil || string(data) != "layer" {
+		t.Errorf("ReadBlob: %q, %v", data, err)
+	}
+}
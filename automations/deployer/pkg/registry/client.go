@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DockerHub is the registry name used for Docker Hub images.
+const DockerHub = "docker.io"
+
+// DefaultChunkSize is the size of the chunks of blob uploads, smaller blobs are uploaded in a
+// single request.
+const DefaultChunkSize = 16 << 20
+
+// ErrNotFound is returned when a manifest, tag or blob does not exist.
+var ErrNotFound = errors.New("not found in registry")
+
+// Client talks to an OCI distribution (Docker registry v2) API.
+type Client struct {
+	// Registry is the registry name as used in image references, e.g. docker.io or localhost:5000
+	Registry string
+	Username string
+	Password string
+	// PlainHTTP talks to the registry without TLS, used for local registries
+	PlainHTTP  bool
+	HTTPClient *http.Client
+	// HubAPI overrides the Docker Hub API URL, see DockerHubAPI
+	HubAPI string
+	// ChunkSize overrides DefaultChunkSize
+	ChunkSize int
+
+	mu       sync.Mutex
+	tokens   map[string]string
+	hubToken string
+}
+
+// NewClient returns a client for the registry. Local registries (localhost and
+// 127.0.0.1) are accessed over plain HTTP.
+func NewClient(registry string, username string, password string) *Client {
+	host := strings.SplitN(registry, ":", 2)[0]
+	return &Client{
+		Registry:   registry,
+		Username:   username,
+		Password:   password,
+		PlainHTTP:  host == "localhost" || host == "127.0.0.1",
+		HTTPClient: http.DefaultClient,
+		tokens:     map[string]string{},
+	}
+}
+
+// endpoint returns the base URL of the registry API.
+func (c *Client) endpoint() string {
+	host := c.Registry
+	if host == DockerHub || host == "index.docker.io" || host == "" {
+		host = "registry-1.docker.io"
+	}
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		return strings.TrimSuffix(host, "/")
+	}
+	if c.PlainHTTP {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// String returns the registry name.
+func (c *Client) String() string {
+	return c.Registry
+}
+
+// do sends the request, answering a Basic or Bearer authentication challenge when needed.
+// The request is rebuilt for the retry so bodies can be sent twice.
+func (c *Client) do(method string, rawURL string, headers map[string]string, body []byte, scope string) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, rawURL, reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create HTTP request")
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s %s failed", method, rawURL)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	if err := c.login(challenge, scope); err != nil {
+		return nil, err
+	}
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+	resp, err = c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s %s failed", method, rawURL)
+	}
+	return resp, nil
+}
+
+// authorize sets the cached bearer token for the scope, or basic auth when there is none.
+func (c *Client) authorize(req *http.Request, scope string) {
+	c.mu.Lock()
+	token, ok := c.tokens[scope]
+	c.mu.Unlock()
+	if ok && token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
+	}
+	if ok && c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// login answers the authentication challenge, fetching a bearer token for the scope.
+func (c *Client) login(challenge string, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.Username == "" {
+			return errors.Errorf("registry %s requires credentials", c.Registry)
+		}
+		c.mu.Lock()
+		c.tokens[scope] = ""
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return errors.Errorf("unsupported authentication challenge from %s: %q", c.Registry, challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return errors.Errorf("invalid bearer realm in challenge %q", challenge)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	for _, s := range strings.Split(scope, " ") {
+		if s != "" {
+			query.Add("scope", s)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create token request")
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch token from %s", realm.Host)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to fetch token from %s: status %d", realm.Host, resp.StatusCode)
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return errors.Wrap(err, "failed to decode token response")
+	}
+	token := tokenResp.Token
+	if token == "" {
+		token = tokenResp.AccessToken
+	}
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+		params[strings.ToLower(key)] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
+
+func pullScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull", repo)
+}
+
+func pushScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull,push", repo)
+}
+
+func deleteScope(repo string) string {
+	return fmt.Sprintf("repository:%s:delete", repo)
+}
+
+// checkResponse turns unexpected status codes into errors, including the registry error body.
+func checkResponse(resp *http.Response, what string, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.Wrap(ErrNotFound, what)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return errors.Errorf("%s: unexpected status code %d: %s", what, resp.StatusCode, strings.TrimSpace(string(body)))
+}
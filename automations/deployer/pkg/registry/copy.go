@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// nonDistributableLayers are layers registries are not allowed to redistribute
+var nonDistributableLayers = map[string]bool{
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":    true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar":      true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip": true,
+}
+
+// CopyManifest copies the manifest referenced by the tag or digest, with every manifest and
+// blob it references, from the source repository to the destination repository under the tag.
+// The manifest bytes are copied unchanged so the digest is preserved, which is verified.
+// Blobs already present in the destination are skipped.
+func CopyManifest(src *Client, srcRepo string, reference string, dst *Client, dstRepo string, tag string) (Descriptor, error) {
+	body, desc, err := src.GetManifest(srcRepo, reference)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return Descriptor{}, errors.Wrapf(err, "failed to decode manifest %s:%s", srcRepo, reference)
+	}
+
+	if manifest.IsIndex() {
+		for _, child := range manifest.Manifests {
+			if _, err := CopyManifest(src, srcRepo, child.Digest, dst, dstRepo, child.Digest); err != nil {
+				return Descriptor{}, err
+			}
+		}
+	} else {
+		blobs := manifest.Layers
+		if manifest.Config != nil {
+			blobs = append([]Descriptor{*manifest.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			if nonDistributableLayers[blob.MediaType] {
+				continue
+			}
+			if err := copyBlob(src, srcRepo, dst, dstRepo, blob.Digest); err != nil {
+				return Descriptor{}, err
+			}
+		}
+	}
+
+	digest, err := dst.PutManifest(dstRepo, tag, desc.MediaType, body)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if digest != desc.Digest {
+		return Descriptor{}, errors.Errorf("copied manifest %s:%s has digest %s, expected %s", dstRepo, tag, digest, desc.Digest)
+	}
+	return desc, nil
+}
+
+// copyBlob copies a blob, mounting it when both repositories are on the same registry. The
+// blob is streamed from the source to the destination and verified on the way.
+func copyBlob(src *Client, srcRepo string, dst *Client, dstRepo string, digest string) error {
+	exists, err := dst.BlobExists(dstRepo, digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if src.Registry == dst.Registry && srcRepo != dstRepo {
+		mounted, err := dst.MountBlob(dstRepo, srcRepo, digest)
+		if err == nil && mounted {
+			return nil
+		}
+	}
+	blob, size, err := src.OpenBlob(srcRepo, digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	return dst.UploadBlob(dstRepo, Descriptor{Digest: digest, Size: size}, blob)
+}
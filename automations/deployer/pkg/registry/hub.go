@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// DockerHubAPI is the Docker Hub API, used for the operations the registry API of Docker Hub
+// does not support, such as deleting tags.
+const DockerHubAPI = "https://hub.docker.com"
+
+// IsDockerHub returns whether the client talks to Docker Hub.
+func (c *Client) IsDockerHub() bool {
+	return c.Registry == DockerHub || c.Registry == "index.docker.io" || c.Registry == ""
+}
+
+// hubAPI returns the base URL of the Docker Hub API.
+func (c *Client) hubAPI() string {
+	if c.HubAPI != "" {
+		return c.HubAPI
+	}
+	return DockerHubAPI
+}
+
+// hubLogin returns the JWT of the Docker Hub API for the credentials of the client.
+func (c *Client) hubLogin() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hubToken != "" {
+		return c.hubToken, nil
+	}
+	if c.Username == "" {
+		return "", errors.New("the Docker Hub API requires credentials")
+	}
+	body, err := json.Marshal(map[string]string{"username": c.Username, "password": c.Password})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.HTTPClient.Post(c.hubAPI()+"/v2/users/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to log in to the Docker Hub API")
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "failed to log in to the Docker Hub API", http.StatusOK); err != nil {
+		return "", err
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		return "", errors.Wrap(err, "failed to decode the Docker Hub login response")
+	}
+	if login.Token == "" {
+		return "", errors.New("the Docker Hub API returned no token")
+	}
+	c.hubToken = login.Token
+	return c.hubToken, nil
+}
+
+// DeleteTag deletes the tag of the repository. Docker Hub deletes tags through its own API, the
+// manifest is removed by Docker Hub once it is untagged. Other registries delete the manifest of
+// the tag, which removes every tag pointing to it.
+func (c *Client) DeleteTag(repo string, tag string) error {
+	if !c.IsDockerHub() {
+		desc, err := c.HeadManifest(repo, tag)
+		if err != nil {
+			return err
+		}
+		return c.DeleteManifest(repo, desc.Digest)
+	}
+	token, err := c.hubLogin()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/v2/repositories/%s/tags/%s/", c.hubAPI(), repo, tag), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create HTTP request")
+	}
+	req.Header.Set("Authorization", "JWT "+token)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete tag %s:%s", repo, tag)
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, fmt.Sprintf("failed to delete tag %s:%s", repo, tag), http.StatusNoContent, http.StatusOK, http.StatusAccepted)
+}
@@ -0,0 +1,358 @@
+package registry
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AnnotationRefName names a manifest of an OCI layout, usually with the tag
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// AnnotationContainerdImageName is the full image name containerd gives to an imported manifest
+	AnnotationContainerdImageName = "io.containerd.image.name"
+)
+
+// Layout is an OCI image layout directory: an oci-layout file, an index.json referencing
+// named manifests and the content addressed blobs/<algorithm>/<hex> files.
+type Layout struct {
+	Dir string
+}
+
+// layoutVersion is the content of the oci-layout file
+var layoutVersion = []byte(`{"imageLayoutVersion":"1.0.0"}`)
+
+// blobPath returns the path of the blob with the digest.
+func (l Layout) blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(parts[1], "/.") {
+		return "", errors.Errorf("invalid digest %s", digest)
+	}
+	return filepath.Join(l.Dir, "blobs", parts[0], parts[1]), nil
+}
+
+// ReadBlob reads the blob and verifies its digest.
+func (l Layout) ReadBlob(digest string) ([]byte, error) {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "blob %s", digest)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read blob %s", digest)
+	}
+	if Digest(data) != digest {
+		return nil, errors.Errorf("blob digest mismatch: expected %s, got %s", digest, Digest(data))
+	}
+	return data, nil
+}
+
+// OpenBlob opens the blob for reading, its digest is verified when it is read to the end.
+func (l Layout) OpenBlob(digest string) (io.ReadCloser, error) {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "blob %s", digest)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open blob %s", digest)
+	}
+	blob, err := NewVerifier(file, digest)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return blob, nil
+}
+
+// WriteBlob stores the blob and returns its digest.
+func (l Layout) WriteBlob(data []byte) (string, error) {
+	digest := Digest(data)
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return digest, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create blobs directory of %s", l.Dir)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to write blob %s", digest)
+	}
+	return digest, nil
+}
+
+// CopyBlob streams the content of the blob with the digest into the layout, verifying the
+// digest before storing it.
+func (l Layout) CopyBlob(digest string, content io.Reader) error {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create blobs directory of %s", l.Dir)
+	}
+	blob, err := NewVerifier(io.NopCloser(content), digest)
+	if err != nil {
+		return err
+	}
+	// The blob is written under a temporary name so an interrupted copy is never taken for it
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return errors.Wrapf(err, "failed to write blob %s", digest)
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, blob)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write blob %s", digest)
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write blob %s", digest)
+	}
+	return errors.Wrapf(os.Rename(file.Name(), path), "failed to write blob %s", digest)
+}
+
+// Index reads the index.json of the layout, an empty index when the layout is new.
+func (l Layout) Index() (Manifest, error) {
+	index := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	data, err := os.ReadFile(filepath.Join(l.Dir, "index.json"))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return index, errors.Wrapf(err, "failed to read index of %s", l.Dir)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, errors.Wrapf(err, "failed to decode index of %s", l.Dir)
+	}
+	return index, nil
+}
+
+// WriteIndex writes the oci-layout file and the index.json of the layout.
+func (l Layout) WriteIndex(index Manifest) error {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create layout %s", l.Dir)
+	}
+	if err := os.WriteFile(filepath.Join(l.Dir, "oci-layout"), layoutVersion, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write oci-layout of %s", l.Dir)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode index")
+	}
+	return errors.Wrapf(os.WriteFile(filepath.Join(l.Dir, "index.json"), data, 0644), "failed to write index of %s", l.Dir)
+}
+
+// Resolve returns the descriptor of the manifest named by the reference, or of the manifest
+// with the digest when the reference is a digest.
+func (l Layout) Resolve(reference string) (Descriptor, error) {
+	index, err := l.Index()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[AnnotationRefName] == reference || desc.Digest == reference {
+			return desc, nil
+		}
+	}
+	if strings.HasPrefix(reference, "sha256:") {
+		data, err := l.ReadBlob(reference)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		return Descriptor{MediaType: manifestMediaType(data), Digest: reference, Size: int64(len(data))}, nil
+	}
+	return Descriptor{}, errors.Wrapf(ErrNotFound, "manifest %s in %s", reference, l.Dir)
+}
+
+// manifestMediaType returns the media type declared by a manifest.
+func manifestMediaType(data []byte) string {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.MediaType != "" {
+		return manifest.MediaType
+	}
+	return MediaTypeOCIManifest
+}
+
+// Pull copies the manifest referenced by the tag or digest, with every manifest and blob it
+// references, from the registry into the layout and names it in the index. The annotations
+// are added to the index entry.
+func (l Layout) Pull(src *Client, repo string, reference string, name string, annotations map[string]string) (Descriptor, error) {
+	desc, err := l.pull(src, repo, reference)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	index, err := l.Index()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	entry := desc
+	entry.Annotations = map[string]string{AnnotationRefName: name}
+	for key, value := range annotations {
+		entry.Annotations[key] = value
+	}
+	var manifests []Descriptor
+	for _, existing := range index.Manifests {
+		if existing.Annotations[AnnotationRefName] != name {
+			manifests = append(manifests, existing)
+		}
+	}
+	index.Manifests = append(manifests, entry)
+	return desc, l.WriteIndex(index)
+}
+
+func (l Layout) pull(src *Client, repo string, reference string) (Descriptor, error) {
+	body, desc, err := src.GetManifest(repo, reference)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return Descriptor{}, errors.Wrapf(err, "failed to decode manifest %s:%s", repo, reference)
+	}
+	if manifest.IsIndex() {
+		for _, child := range manifest.Manifests {
+			if _, err := l.pull(src, repo, child.Digest); err != nil {
+				return Descriptor{}, err
+			}
+		}
+	} else {
+		blobs := manifest.Layers
+		if manifest.Config != nil {
+			blobs = append([]Descriptor{*manifest.Config}, blobs...)
+		}
+		for _, blob := range blobs {
+			if nonDistributableLayers[blob.MediaType] {
+				continue
+			}
+			if path, err := l.blobPath(blob.Digest); err == nil {
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+			}
+			content, _, err := src.OpenBlob(repo, blob.Digest)
+			if err != nil {
+				return Descriptor{}, err
+			}
+			err = l.CopyBlob(blob.Digest, content)
+			content.Close()
+			if err != nil {
+				return Descriptor{}, err
+			}
+		}
+	}
+	if _, err := l.WriteBlob(body); err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}, nil
+}
+
+// WriteArchive writes the layout as a tar archive whose index only names the manifest with
+// the name, the format imported by containerd, e.g. with kind load image-archive.
+func (l Layout) WriteArchive(w io.Writer, name string) error {
+	desc, err := l.Resolve(name)
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{desc}})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode index")
+	}
+	archive := tar.NewWriter(w)
+	writeFile := func(name string, data []byte) error {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+	// Blobs are streamed into the archive, layers are never held in memory
+	copyFile := func(name string, path string, size int64) error {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(archive, file, size)
+		return err
+	}
+	if err := writeFile("oci-layout", layoutVersion); err != nil {
+		return errors.Wrap(err, "failed to write archive")
+	}
+	if err := writeFile("index.json", index); err != nil {
+		return errors.Wrap(err, "failed to write archive")
+	}
+	err = filepath.Walk(filepath.Join(l.Dir, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.Dir, path)
+		if err != nil {
+			return err
+		}
+		return copyFile(filepath.ToSlash(rel), path, info.Size())
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to write archive")
+	}
+	return errors.Wrap(archive.Close(), "failed to write archive")
+}
+
+// ExtractArchive extracts an OCI layout tar archive, such as the one written by
+// docker buildx build --output type=oci, into the directory of the layout.
+func (l Layout) ExtractArchive(r io.Reader) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return errors.Errorf("invalid path %s in archive", header.Name)
+		}
+		path := filepath.Join(l.Dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return errors.Wrapf(err, "failed to create directory of %s", path)
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s", path)
+		}
+		_, err = io.Copy(file, archive)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to write %s", path)
+		}
+	}
+}
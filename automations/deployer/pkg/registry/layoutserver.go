@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// layoutRoute matches the read-only distribution API paths served from layouts
+var layoutRoute = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|tags)/([^/]+)$`)
+
+// LayoutServer serves OCI layouts through the read-only part of the distribution API, so that
+// the registry client, and everything built on it, can read images from a bundle on disk.
+type LayoutServer struct {
+	// Layouts maps the repositories to the layouts holding them
+	Layouts map[string]Layout
+}
+
+// ServeHTTP answers the manifest, blob and tag list requests.
+func (s LayoutServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "read-only registry", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	match := layoutRoute.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		http.NotFound(w, r)
+		return
+	}
+	layout, ok := s.Layouts[match[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch match[2] {
+	case "tags":
+		index, err := layout.Index()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tags := []string{}
+		for _, desc := range index.Manifests {
+			if name := desc.Annotations[AnnotationRefName]; name != "" {
+				tags = append(tags, name)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": match[1], "tags": tags})
+	case "manifests":
+		desc, err := layout.Resolve(match[3])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.serveBlob(w, r, layout, desc.Digest, desc.MediaType)
+	case "blobs":
+		s.serveBlob(w, r, layout, match[3], "application/octet-stream")
+	}
+}
+
+func (s LayoutServer) serveBlob(w http.ResponseWriter, r *http.Request, layout Layout, digest string, mediaType string) {
+	blob, err := layout.OpenBlob(digest)
+	if errors.Cause(err) == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+	path, _ := layout.blobPath(digest)
+	info, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if r.Method == http.MethodGet {
+		// The client verifies the digest of the content
+		io.Copy(w, blob)
+	}
+}
+
+// Serve starts serving the layouts on a random local port and returns a client for them and
+// a function stopping the server.
+func (s LayoutServer) Serve() (*Client, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to listen for the layout server")
+	}
+	server := &http.Server{Handler: s}
+	go server.Serve(listener)
+	client := NewClient(fmt.Sprintf("http://%s", listener.Addr().String()), "", "")
+	return client, func() { server.Close() }, nil
+}
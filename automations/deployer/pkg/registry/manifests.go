@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Digest returns the sha256 digest of the content in OCI format.
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// ListTags returns all the tags of the repository, following pagination links.
+func (c *Client) ListTags(repo string) ([]string, error) {
+	var tags []string
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=1000", c.endpoint(), repo)
+	for next != "" {
+		resp, err := c.do(http.MethodGet, next, nil, nil, pullScope(repo))
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(resp, fmt.Sprintf("failed to list tags of %s", repo), http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode tags of %s", repo)
+		}
+		tags = append(tags, page.Tags...)
+		next = nextLink(c.endpoint(), resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// nextLink extracts the rel="next" URL from a Link header.
+func nextLink(base string, header string) string {
+	if header == "" || !strings.Contains(header, `rel="next"`) {
+		return ""
+	}
+	start := strings.Index(header, "<")
+	end := strings.Index(header, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	link := header[start+1 : end]
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	if parsed.IsAbs() {
+		return link
+	}
+	return base + link
+}
+
+// HeadManifest returns the descriptor of the manifest referenced by the tag or digest.
+func (c *Client) HeadManifest(repo string, reference string) (Descriptor, error) {
+	rawURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.endpoint(), repo, reference)
+	headers := map[string]string{"Accept": strings.Join(manifestAccept, ", ")}
+	resp, err := c.do(http.MethodHead, rawURL, headers, nil, pullScope(repo))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, fmt.Sprintf("failed to get manifest %s:%s", repo, reference), http.StatusOK); err != nil {
+		return Descriptor{}, err
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// Some registries do not answer HEAD with the digest, fall back to GET
+		_, desc, err := c.GetManifest(repo, reference)
+		return desc, err
+	}
+	return Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    digest,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// GetManifest fetches the raw manifest referenced by the tag or digest and its descriptor.
+func (c *Client) GetManifest(repo string, reference string) ([]byte, Descriptor, error) {
+	rawURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.endpoint(), repo, reference)
+	headers := map[string]string{"Accept": strings.Join(manifestAccept, ", ")}
+	resp, err := c.do(http.MethodGet, rawURL, headers, nil, pullScope(repo))
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, fmt.Sprintf("failed to get manifest %s:%s", repo, reference), http.StatusOK); err != nil {
+		return nil, Descriptor{}, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, Descriptor{}, errors.Wrapf(err, "failed to read manifest %s:%s", repo, reference)
+	}
+	digest := Digest(body)
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return nil, Descriptor{}, errors.Errorf("manifest digest mismatch: expected %s, got %s", reference, digest)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if mediaType == "" || mediaType == "application/json" {
+		var manifest Manifest
+		if err := json.Unmarshal(body, &manifest); err == nil && manifest.MediaType != "" {
+			mediaType = manifest.MediaType
+		}
+	}
+	return body, Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(body))}, nil
+}
+
+// GetImageManifest fetches and decodes the manifest referenced by the tag or digest.
+func (c *Client) GetImageManifest(repo string, reference string) (Manifest, Descriptor, error) {
+	body, desc, err := c.GetManifest(repo, reference)
+	if err != nil {
+		return Manifest{}, desc, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return Manifest{}, desc, errors.Wrapf(err, "failed to decode manifest %s:%s", repo, reference)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = desc.MediaType
+	}
+	return manifest, desc, nil
+}
+
+// PutManifest uploads the manifest under the tag or digest and returns its digest.
+func (c *Client) PutManifest(repo string, reference string, mediaType string, body []byte) (string, error) {
+	rawURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.endpoint(), repo, reference)
+	headers := map[string]string{"Content-Type": mediaType}
+	resp, err := c.do(http.MethodPut, rawURL, headers, body, pushScope(repo))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, fmt.Sprintf("failed to put manifest %s:%s", repo, reference), http.StatusCreated, http.StatusOK); err != nil {
+		return "", err
+	}
+	digest := Digest(body)
+	if returned := resp.Header.Get("Docker-Content-Digest"); returned != "" && returned != digest {
+		return "", errors.Errorf("registry stored manifest with digest %s, expected %s", returned, digest)
+	}
+	return digest, nil
+}
+
+// DeleteManifest deletes the manifest with the digest, removing every tag that points to it.
+// Docker Hub does not support it, its tags are deleted with DeleteTag.
+func (c *Client) DeleteManifest(repo string, digest string) error {
+	if c.IsDockerHub() {
+		return errors.Errorf("failed to delete manifest %s@%s: Docker Hub does not support deleting manifests, delete its tags", repo, digest)
+	}
+	rawURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.endpoint(), repo, digest)
+	resp, err := c.do(http.MethodDelete, rawURL, nil, nil, deleteScope(repo))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return errors.Errorf("failed to delete manifest %s@%s: registry %s does not allow deleting manifests", repo, digest, c.Registry)
+	}
+	return checkResponse(resp, fmt.Sprintf("failed to delete manifest %s@%s", repo, digest), http.StatusAccepted, http.StatusOK)
+}
+
+// GetImageConfig returns the config of the image referenced by the tag or digest.
+// For multi-platform images the config of the first platform is returned.
+func (c *Client) GetImageConfig(repo string, reference string) (ImageConfig, error) {
+	manifest, _, err := c.GetImageManifest(repo, reference)
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	if manifest.IsIndex() {
+		if len(manifest.Manifests) == 0 {
+			return ImageConfig{}, errors.Errorf("image index %s:%s is empty", repo, reference)
+		}
+		return c.GetImageConfig(repo, manifest.Manifests[0].Digest)
+	}
+	if manifest.Config == nil {
+		return ImageConfig{}, errors.Errorf("manifest %s:%s has no config", repo, reference)
+	}
+	data, err := c.GetBlob(repo, manifest.Config.Digest)
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ImageConfig{}, errors.Wrapf(err, "failed to decode image config of %s:%s", repo, reference)
+	}
+	return config, nil
+}
@@ -0,0 +1,252 @@
+// Package registrytest provides an in-memory OCI distribution registry for tests.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"deployer/pkg/registry"
+)
+
+// Registry is an in-memory registry serving the subset of the distribution API used by the
+// client: blobs, monolithic and chunked uploads, cross-repository mounts, manifests and tags.
+type Registry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]map[string]manifest
+	uploads   map[string][]byte
+	nextID    int
+	// Requests counts the requests by method and path kind, e.g. "PATCH upload"
+	Requests map[string]int
+}
+
+type manifest struct {
+	mediaType string
+	body      []byte
+}
+
+// New starts a registry, close it with Close.
+func New() *Registry {
+	r := &Registry{
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string]manifest{},
+		uploads:   map[string][]byte{},
+		Requests:  map[string]int{},
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// Client returns a registry client talking to the server.
+func (r *Registry) Client() *registry.Client {
+	client := registry.NewClient(r.URL, "", "")
+	client.HTTPClient = r.Server.Client()
+	return client
+}
+
+// Manifest returns the manifest stored under the tag or digest.
+func (r *Registry) Manifest(repo string, reference string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifests[repo][reference]
+	return m.body, ok
+}
+
+// SetManifest stores the manifest under the reference without any check, e.g. to move a tag
+// to another digest behind the back of the client.
+func (r *Registry) SetManifest(repo string, reference string, mediaType string, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setManifest(repo, reference, mediaType, body)
+}
+
+// Uploads returns the number of uploads in progress.
+func (r *Registry) Uploads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.uploads)
+}
+
+// Blob returns the content of the blob.
+func (r *Registry) Blob(digest string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.blobs[digest]
+	return data, ok
+}
+
+// SetBlob stores the content under the digest without any check, e.g. to corrupt a blob.
+func (r *Registry) SetBlob(digest string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digest] = data
+}
+
+func (r *Registry) setManifest(repo string, reference string, mediaType string, body []byte) {
+	if r.manifests[repo] == nil {
+		r.manifests[repo] = map[string]manifest{}
+	}
+	m := manifest{mediaType: mediaType, body: body}
+	r.manifests[repo][reference] = m
+	r.manifests[repo][digest(body)] = m
+}
+
+func digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// split returns the repository and the remaining path after the kind (blobs, manifests, tags).
+func split(path string) (string, string, string) {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, kind := range []string{"/blobs/uploads/", "/blobs/", "/manifests/", "/tags/"} {
+		if i := strings.LastIndex(path, kind); i >= 0 {
+			return path[:i], strings.Trim(kind, "/"), path[i+len(kind):]
+		}
+	}
+	return "", "", ""
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, kind, rest := split(req.URL.Path)
+	r.Requests[req.Method+" "+strings.Replace(kind, "blobs/uploads", "upload", 1)]++
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch kind {
+	case "blobs":
+		data, ok := r.blobs[rest]
+		if !ok {
+			http.Error(w, "blob unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Docker-Content-Digest", rest)
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case "blobs/uploads":
+		r.serveUpload(w, req, repo, rest, body)
+	case "manifests":
+		r.serveManifest(w, req, repo, rest, body)
+	case "tags":
+		var tags []string
+		for reference := range r.manifests[repo] {
+			if !strings.HasPrefix(reference, "sha256:") {
+				tags = append(tags, reference)
+			}
+		}
+		sort.Strings(tags)
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags})
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repo string, id string, body []byte) {
+	switch req.Method {
+	case http.MethodPost:
+		if mount := req.URL.Query().Get("mount"); mount != "" {
+			if _, ok := r.blobs[mount]; ok {
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		r.nextID++
+		id = strconv.Itoa(r.nextID)
+		r.uploads[id] = nil
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		upload, ok := r.uploads[id]
+		if !ok {
+			http.Error(w, "upload unknown", http.StatusNotFound)
+			return
+		}
+		if contentRange := req.Header.Get("Content-Range"); contentRange != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(contentRange, "%d-%d", &start, &end); err != nil || start != len(upload) || end != start+len(body)-1 {
+				http.Error(w, "invalid content range", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		r.uploads[id] = append(upload, body...)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(r.uploads[id])-1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		upload, ok := r.uploads[id]
+		if !ok {
+			http.Error(w, "upload unknown", http.StatusNotFound)
+			return
+		}
+		data := append(upload, body...)
+		expected := req.URL.Query().Get("digest")
+		if digest(data) != expected {
+			http.Error(w, "digest invalid", http.StatusBadRequest)
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[expected] = data
+		w.Header().Set("Docker-Content-Digest", expected)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repo string, reference string, body []byte) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := r.manifests[repo][reference]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+		w.Header().Set("Docker-Content-Digest", digest(m.body))
+		if req.Method == http.MethodGet {
+			w.Write(m.body)
+		}
+	case http.MethodPut:
+		if strings.HasPrefix(reference, "sha256:") && reference != digest(body) {
+			http.Error(w, "digest invalid", http.StatusBadRequest)
+			return
+		}
+		r.setManifest(repo, reference, req.Header.Get("Content-Type"), body)
+		w.Header().Set("Docker-Content-Digest", digest(body))
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := r.manifests[repo][reference]; !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		// Deleting a digest removes every tag pointing to it
+		for ref, m := range r.manifests[repo] {
+			if digest(m.body) == reference {
+				delete(r.manifests[repo], ref)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
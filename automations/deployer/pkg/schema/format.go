@@ -0,0 +1,172 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIVersionField is the field holding the version of a versioned file
+const APIVersionField = "apiVersion"
+
+// apiVersionPrefix prefixes the numbered versions, e.g. deployer/v2
+const apiVersionPrefix = "deployer/v"
+
+// Converter upgrades a document from the version From to the version To, in place.
+type Converter struct {
+	From    string
+	To      string
+	Convert func(document *yaml.Node) error
+}
+
+// Format is a versioned file format. Documents of older versions are upgraded through the
+// converters to the current version before they are validated and decoded.
+type Format struct {
+	// Name is the name of the file, e.g. infra.yaml
+	Name string
+	// Current is the version the typed model reads
+	Current string
+	// Unversioned is the version of the documents without an apiVersion
+	Unversioned string
+	Converters  []Converter
+}
+
+// versionNumber returns the number of a deployer/vN version, 0 when it is not one.
+func versionNumber(version string) int {
+	if !strings.HasPrefix(version, apiVersionPrefix) {
+		return 0
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(version, apiVersionPrefix))
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+// MappingValue returns the value of the key of the mapping node, nil when it is not set.
+func MappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// SetMappingValue sets the key of the mapping node to the scalar value, a new key goes first.
+func SetMappingValue(mapping *yaml.Node, key string, value string) {
+	if current := MappingValue(mapping, key); current != nil {
+		current.Kind, current.Tag, current.Value, current.Content = yaml.ScalarNode, "!!str", value, nil
+		return
+	}
+	mapping.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	}, mapping.Content...)
+}
+
+// Version returns the version of the document.
+func (f Format) Version(document *yaml.Node) string {
+	if value := MappingValue(document, APIVersionField); value != nil {
+		return value.Value
+	}
+	return f.Unversioned
+}
+
+// Migrate upgrades the mapping node of a document to the current version and returns the
+// version it was at. Versions newer than the current one ask for a newer deployer.
+func (f Format) Migrate(file string, document *yaml.Node) (string, error) {
+	from := f.Version(document)
+	position := document
+	if value := MappingValue(document, APIVersionField); value != nil {
+		position = value
+	}
+	fail := func(format string, args ...interface{}) error {
+		return Errors{{File: file, Line: position.Line, Column: position.Column, Message: fmt.Sprintf(format, args...)}}
+	}
+	if number := versionNumber(from); number > versionNumber(f.Current) {
+		return from, fail("%s %s is newer than %s, the newest version this deployer reads, upgrade the deployer CLI", f.Name, from, f.Current)
+	}
+	version := from
+	for version != f.Current {
+		var converter *Converter
+		for i := range f.Converters {
+			if f.Converters[i].From == version {
+				converter = &f.Converters[i]
+			}
+		}
+		if converter == nil {
+			return from, fail("unsupported %s %s of %s, expected %s", APIVersionField, from, f.Name, f.Current)
+		}
+		if err := converter.Convert(document); err != nil {
+			return from, fail("failed to convert %s from %s to %s: %v", f.Name, converter.From, converter.To, err)
+		}
+		SetMappingValue(document, APIVersionField, converter.To)
+		version = converter.To
+	}
+	return from, nil
+}
+
+// parse returns the document and its mapping node, nil for an empty document.
+func (f Format) parse(file string, data []byte) (*yaml.Node, *yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, nil, syntaxError(file, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, Errors{{File: file, Line: root.Line, Column: root.Column, Message: fmt.Sprintf("%s must be a mapping", f.Name)}}
+	}
+	return &document, root, nil
+}
+
+// Load upgrades the document to the current version, validates it against the type of target
+// and decodes it into target.
+func (f Format) Load(file string, data []byte, target interface{}) error {
+	_, root, err := f.parse(file, data)
+	if err != nil || root == nil {
+		return err
+	}
+	if _, err := f.Migrate(file, root); err != nil {
+		return err
+	}
+	if err := ValidateNode(file, root, target).Err(); err != nil {
+		return err
+	}
+	if err := root.Decode(target); err != nil {
+		return Errors{{File: file, Line: root.Line, Column: root.Column, Message: err.Error()}}
+	}
+	return nil
+}
+
+// Rewrite upgrades the document to the current version and returns it encoded, with its
+// comments, and the version it was at. Blank lines are not kept.
+func (f Format) Rewrite(file string, data []byte) ([]byte, string, error) {
+	document, root, err := f.parse(file, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if root == nil {
+		return data, f.Current, nil
+	}
+	from, err := f.Migrate(file, root)
+	if err != nil || from == f.Current {
+		return data, from, err
+	}
+	var out bytes.Buffer
+	if bytes.HasPrefix(data, []byte("---")) {
+		out.WriteString("---\n")
+	}
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return nil, from, err
+	}
+	return out.Bytes(), from, encoder.Close()
+}
@@ -0,0 +1,249 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RequiredTag is the value of the schema struct tag of the fields that must be set
+const RequiredTag = "required"
+
+// Error is a schema violation at a position of a YAML file.
+type Error struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e Error) Error() string {
+	if e.Column == 0 {
+		// Syntax errors only have a line
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// Errors are the violations of a file, in the order of the file.
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Err returns the errors as an error, nil when there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validator is implemented by the types with rules the YAML structure cannot express. The
+// error is reported at the position of the value.
+type Validator interface {
+	Validate() error
+}
+
+var (
+	validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+	timeType      = reflect.TypeOf(time.Time{})
+)
+
+type validator struct {
+	file   string
+	errors Errors
+}
+
+// Validate checks the YAML document against the Go type of target, a pointer to a struct or map:
+// unknown fields, values of the wrong kind, missing fields tagged `schema:"required"`, and the
+// Validate method of the types that implement Validator. Fields are named by their yaml tag.
+func Validate(file string, data []byte, target interface{}) Errors {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return syntaxError(file, err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	return ValidateNode(file, document.Content[0], target)
+}
+
+// ValidateNode is Validate for the root node of a parsed document.
+func ValidateNode(file string, root *yaml.Node, target interface{}) Errors {
+	v := &validator{file: file}
+	v.check(root, reflect.TypeOf(target).Elem(), "")
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		if v.errors[i].Line != v.errors[j].Line {
+			return v.errors[i].Line < v.errors[j].Line
+		}
+		return v.errors[i].Column < v.errors[j].Column
+	})
+	return v.errors
+}
+
+// syntaxError returns the YAML syntax error at its line.
+func syntaxError(file string, err error) Errors {
+	line := 0
+	fmt.Sscanf(strings.TrimPrefix(err.Error(), "yaml: "), "line %d:", &line)
+	return Errors{{File: file, Line: line, Message: err.Error()}}
+}
+
+func (v *validator) fail(node *yaml.Node, format string, args ...interface{}) {
+	v.errors = append(v.errors, Error{File: v.file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+func fieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
+
+// check validates the node against the type, path is the dotted path of the node.
+func (v *validator) check(node *yaml.Node, t reflect.Type, path string) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	before := len(v.errors)
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return
+	case reflect.Struct:
+		if t == timeType {
+			if node.Kind != yaml.ScalarNode || node.Tag != "!!timestamp" {
+				v.fail(node, "%s must be a timestamp, not %s", describe(path), kindName(node))
+			}
+			break
+		}
+		if node.Kind != yaml.MappingNode {
+			v.fail(node, "%s must be a mapping, not %s", describe(path), kindName(node))
+			return
+		}
+		v.checkStruct(node, t, path)
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			v.fail(node, "%s must be a mapping, not %s", describe(path), kindName(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.check(node.Content[i+1], t.Elem(), fieldPath(path, node.Content[i].Value))
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			v.fail(node, "%s must be a list, not %s", describe(path), kindName(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			v.fail(node, "%s must be a string, not %s", describe(path), kindName(node))
+		}
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			v.fail(node, "%s must be true or false, not %s", describe(path), kindName(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			v.fail(node, "%s must be an integer, not %s", describe(path), kindName(node))
+		}
+	case reflect.Float32, reflect.Float64:
+		if node.Kind != yaml.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			v.fail(node, "%s must be a number, not %s", describe(path), kindName(node))
+		}
+	}
+
+	if len(v.errors) > before || !reflect.PtrTo(t).Implements(validatorType) {
+		return
+	}
+	value := reflect.New(t)
+	if err := node.Decode(value.Interface()); err != nil {
+		v.fail(node, "%s: %v", describe(path), err)
+		return
+	}
+	if err := value.Interface().(Validator).Validate(); err != nil {
+		if path == "" {
+			v.fail(node, "%v", err)
+		} else {
+			v.fail(node, "%s: %v", path, err)
+		}
+	}
+}
+
+func (v *validator) checkStruct(node *yaml.Node, t reflect.Type, path string) {
+	fields := map[string]reflect.StructField{}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+		names = append(names, name)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field, ok := fields[key.Value]
+		if !ok {
+			v.fail(key, "unknown field %s in %s, expected one of %s", key.Value, describe(path), strings.Join(names, ", "))
+			continue
+		}
+		if seen[key.Value] {
+			v.fail(key, "field %s is set more than once in %s", key.Value, describe(path))
+		}
+		seen[key.Value] = true
+		if field.Tag.Get("schema") == RequiredTag && value.Kind == yaml.ScalarNode && (value.Value == "" || value.Tag == "!!null") {
+			v.fail(value, "%s must not be empty", fieldPath(path, key.Value))
+			continue
+		}
+		v.check(value, field.Type, fieldPath(path, key.Value))
+	}
+	for _, name := range names {
+		if fields[name].Tag.Get("schema") == RequiredTag && !seen[name] {
+			v.fail(node, "%s is required in %s", name, describe(path))
+		}
+	}
+}
+
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("%q", node.Value)
+	}
+}
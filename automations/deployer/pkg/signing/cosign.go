@@ -0,0 +1,116 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+
+	"deployer/pkg/registry"
+
+	"github.com/pkg/errors"
+)
+
+// Cosign compatible media types and annotations
+const (
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation    = "dev.cosignproject.cosign/signature"
+	SignatureTagSuffix     = "sig"
+)
+
+// SimpleSigning is the payload signed for an image, in the format cosign uses.
+type SimpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// NewPayload returns the simple signing payload for the image digest.
+func NewPayload(dockerReference string, digest string) ([]byte, error) {
+	var payload SimpleSigning
+	payload.Critical.Identity.DockerReference = dockerReference
+	payload.Critical.Image.DockerManifestDigest = digest
+	payload.Critical.Type = "cosign container image signature"
+	data, err := json.Marshal(payload)
+	return data, errors.Wrap(err, "failed to marshal signing payload")
+}
+
+// SignImage signs the image digest and stores the signature in the registry under the
+// sha256-<hex>.sig tag, appending it to the signatures already stored there.
+func SignImage(client *registry.Client, repo string, dockerReference string, image registry.Descriptor, key *ecdsa.PrivateKey) (registry.Descriptor, error) {
+	payload, err := NewPayload(dockerReference, image.Digest)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	signature, err := Sign(key, payload)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+
+	tag := registry.ReferrerTag(image.Digest, SignatureTagSuffix)
+	layers, err := existingSignatures(client, repo, tag)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	layers = append(layers, registry.Layer{
+		MediaType:   SimpleSigningMediaType,
+		Data:        payload,
+		Annotations: map[string]string{SignatureAnnotation: signature},
+	})
+	return client.PushArtifact(repo, tag, registry.Artifact{
+		ConfigMediaType: registry.MediaTypeOCIConfig,
+		Config:          []byte(`{"architecture":"","os":"","rootfs":{"type":"layers","diff_ids":[]}}`),
+		Layers:          layers,
+	})
+}
+
+// existingSignatures returns the signature layers already stored under the tag.
+func existingSignatures(client *registry.Client, repo string, tag string) ([]registry.Layer, error) {
+	manifest, blobs, err := client.PullArtifact(repo, tag)
+	if errors.Cause(err) == registry.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var layers []registry.Layer
+	for i, layer := range manifest.Layers {
+		layers = append(layers, registry.Layer{MediaType: layer.MediaType, Data: blobs[i], Annotations: layer.Annotations})
+	}
+	return layers, nil
+}
+
+// VerifyImage checks that the registry holds a signature of the image digest made by one of the trusted keys.
+func VerifyImage(client *registry.Client, repo string, digest string, keys []*ecdsa.PublicKey) error {
+	if len(keys) == 0 {
+		return errors.New("no trusted keys configured")
+	}
+	manifest, blobs, err := client.PullArtifact(repo, registry.ReferrerTag(digest, SignatureTagSuffix))
+	if errors.Cause(err) == registry.ErrNotFound {
+		return errors.Errorf("no signatures found for %s@%s", repo, digest)
+	}
+	if err != nil {
+		return err
+	}
+	for i, layer := range manifest.Layers {
+		if layer.MediaType != SimpleSigningMediaType {
+			continue
+		}
+		var payload SimpleSigning
+		if err := json.Unmarshal(blobs[i], &payload); err != nil {
+			continue
+		}
+		if payload.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+		if Verify(keys, blobs[i], layer.Annotations[SignatureAnnotation]) {
+			return nil
+		}
+	}
+	return errors.Errorf("no signature of %s@%s matches the trusted keys", repo, digest)
+}
@@ -0,0 +1,155 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"deployer/pkg/registry"
+	"deployer/pkg/registry/registrytest"
+)
+
+const testRepo = "team/app"
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// pushImage pushes a single layer image under the tag and returns its descriptor.
+func pushImage(t *testing.T, client *registry.Client, tag string, content string) registry.Descriptor {
+	t.Helper()
+	image, err := client.PushArtifact(testRepo, tag, registry.Artifact{
+		ConfigMediaType: registry.MediaTypeOCIConfig,
+		Config:          []byte(`{"architecture":"amd64","os":"linux"}`),
+		Layers:          []registry.Layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Data: []byte(content)}},
+	})
+	if err != nil {
+		t.Fatalf("pushing %s: %v", tag, err)
+	}
+	return image
+}
+
+func TestSignAndVerifyImage(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+	key := newKey(t)
+
+	image := pushImage(t, client, "1.0.0", "release")
+	if _, err := SignImage(client, testRepo, "registry/"+testRepo, image, key); err != nil {
+		t.Fatalf("SignImage: %v", err)
+	}
+	if err := VerifyImage(client, testRepo, image.Digest, []*ecdsa.PublicKey{&key.PublicKey}); err != nil {
+		t.Errorf("VerifyImage: %v", err)
+	}
+
+	// A second signature is appended, both keys are then accepted
+	other := newKey(t)
+	if _, err := SignImage(client, testRepo, "registry/"+testRepo, image, other); err != nil {
+		t.Fatalf("SignImage: %v", err)
+	}
+	if err := VerifyImage(client, testRepo, image.Digest, []*ecdsa.PublicKey{&other.PublicKey}); err != nil {
+		t.Errorf("VerifyImage with the second key: %v", err)
+	}
+	if err := VerifyImage(client, testRepo, image.Digest, []*ecdsa.PublicKey{&key.PublicKey}); err != nil {
+		t.Errorf("VerifyImage with the first key: %v", err)
+	}
+}
+
+func TestVerifyImageUntrustedKey(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+
+	image := pushImage(t, client, "1.0.0", "release")
+	if _, err := SignImage(client, testRepo, "registry/"+testRepo, image, newKey(t)); err != nil {
+		t.Fatalf("SignImage: %v", err)
+	}
+	err := VerifyImage(client, testRepo, image.Digest, []*ecdsa.PublicKey{&newKey(t).PublicKey})
+	if err == nil || !strings.Contains(err.Error(), "matches the trusted keys") {
+		t.Errorf("got %v, want no signature matching the trusted keys", err)
+	}
+	if err := VerifyImage(client, testRepo, image.Digest, nil); err == nil {
+		t.Error("expected an error without trusted keys")
+	}
+}
+
+func TestVerifyImageTamperedDigest(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+	key := newKey(t)
+	keys := []*ecdsa.PublicKey{&key.PublicKey}
+
+	image := pushImage(t, client, "1.0.0", "release")
+	if _, err := SignImage(client, testRepo, "registry/"+testRepo, image, key); err != nil {
+		t.Fatalf("SignImage: %v", err)
+	}
+
+	// The tag is moved to another image after the release
+	tampered := pushImage(t, client, "evil", "tampered")
+	body, _ := server.Manifest(testRepo, tampered.Digest)
+	server.SetManifest(testRepo, "1.0.0", registry.MediaTypeOCIManifest, body)
+	resolved, err := client.HeadManifest(testRepo, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Digest != tampered.Digest {
+		t.Fatalf("tag resolved to %s, want %s", resolved.Digest, tampered.Digest)
+	}
+	err = VerifyImage(client, testRepo, resolved.Digest, keys)
+	if err == nil || !strings.Contains(err.Error(), "no signatures found") {
+		t.Errorf("got %v, want no signatures for the tampered digest", err)
+	}
+
+	// The signature of the release copied to the tampered digest signs another digest
+	signature, _ := server.Manifest(testRepo, registry.ReferrerTag(image.Digest, SignatureTagSuffix))
+	server.SetManifest(testRepo, registry.ReferrerTag(tampered.Digest, SignatureTagSuffix), registry.MediaTypeOCIManifest, signature)
+	if err := VerifyImage(client, testRepo, tampered.Digest, keys); err == nil {
+		t.Error("expected the copied signature to be rejected")
+	}
+
+	if err := VerifyImage(client, testRepo, image.Digest, keys); err != nil {
+		t.Errorf("the release digest should still verify: %v", err)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	server := registrytest.New()
+	defer server.Close()
+	client := server.Client()
+	key := newKey(t)
+
+	image := pushImage(t, client, "1.0.0", "release")
+	envelope, err := SignEnvelope(key, InTotoPayloadType, []byte(`{"predicateType":"test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AttachAttestation(client, testRepo, image, "test", envelope); err != nil {
+		t.Fatalf("AttachAttestation: %v", err)
+	}
+
+	envelopes, err := FetchAttestations(client, testRepo, image.Digest, "test")
+	if err != nil {
+		t.Fatalf("FetchAttestations: %v", err)
+	}
+	if len(envelopes) != 1 {
+		t.Fatalf("got %d envelopes, want 1", len(envelopes))
+	}
+	if _, err := VerifyEnvelope([]*ecdsa.PublicKey{&key.PublicKey}, envelopes[0]); err != nil {
+		t.Errorf("VerifyEnvelope: %v", err)
+	}
+	if _, err := VerifyEnvelope([]*ecdsa.PublicKey{&newKey(t).PublicKey}, envelopes[0]); err == nil {
+		t.Error("expected the envelope to be rejected with an untrusted key")
+	}
+	if _, err := FetchAttestations(client, testRepo, image.Digest, "other"); err == nil {
+		t.Error("expected no attestation of another predicate type")
+	}
+}
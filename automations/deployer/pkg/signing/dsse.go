@@ -0,0 +1,115 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"deployer/pkg/registry"
+
+	"github.com/pkg/errors"
+)
+
+// Attestation media types and annotations, compatible with cosign attest
+const (
+	InTotoPayloadType       = "application/vnd.in-toto+json"
+	DSSEMediaType           = "application/vnd.dsse.envelope.v1+json"
+	PredicateTypeAnnotation = "predicateType"
+	AttestationTagSuffix    = "att"
+)
+
+// EnvelopeSignature is a signature of a DSSE envelope.
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Envelope is a DSSE envelope wrapping a signed payload.
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// pae is the DSSE pre-authentication encoding of the payload, the bytes actually signed.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// SignEnvelope wraps the payload in a DSSE envelope signed with the key.
+func SignEnvelope(key *ecdsa.PrivateKey, payloadType string, payload []byte) (Envelope, error) {
+	signature, err := Sign(key, pae(payloadType, payload))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []EnvelopeSignature{{Sig: signature}},
+	}, nil
+}
+
+// VerifyEnvelope checks that the envelope is signed by one of the keys and returns its payload.
+func VerifyEnvelope(keys []*ecdsa.PublicKey, envelope Envelope) ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode envelope payload")
+	}
+	for _, signature := range envelope.Signatures {
+		if Verify(keys, pae(envelope.PayloadType, payload), signature.Sig) {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("no envelope signature matches the trusted keys")
+}
+
+// AttachAttestation stores the signed envelope under the sha256-<hex>.att tag of the image,
+// appending it to the attestations already stored there.
+func AttachAttestation(client *registry.Client, repo string, image registry.Descriptor, predicateType string, envelope Envelope) (registry.Descriptor, error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return registry.Descriptor{}, errors.Wrap(err, "failed to marshal envelope")
+	}
+	tag := registry.ReferrerTag(image.Digest, AttestationTagSuffix)
+	layers, err := existingSignatures(client, repo, tag)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	layers = append(layers, registry.Layer{
+		MediaType:   DSSEMediaType,
+		Data:        data,
+		Annotations: map[string]string{PredicateTypeAnnotation: predicateType},
+	})
+	return client.PushArtifact(repo, tag, registry.Artifact{
+		ConfigMediaType: registry.MediaTypeOCIConfig,
+		Config:          []byte(`{"architecture":"","os":"","rootfs":{"type":"layers","diff_ids":[]}}`),
+		Layers:          layers,
+	})
+}
+
+// FetchAttestations returns the envelopes with the predicate type attached to the image digest.
+func FetchAttestations(client *registry.Client, repo string, digest string, predicateType string) ([]Envelope, error) {
+	manifest, blobs, err := client.PullArtifact(repo, registry.ReferrerTag(digest, AttestationTagSuffix))
+	if errors.Cause(err) == registry.ErrNotFound {
+		return nil, errors.Errorf("no attestations found for %s@%s", repo, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var envelopes []Envelope
+	for i, layer := range manifest.Layers {
+		if layer.MediaType != DSSEMediaType || layer.Annotations[PredicateTypeAnnotation] != predicateType {
+			continue
+		}
+		var envelope Envelope
+		if err := json.Unmarshal(blobs[i], &envelope); err != nil {
+			return nil, errors.Wrap(err, "failed to decode attestation envelope")
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	if len(envelopes) == 0 {
+		return nil, errors.Errorf("no %s attestation found for %s@%s", predicateType, repo, digest)
+	}
+	return envelopes, nil
+}
@@ -0,0 +1,107 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// GenerateKeyPair returns a new ECDSA P-256 key pair encoded as PEM, the key type used by cosign.
+func GenerateKeyPair() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate key")
+	}
+	privateDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal private key")
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal public key")
+	}
+	privatePem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer})
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+	return privatePem, publicPem, nil
+}
+
+// LoadPrivateKey reads an unencrypted ECDSA private key in PKCS#8 or SEC 1 PEM format.
+func LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read private key %s", path)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data found in %s", path)
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		return key, errors.Wrapf(err, "failed to parse private key %s", path)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse private key %s", path)
+		}
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.Errorf("private key %s is not an ECDSA key", path)
+		}
+		return key, nil
+	}
+	return nil, errors.Errorf("unsupported PEM block %q in %s, encrypted keys must be decrypted first", block.Type, path)
+}
+
+// LoadPublicKey reads an ECDSA public key in PKIX PEM format.
+func LoadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read public key %s", path)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.Errorf("no PUBLIC KEY PEM block found in %s", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse public key %s", path)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("public key %s is not an ECDSA key", path)
+	}
+	return key, nil
+}
+
+// Sign signs the SHA-256 digest of the payload and returns the base64 encoded ASN.1 signature.
+func Sign(key *ecdsa.PrivateKey, payload []byte) (string, error) {
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign payload")
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the base64 encoded signature of the payload against any of the keys.
+func Verify(keys []*ecdsa.PublicKey, payload []byte, signature string) bool {
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256(payload)
+	for _, key := range keys {
+		if ecdsa.VerifyASN1(key, hash[:], raw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package trivy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IgnoreRule accepts a vulnerability until the expiry date.
+type IgnoreRule struct {
+	ID            string `yaml:"id"`
+	Justification string `yaml:"justification"`
+	// Expires is a date in the YYYY-MM-DD format
+	Expires string `yaml:"expires"`
+}
+
+// Policy decides which findings fail a release, it is read from the security section of deploy.yaml.
+type Policy struct {
+	// MaxCounts is the maximum number of findings allowed per severity, severities not listed are unlimited
+	MaxCounts   map[string]int `yaml:"maxCounts"`
+	FixableOnly bool           `yaml:"fixableOnly"`
+	Ignore      []IgnoreRule   `yaml:"ignore"`
+	// Baseline is the environment whose deployed release is the baseline, only
+	// findings that are new relative to it count towards the limits
+	Baseline string `yaml:"baseline"`
+}
+
+// DefaultPolicy fails on any HIGH or CRITICAL finding.
+func DefaultPolicy() Policy {
+	return Policy{MaxCounts: map[string]int{"CRITICAL": 0, "HIGH": 0}}
+}
+
+// SeveritySummary counts the findings of one severity.
+type SeveritySummary struct {
+	Severity string
+	Total    int
+	Fixable  int
+	Ignored  int
+	Counted  int
+	Max      int
+	Limited  bool
+}
+
+// Evaluation is the result of applying a policy to a set of findings.
+type Evaluation struct {
+	Summaries []SeveritySummary
+	// Counted are the findings that count towards the limits
+	Counted []Vulnerability
+	// Violations describe the exceeded limits, empty when the policy passes
+	Violations []string
+	// Warnings describe expired or invalid ignore rules
+	Warnings []string
+}
+
+// Passed reports whether no limit was exceeded.
+func (e Evaluation) Passed() bool {
+	return len(e.Violations) == 0
+}
+
+// Validate checks that every ignore rule has an ID, a justification and a valid expiry date.
+func (p Policy) Validate() error {
+	var problems []string
+	for i, rule := range p.Ignore {
+		if rule.ID == "" {
+			problems = append(problems, fmt.Sprintf("ignore[%d]: id is required", i))
+		}
+		if strings.TrimSpace(rule.Justification) == "" {
+			problems = append(problems, fmt.Sprintf("ignore[%d] %s: justification is required", i, rule.ID))
+		}
+		if _, err := time.Parse("2006-01-02", rule.Expires); err != nil {
+			problems = append(problems, fmt.Sprintf("ignore[%d] %s: expires must be a YYYY-MM-DD date", i, rule.ID))
+		}
+	}
+	for severity := range p.MaxCounts {
+		if !isSeverity(severity) {
+			problems = append(problems, fmt.Sprintf("maxCounts: unknown severity %s", severity))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("invalid security policy: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Evaluate applies the policy to the findings at the given time.
+func (p Policy) Evaluate(vulnerabilities []Vulnerability, now time.Time) Evaluation {
+	var evaluation Evaluation
+	ignored := map[string]IgnoreRule{}
+	for _, rule := range p.Ignore {
+		expires, err := time.Parse("2006-01-02", rule.Expires)
+		if err != nil {
+			evaluation.Warnings = append(evaluation.Warnings, fmt.Sprintf("ignore rule %s has an invalid expiry date %q", rule.ID, rule.Expires))
+			continue
+		}
+		// The rule is valid until the end of the expiry day
+		if now.After(expires.Add(24 * time.Hour)) {
+			evaluation.Warnings = append(evaluation.Warnings, fmt.Sprintf("ignore rule %s expired on %s", rule.ID, rule.Expires))
+			continue
+		}
+		ignored[rule.ID] = rule
+	}
+
+	summaries := map[string]*SeveritySummary{}
+	for _, severity := range Severities {
+		max, limited := p.MaxCounts[severity]
+		summaries[severity] = &SeveritySummary{Severity: severity, Max: max, Limited: limited}
+	}
+	for _, v := range vulnerabilities {
+		summary, ok := summaries[v.Severity]
+		if !ok {
+			summary = summaries["UNKNOWN"]
+		}
+		summary.Total++
+		if v.Fixable() {
+			summary.Fixable++
+		}
+		if _, ok := ignored[v.VulnerabilityID]; ok {
+			summary.Ignored++
+			continue
+		}
+		if p.FixableOnly && !v.Fixable() {
+			continue
+		}
+		summary.Counted++
+		evaluation.Counted = append(evaluation.Counted, v)
+	}
+
+	for _, severity := range Severities {
+		summary := summaries[severity]
+		evaluation.Summaries = append(evaluation.Summaries, *summary)
+		if summary.Limited && summary.Counted > summary.Max {
+			evaluation.Violations = append(
+				evaluation.Violations,
+				fmt.Sprintf("%d %s findings exceed the maximum of %d", summary.Counted, severity, summary.Max),
+			)
+		}
+	}
+	sort.SliceStable(evaluation.Counted, func(i, j int) bool {
+		return severityRank(evaluation.Counted[i].Severity) < severityRank(evaluation.Counted[j].Severity)
+	})
+	return evaluation
+}
+
+// PrintSummary writes a table with the counts per severity and the counted findings of limited severities.
+func (e Evaluation) PrintSummary(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tTOTAL\tFIXABLE\tIGNORED\tCOUNTED\tMAX")
+	for _, summary := range e.Summaries {
+		max := "-"
+		if summary.Limited {
+			max = fmt.Sprint(summary.Max)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", summary.Severity, summary.Total, summary.Fixable, summary.Ignored, summary.Counted, max)
+	}
+	w.Flush()
+
+	limited := map[string]bool{}
+	for _, summary := range e.Summaries {
+		limited[summary.Severity] = summary.Limited
+	}
+	var findings []Vulnerability
+	for _, v := range e.Counted {
+		if limited[v.Severity] {
+			findings = append(findings, v)
+		}
+	}
+	if len(findings) == 0 {
+		return
+	}
+	fmt.Fprintln(out)
+	PrintFindings(out, findings)
+}
+
+// PrintFindings writes a table with one line per finding.
+func PrintFindings(out io.Writer, findings []Vulnerability) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSEVERITY\tPACKAGE\tINSTALLED\tFIXED")
+	for _, v := range findings {
+		fixed := v.FixedVersion
+		if fixed == "" {
+			fixed = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.VulnerabilityID, v.Severity, v.PkgName, v.InstalledVersion, fixed)
+	}
+	w.Flush()
+}
+
+func isSeverity(severity string) bool {
+	for _, s := range Severities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func severityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(Severities)
+}
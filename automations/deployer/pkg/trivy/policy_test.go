@@ -0,0 +1,163 @@
+package trivy_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"deployer/pkg/trivy"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	critical := trivy.Vulnerability{VulnerabilityID: "CVE-1", PkgName: "openssl", Severity: "CRITICAL", FixedVersion: "3.0.1"}
+	unfixable := trivy.Vulnerability{VulnerabilityID: "CVE-2", PkgName: "zlib", Severity: "HIGH"}
+	high := trivy.Vulnerability{VulnerabilityID: "CVE-3", PkgName: "curl", Severity: "HIGH", FixedVersion: "8.0.0"}
+	low := trivy.Vulnerability{VulnerabilityID: "CVE-4", PkgName: "bash", Severity: "LOW"}
+	odd := trivy.Vulnerability{VulnerabilityID: "CVE-5", PkgName: "tar", Severity: "NEGLIGIBLE"}
+
+	for _, test := range []struct {
+		name            string
+		policy          trivy.Policy
+		vulnerabilities []trivy.Vulnerability
+		wantCounted     []string
+		wantViolations  []string
+		wantWarnings    []string
+	}{
+		{
+			name:            "default policy",
+			policy:          trivy.DefaultPolicy(),
+			vulnerabilities: []trivy.Vulnerability{low, high, critical},
+			wantCounted:     []string{"CVE-1", "CVE-3", "CVE-4"},
+			wantViolations:  []string{"1 CRITICAL findings exceed the maximum of 0", "1 HIGH findings exceed the maximum of 0"},
+		},
+		{
+			name:            "count at the maximum",
+			policy:          trivy.Policy{MaxCounts: map[string]int{"HIGH": 2}},
+			vulnerabilities: []trivy.Vulnerability{high, unfixable},
+			wantCounted:     []string{"CVE-3", "CVE-2"},
+		},
+		{
+			name:            "count above the maximum",
+			policy:          trivy.Policy{MaxCounts: map[string]int{"HIGH": 1}},
+			vulnerabilities: []trivy.Vulnerability{high, unfixable},
+			wantCounted:     []string{"CVE-3", "CVE-2"},
+			wantViolations:  []string{"2 HIGH findings exceed the maximum of 1"},
+		},
+		{
+			name:            "unfixable findings with fixableOnly",
+			policy:          trivy.Policy{MaxCounts: map[string]int{"HIGH": 0, "LOW": 0}, FixableOnly: true},
+			vulnerabilities: []trivy.Vulnerability{unfixable, low},
+		},
+		{
+			name:            "unfixable findings without fixableOnly",
+			policy:          trivy.Policy{MaxCounts: map[string]int{"HIGH": 0}},
+			vulnerabilities: []trivy.Vulnerability{unfixable},
+			wantCounted:     []string{"CVE-2"},
+			wantViolations:  []string{"1 HIGH findings exceed the maximum of 0"},
+		},
+		{
+			name: "active ignore on its expiry day",
+			policy: trivy.Policy{
+				MaxCounts: map[string]int{"CRITICAL": 0},
+				Ignore:    []trivy.IgnoreRule{{ID: "CVE-1", Justification: "not reachable", Expires: "2024-06-15"}},
+			},
+			vulnerabilities: []trivy.Vulnerability{critical},
+		},
+		{
+			name: "expired ignore",
+			policy: trivy.Policy{
+				MaxCounts: map[string]int{"CRITICAL": 0},
+				Ignore:    []trivy.IgnoreRule{{ID: "CVE-1", Justification: "not reachable", Expires: "2024-06-14"}},
+			},
+			vulnerabilities: []trivy.Vulnerability{critical},
+			wantCounted:     []string{"CVE-1"},
+			wantViolations:  []string{"1 CRITICAL findings exceed the maximum of 0"},
+			wantWarnings:    []string{"ignore rule CVE-1 expired on 2024-06-14"},
+		},
+		{
+			name: "invalid expiry date",
+			policy: trivy.Policy{
+				MaxCounts: map[string]int{"CRITICAL": 0},
+				Ignore:    []trivy.IgnoreRule{{ID: "CVE-1", Justification: "not reachable", Expires: "soon"}},
+			},
+			vulnerabilities: []trivy.Vulnerability{critical},
+			wantCounted:     []string{"CVE-1"},
+			wantViolations:  []string{"1 CRITICAL findings exceed the maximum of 0"},
+			wantWarnings:    []string{`ignore rule CVE-1 has an invalid expiry date "soon"`},
+		},
+		{
+			name:            "unknown severities count as UNKNOWN",
+			policy:          trivy.Policy{MaxCounts: map[string]int{"UNKNOWN": 0}},
+			vulnerabilities: []trivy.Vulnerability{odd},
+			wantCounted:     []string{"CVE-5"},
+			wantViolations:  []string{"1 UNKNOWN findings exceed the maximum of 0"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			evaluation := test.policy.Evaluate(test.vulnerabilities, now)
+			var counted []string
+			for _, v := range evaluation.Counted {
+				counted = append(counted, v.VulnerabilityID)
+			}
+			if !reflect.DeepEqual(counted, test.wantCounted) {
+				t.Errorf("got counted %v, want %v", counted, test.wantCounted)
+			}
+			if !reflect.DeepEqual(evaluation.Violations, test.wantViolations) {
+				t.Errorf("got violations %q, want %q", evaluation.Violations, test.wantViolations)
+			}
+			if !reflect.DeepEqual(evaluation.Warnings, test.wantWarnings) {
+				t.Errorf("got warnings %q, want %q", evaluation.Warnings, test.wantWarnings)
+			}
+			if evaluation.Passed() != (len(test.wantViolations) == 0) {
+				t.Errorf("got passed %v with violations %q", evaluation.Passed(), evaluation.Violations)
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluateSummaries(t *testing.T) {
+	policy := trivy.Policy{
+		MaxCounts:   map[string]int{"HIGH": 1},
+		FixableOnly: true,
+		Ignore:      []trivy.IgnoreRule{{ID: "CVE-3", Justification: "test only", Expires: "2099-01-01"}},
+	}
+	evaluation := policy.Evaluate([]trivy.Vulnerability{
+		{VulnerabilityID: "CVE-1", PkgName: "openssl", Severity: "HIGH", FixedVersion: "3.0.1"},
+		{VulnerabilityID: "CVE-2", PkgName: "zlib", Severity: "HIGH"},
+		{VulnerabilityID: "CVE-3", PkgName: "curl", Severity: "HIGH", FixedVersion: "8.0.0"},
+	}, time.Now())
+	want := trivy.SeveritySummary{Severity: "HIGH", Total: 3, Fixable: 2, Ignored: 1, Counted: 1, Max: 1, Limited: true}
+	if got := evaluation.Summaries[1]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(evaluation.Summaries) != len(trivy.Severities) {
+		t.Errorf("got %d summaries, want one per severity", len(evaluation.Summaries))
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	valid := trivy.Policy{
+		MaxCounts: map[string]int{"CRITICAL": 0},
+		Ignore:    []trivy.IgnoreRule{{ID: "CVE-1", Justification: "not reachable", Expires: "2024-06-15"}},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("got %v, want a valid policy", err)
+	}
+	invalid := trivy.Policy{
+		MaxCounts: map[string]int{"SEVERE": 0},
+		Ignore:    []trivy.IgnoreRule{{Expires: "15/06/2024"}},
+	}
+	err := invalid.Validate()
+	for _, want := range []string{
+		"ignore[0]: id is required",
+		"justification is required",
+		"expires must be a YYYY-MM-DD date",
+		"maxCounts: unknown severity SEVERE",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package trivy
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Severities in decreasing order of importance
+var Severities = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW", "UNKNOWN"}
+
+// Vulnerability is a single finding reported by Trivy.
+type Vulnerability struct {
+	VulnerabilityID  string `json:"VulnerabilityID"`
+	PkgName          string `json:"PkgName"`
+	InstalledVersion string `json:"InstalledVersion"`
+	FixedVersion     string `json:"FixedVersion,omitempty"`
+	Severity         string `json:"Severity"`
+	Title            string `json:"Title,omitempty"`
+	PrimaryURL       string `json:"PrimaryURL,omitempty"`
+}
+
+// Fixable reports whether a fixed version of the package is available.
+func (v Vulnerability) Fixable() bool {
+	return v.FixedVersion != ""
+}
+
+// Result groups the vulnerabilities found in one target (OS packages, node_modules, ...).
+type Result struct {
+	Target          string          `json:"Target"`
+	Class           string          `json:"Class,omitempty"`
+	Type            string          `json:"Type,omitempty"`
+	Vulnerabilities []Vulnerability `json:"Vulnerabilities,omitempty"`
+}
+
+// Report is the JSON report written by "trivy image --format json".
+type Report struct {
+	SchemaVersion int      `json:"SchemaVersion"`
+	ArtifactName  string   `json:"ArtifactName"`
+	ArtifactType  string   `json:"ArtifactType,omitempty"`
+	Results       []Result `json:"Results,omitempty"`
+}
+
+// Vulnerabilities returns the findings of every result of the report.
+func (r Report) Vulnerabilities() []Vulnerability {
+	var vulnerabilities []Vulnerability
+	for _, result := range r.Results {
+		vulnerabilities = append(vulnerabilities, result.Vulnerabilities...)
+	}
+	return vulnerabilities
+}
+
+// ReadReport parses a Trivy JSON report.
+func ReadReport(reportFile string) (Report, error) {
+	var report Report
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		return report, errors.Wrapf(err, "failed to read Trivy report %s", reportFile)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, errors.Wrapf(err, "failed to parse Trivy report %s", reportFile)
+	}
+	return report, nil
+}
+
+// Key identifies a finding across scans: the same vulnerability in the same package.
+func (v Vulnerability) Key() string {
+	return v.VulnerabilityID + "|" + v.PkgName
+}
+
+// WriteVulnerabilities stores the parsed findings of a release so later releases can compare against them.
+func WriteVulnerabilities(file string, vulnerabilities []Vulnerability) error {
+	data, err := json.MarshalIndent(vulnerabilities, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal vulnerabilities")
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write vulnerabilities file %s", file)
+	}
+	return nil
+}
+
+// ReadVulnerabilities reads the findings stored by WriteVulnerabilities.
+func ReadVulnerabilities(file string) ([]Vulnerability, error) {
+	var vulnerabilities []Vulnerability
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read vulnerabilities file %s", file)
+	}
+	if err := json.Unmarshal(data, &vulnerabilities); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse vulnerabilities file %s", file)
+	}
+	return vulnerabilities, nil
+}
+
+// Comparison splits the findings of a build relative to a baseline build.
+type Comparison struct {
+	// New findings are not present in the baseline
+	New []Vulnerability
+	// Inherited findings are present in both builds
+	Inherited []Vulnerability
+	// Fixed findings are present in the baseline only
+	Fixed []Vulnerability
+}
+
+// Compare matches the current findings against the baseline findings by Key.
+func Compare(current []Vulnerability, baseline []Vulnerability) Comparison {
+	var comparison Comparison
+	inBaseline := map[string]bool{}
+	for _, v := range baseline {
+		inBaseline[v.Key()] = true
+	}
+	inCurrent := map[string]bool{}
+	for _, v := range current {
+		if inCurrent[v.Key()] {
+			continue
+		}
+		inCurrent[v.Key()] = true
+		if inBaseline[v.Key()] {
+			comparison.Inherited = append(comparison.Inherited, v)
+		} else {
+			comparison.New = append(comparison.New, v)
+		}
+	}
+	fixed := map[string]bool{}
+	for _, v := range baseline {
+		if !inCurrent[v.Key()] && !fixed[v.Key()] {
+			fixed[v.Key()] = true
+			comparison.Fixed = append(comparison.Fixed, v)
+		}
+	}
+	return comparison
+}
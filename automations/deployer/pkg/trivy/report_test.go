@@ -0,0 +1,92 @@
+package trivy_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"deployer/pkg/trivy"
+)
+
+func TestCompare(t *testing.T) {
+	openssl := trivy.Vulnerability{VulnerabilityID: "CVE-1", PkgName: "openssl", Severity: "HIGH"}
+	opensslOtherTarget := trivy.Vulnerability{VulnerabilityID: "CVE-1", PkgName: "openssl", Severity: "HIGH", InstalledVersion: "3.0.0"}
+	libssl := trivy.Vulnerability{VulnerabilityID: "CVE-1", PkgName: "libssl", Severity: "HIGH"}
+	curl := trivy.Vulnerability{VulnerabilityID: "CVE-2", PkgName: "curl", Severity: "CRITICAL"}
+	zlib := trivy.Vulnerability{VulnerabilityID: "CVE-3", PkgName: "zlib", Severity: "LOW"}
+
+	keys := func(vulnerabilities []trivy.Vulnerability) []string {
+		var keys []string
+		for _, v := range vulnerabilities {
+			keys = append(keys, v.Key())
+		}
+		return keys
+	}
+	for _, test := range []struct {
+		name          string
+		current       []trivy.Vulnerability
+		baseline      []trivy.Vulnerability
+		wantNew       []string
+		wantInherited []string
+		wantFixed     []string
+	}{
+		{
+			name:    "no baseline",
+			current: []trivy.Vulnerability{openssl, curl},
+			wantNew: []string{"CVE-1|openssl", "CVE-2|curl"},
+		},
+		{
+			name:          "new, inherited and fixed",
+			current:       []trivy.Vulnerability{openssl, curl},
+			baseline:      []trivy.Vulnerability{openssl, zlib},
+			wantNew:       []string{"CVE-2|curl"},
+			wantInherited: []string{"CVE-1|openssl"},
+			wantFixed:     []string{"CVE-3|zlib"},
+		},
+		{
+			name:          "duplicates of several targets are counted once",
+			current:       []trivy.Vulnerability{openssl, opensslOtherTarget, curl, curl},
+			baseline:      []trivy.Vulnerability{openssl, openssl, zlib, zlib},
+			wantNew:       []string{"CVE-2|curl"},
+			wantInherited: []string{"CVE-1|openssl"},
+			wantFixed:     []string{"CVE-3|zlib"},
+		},
+		{
+			name:      "the same vulnerability in another package is new",
+			current:   []trivy.Vulnerability{libssl},
+			baseline:  []trivy.Vulnerability{openssl},
+			wantNew:   []string{"CVE-1|libssl"},
+			wantFixed: []string{"CVE-1|openssl"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			comparison := trivy.Compare(test.current, test.baseline)
+			if got := keys(comparison.New); !reflect.DeepEqual(got, test.wantNew) {
+				t.Errorf("got new %v, want %v", got, test.wantNew)
+			}
+			if got := keys(comparison.Inherited); !reflect.DeepEqual(got, test.wantInherited) {
+				t.Errorf("got inherited %v, want %v", got, test.wantInherited)
+			}
+			if got := keys(comparison.Fixed); !reflect.DeepEqual(got, test.wantFixed) {
+				t.Errorf("got fixed %v, want %v", got, test.wantFixed)
+			}
+		})
+	}
+}
+
+func TestWriteAndReadVulnerabilities(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vulnerabilities.json")
+	vulnerabilities := []trivy.Vulnerability{
+		{VulnerabilityID: "CVE-1", PkgName: "openssl", InstalledVersion: "3.0.0", FixedVersion: "3.0.1", Severity: "HIGH"},
+	}
+	if err := trivy.WriteVulnerabilities(file, vulnerabilities); err != nil {
+		t.Fatal(err)
+	}
+	read, err := trivy.ReadVulnerabilities(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, vulnerabilities) {
+		t.Errorf("got %+v, want %+v", read, vulnerabilities)
+	}
+}